@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,25 +21,86 @@ func main() {
 	filesDir := filepath.Join(workDir, "files")
 	FileServer(r, "/files", http.Dir(filesDir))
 
+	// Serve the same files from a sub-router mounted on /static, without
+	// repeating the mount path
+	static := chi.NewRouter()
+	FileServer(static, "/", http.Dir(filesDir))
+	r.Mount("/static", static)
+
+	// Serve the assets with hashed filenames for a year, while the pages are
+	// revalidated on every request
+	FileServerWithCache(r, "/assets", http.Dir(filesDir), map[string]string{
+		".js":   "public, max-age=31536000, immutable",
+		".css":  "public, max-age=31536000, immutable",
+		".html": "no-cache",
+		"*":     "public, max-age=3600",
+	})
+
 	http.ListenAndServe(":3333", r)
 }
 
 // FileServer conveniently sets up a http.FileServer handler to serve
-// static files from a http.FileSystem.
+// static files from a http.FileSystem. The `path` is relative to the router
+// `r`, which may be mounted on a sub-path, as the files are served along the
+// path matched by the wildcard of the route, whatever its prefix, ie. a mount
+// along "/users/{id}".
 func FileServer(r chi.Router, path string, root http.FileSystem) {
 	if strings.ContainsAny(path, "{}*") {
 		panic("FileServer does not permit URL parameters.")
 	}
 
-	fs := http.StripPrefix(path, http.FileServer(root))
-
 	if path != "/" && path[len(path)-1] != '/' {
-		r.Get(path, http.RedirectHandler(path+"/", 301).ServeHTTP)
+		r.Get(path, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, r.URL.Path+"/", 301)
+		})
 		path += "/"
 	}
 	path += "*"
 
-	r.Get(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fs.ServeHTTP(w, r)
-	}))
+	fs := http.FileServer(root)
+	r.Get(path, func(w http.ResponseWriter, r *http.Request) {
+		fs.ServeHTTP(w, filePathRequest(r, "/"+chi.URLParam(r, "*")))
+	})
+}
+
+// filePathRequest returns a shallow copy of the request `r` along the path
+// `upath`, which is escaped if the request was routed along its escaped path.
+func filePathRequest(r *http.Request, upath string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = upath
+	r2.URL.RawPath = ""
+	if r.URL.RawPath != "" {
+		if u, err := url.Parse(upath); err == nil {
+			r2.URL.Path = u.Path
+			r2.URL.RawPath = u.RawPath
+		}
+	}
+	return r2
+}
+
+// FileServerWithCache sets up a http.FileServer handler like FileServer, and
+// sets the Cache-Control header of the responses as per the `rules`, which map
+// file extensions such as ".js" to a Cache-Control value. The "*" rule applies
+// to the files matching no other rule, if any. The header is set ahead of the
+// file server, which still answers the conditional requests with If-Modified-Since
+// with a 304 Not Modified response, carrying the Cache-Control header.
+func FileServerWithCache(r chi.Router, path string, root http.FileSystem, rules map[string]string) {
+	r.Group(func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cc, ok := rules[strings.ToLower(filepath.Ext(r.URL.Path))]
+				if !ok {
+					cc, ok = rules["*"]
+				}
+				if ok {
+					w.Header().Set("Cache-Control", cc)
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+		FileServer(r, path, root)
+	})
 }
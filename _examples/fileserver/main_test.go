@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestFileServer(t *testing.T) {
+	r := chi.NewRouter()
+	FileServer(r, "/files", http.Dir("files"))
+
+	static := chi.NewRouter()
+	FileServer(static, "/", http.Dir("files"))
+	r.Mount("/static", static)
+
+	// The prefix of the files has a URL param, along the mount and the route
+	r.Route("/users/{id}", func(r chi.Router) {
+		FileServer(r, "/files", http.Dir("files"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/files/notes.txt", 200, "Notessszzz\n"},
+		{"/static/notes.txt", 200, "Notessszzz\n"},
+		{"/users/1/files/notes.txt", 200, "Notessszzz\n"},
+		{"/users/%31/files/notes.txt", 200, "Notessszzz\n"},
+		{"/users/1/files/nope.txt", 404, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts.URL+tt.path, "")
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The path of the files is redirected to the directory
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(ts.URL + "/users/1/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 301 || resp.Header.Get("Location") != "/users/1/files/" {
+		t.Fatalf("expecting a redirect to '/users/1/files/', got %d '%s'", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestFileServerWithCache(t *testing.T) {
+	fi, err := os.Stat("files/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file server is within a sub-router, along a mount with a URL param
+	files := chi.NewRouter()
+	FileServerWithCache(files, "/assets", http.Dir("files"), map[string]string{
+		".txt": "no-cache",
+		"*":    "public, max-age=3600",
+	})
+
+	r := chi.NewRouter()
+	r.Mount("/{version}/static", files)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts.URL+"/v1/static/assets/notes.txt", "")
+	if resp.StatusCode != 200 || body != "Notessszzz\n" {
+		t.Fatalf("expecting 200 with the file, got %d '%s'", resp.StatusCode, body)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("expecting the Cache-Control header of the .txt rule, got '%s'", cc)
+	}
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified != fi.ModTime().UTC().Format(http.TimeFormat) {
+		t.Fatalf("expecting the Last-Modified header of the file, got '%s'", lastModified)
+	}
+
+	// The conditional requests are answered by the file server, with the
+	// Cache-Control header
+	resp, body = testRequest(t, ts.URL+"/v1/static/assets/notes.txt", lastModified)
+	if resp.StatusCode != 304 || body != "" {
+		t.Fatalf("expecting 304 for an unmodified file, got %d '%s'", resp.StatusCode, body)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Fatalf("expecting the Cache-Control header on a 304, got '%s'", cc)
+	}
+
+	before := fi.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if resp, body := testRequest(t, ts.URL+"/v1/static/assets/notes.txt", before); resp.StatusCode != 200 || body != "Notessszzz\n" {
+		t.Fatalf("expecting 200 for a modified file, got %d '%s'", resp.StatusCode, body)
+	}
+
+	// The "*" rule applies to the other files
+	resp, _ = testRequest(t, ts.URL+"/v1/static/assets/", "")
+	if cc := resp.Header.Get("Cache-Control"); cc != "public, max-age=3600" {
+		t.Fatalf("expecting the Cache-Control header of the '*' rule, got '%s'", cc)
+	}
+}
+
+// testRequest sends a GET request to the `url`, with the If-Modified-Since
+// header `ifModifiedSince` if any, and returns the response with its body.
+func testRequest(t *testing.T, url string, ifModifiedSince string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ifModifiedSince != "" {
+		req.Header.Set("If-Modified-Since", ifModifiedSince)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
@@ -0,0 +1,45 @@
+// +build go1.7,!go1.8
+
+package chi
+
+import (
+	"io"
+	"net/http"
+)
+
+// newBeforeWriteWriter wraps the response writer `w` to call `fn` with the
+// wrapper and the request `r`, keeping the optional interfaces of `w`.
+func newBeforeWriteWriter(w http.ResponseWriter, r *http.Request, fn func(w http.ResponseWriter, r *http.Request)) http.ResponseWriter {
+	_, cn := w.(http.CloseNotifier)
+	_, fl := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+
+	bw := &beforeWriteWriter{ResponseWriter: w}
+	var ww http.ResponseWriter
+
+	if r.ProtoMajor == 2 {
+		if cn && fl {
+			ww = &beforeWriteHTTP2FancyWriter{bw}
+		}
+	} else {
+		_, rf := w.(io.ReaderFrom)
+		if cn && fl && hj && rf {
+			ww = &beforeWriteHTTPFancyWriter{bw}
+		}
+	}
+	if ww == nil {
+		switch {
+		case fl && hj:
+			ww = &beforeWriteFlushHijackWriter{bw}
+		case fl:
+			ww = &beforeWriteFlushWriter{bw}
+		case hj:
+			ww = &beforeWriteHijackWriter{bw}
+		default:
+			ww = bw
+		}
+	}
+
+	bw.fn = func() { fn(ww, r) }
+	return ww
+}
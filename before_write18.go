@@ -0,0 +1,52 @@
+// +build go1.8 appengine
+
+package chi
+
+import (
+	"io"
+	"net/http"
+)
+
+// newBeforeWriteWriter wraps the response writer `w` to call `fn` with the
+// wrapper and the request `r`, keeping the optional interfaces of `w`.
+func newBeforeWriteWriter(w http.ResponseWriter, r *http.Request, fn func(w http.ResponseWriter, r *http.Request)) http.ResponseWriter {
+	_, cn := w.(http.CloseNotifier)
+	_, fl := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+
+	bw := &beforeWriteWriter{ResponseWriter: w}
+	var ww http.ResponseWriter
+
+	if r.ProtoMajor == 2 {
+		_, ps := w.(http.Pusher)
+		if cn && fl && ps {
+			ww = &beforeWriteHTTP2FancyWriter{bw}
+		}
+	} else {
+		_, rf := w.(io.ReaderFrom)
+		if cn && fl && hj && rf {
+			ww = &beforeWriteHTTPFancyWriter{bw}
+		}
+	}
+	if ww == nil {
+		switch {
+		case fl && hj:
+			ww = &beforeWriteFlushHijackWriter{bw}
+		case fl:
+			ww = &beforeWriteFlushWriter{bw}
+		case hj:
+			ww = &beforeWriteHijackWriter{bw}
+		default:
+			ww = bw
+		}
+	}
+
+	bw.fn = func() { fn(ww, r) }
+	return ww
+}
+
+func (f *beforeWriteHTTP2FancyWriter) Push(target string, opts *http.PushOptions) error {
+	return f.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+var _ http.Pusher = &beforeWriteHTTP2FancyWriter{}
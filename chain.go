@@ -1,6 +1,11 @@
 package chi
 
-import "net/http"
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"time"
+)
 
 // Chain returns a Middlewares type from a slice of middleware handlers.
 func Chain(middlewares ...func(http.Handler) http.Handler) Middlewares {
@@ -8,14 +13,20 @@ func Chain(middlewares ...func(http.Handler) http.Handler) Middlewares {
 }
 
 // Handler builds and returns a http.Handler from the chain of middlewares,
-// with `h http.Handler` as the final handler.
+// with `h http.Handler` as the final handler. An empty chain returns `h` as is.
 func (mws Middlewares) Handler(h http.Handler) http.Handler {
+	if len(mws) == 0 {
+		return h
+	}
 	return &ChainHandler{mws, h, chain(mws, h)}
 }
 
 // HandlerFunc builds and returns a http.Handler from the chain of middlewares,
-// with `h http.Handler` as the final handler.
+// with `h http.Handler` as the final handler. An empty chain returns `h` as is.
 func (mws Middlewares) HandlerFunc(h http.HandlerFunc) http.Handler {
+	if len(mws) == 0 {
+		return h
+	}
 	return &ChainHandler{mws, h, chain(mws, h)}
 }
 
@@ -47,3 +58,54 @@ func chain(middlewares []func(http.Handler) http.Handler, endpoint http.Handler)
 
 	return h
 }
+
+// timeMiddlewares wraps each of the middlewares `mws` to record the time spent
+// in it on the routing context of the request, see WithMiddlewareTiming.
+func timeMiddlewares(mws []func(http.Handler) http.Handler) []func(http.Handler) http.Handler {
+	timed := make([]func(http.Handler) http.Handler, len(mws))
+	for i, mw := range mws {
+		timed[i] = timeMiddleware(mw)
+	}
+	return timed
+}
+
+func timeMiddleware(mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	name := runtime.FuncForPC(reflect.ValueOf(mw).Pointer()).Name()
+	return func(next http.Handler) http.Handler {
+		// Identifies the timings of this chain, which measure the time spent
+		// in the next handler to exclude it
+		id := new(int)
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx, _ := r.Context().Value(RouteCtxKey).(*Context)
+			if rctx == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// The timing of this chain is the latest one as the middleware
+			// calls its next handler, before the next middlewares add theirs
+			i := len(rctx.middlewareTimings) - 1
+			if i < 0 || rctx.middlewareTimings[i].id != id {
+				i = rctx.middlewareTiming(id)
+			}
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			if i >= 0 {
+				rctx.middlewareTimings[i].next += time.Since(start)
+			}
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx, _ := r.Context().Value(RouteCtxKey).(*Context)
+			if rctx == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			i := len(rctx.middlewareTimings)
+			rctx.middlewareTimings = append(rctx.middlewareTimings, MiddlewareTiming{Name: name, id: id})
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			t := &rctx.middlewareTimings[i]
+			t.Duration = time.Since(start) - t.next
+		})
+	}
+}
@@ -35,6 +35,26 @@
 // up to the next / or the end of the URL. Trailing slashes on paths must
 // be handled explicitly.
 //
+// A placeholder followed by other characters than / within a segment, such
+// as {name} in "/images/{name}.{ext}", matches up to the first occurrence of
+// the character that follows it, ie. the first dot. When the rest of the URL
+// doesn't match from there, the placeholder extends to the next occurrence,
+// and so on. Thus {ext} is "tar.gz" for "/images/archive.tar.gz", while a
+// regular expression placeholder like {ext:[a-z0-9]+} makes {name}
+// "archive.tar" and {ext} "gz".
+//
+// Paths are matched as is, without cleaning up empty segments. An empty
+// segment in a pattern, such as "/a//b", only matches the same empty segment
+// in the URL, and a placeholder matches an empty segment when it's followed
+// by more of the URL, such as "/a/{name}/b" for "/a//b". A URL with empty
+// segments doesn't otherwise match, ie. "/a/b" matches neither "/a//b" nor
+// "//a/b".
+//
+// A placeholder with a name followed by an equal sign sets a default value
+// for the last segment of a pattern, for example {page=1}, which makes the
+// segment optional. The pattern "/articles/{page=1}" also matches "/articles",
+// in which case {page} is "1".
+//
 // A placeholder with a name followed by a colon allows a regular
 // expression match, for example {number:\\d+}. The regular expression
 // syntax is Go's normal regexp RE2 syntax, except that regular expressions
@@ -42,16 +62,31 @@
 // matched. An anonymous regexp pattern is allowed, using an empty string
 // before the colon in the placeholder, such as {:\\d+}
 //
+// The "int" type hint in place of a regular expression, such as {id:int},
+// matches a decimal integer like {id:[0-9]+}, whose value is parsed once the
+// route matches and returned by URLParamInt.
+//
 // The special placeholder of asterisk matches the rest of the requested
-// URL. Any trailing characters in the pattern are ignored. This is the only
-// placeholder which will match / characters.
+// URL. Any trailing characters in the pattern are ignored, unless the asterisk
+// is followed by a slash and more static segments, in which case it matches
+// one or more characters up to the trailing segments. When the trailing
+// segments occur more than once in the URL, the asterisk matches the longest
+// possible value, and routes with trailing segments take precedence over a
+// plain asterisk at the same position. It can only be used once in a pattern,
+// and this is the only placeholder which will match / characters. A trailing
+// asterisk doesn't match the base path of the pattern by default, ie. "/page/*"
+// doesn't match "/page", unless the mux is created WithEmptyWildcard().
 //
 // Examples:
 //  "/user/{name}" matches "/user/jsmith" but not "/user/jsmith/info" or "/user/jsmith/"
-//  "/user/{name}/info" matches "/user/jsmith/info"
+//  "/user/{name}/info" matches "/user/jsmith/info", and "/user//info" where {name} is empty
 //  "/page/*" matches "/page/intro/latest"
-//  "/page/*/index" also matches "/page/intro/latest"
+//  "/page/*/index" matches "/page/intro/latest/index" but not "/page/intro/latest"
+//  "/files/*/meta" matches "/files/a/meta/b/meta", where * is "a/meta/b"
+//  "/images/{name}.{ext}" matches "/images/photo.jpg", and "/images/archive.tar.gz" where {ext} is "tar.gz"
+//  "/images/{name}.png" matches "/images/photo.2x.png", where {name} is "photo.2x"
 //  "/date/{yyyy:\\d\\d\\d\\d}/{mm:\\d\\d}/{dd:\\d\\d}" matches "/date/2017/04/01"
+//  "/users/{id:int}" matches "/users/42" but not "/users/me"
 //
 package chi
 
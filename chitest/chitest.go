@@ -0,0 +1,113 @@
+// Package chitest provides helpers to test the routing of chi routers.
+package chitest
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// TestingT is the interface of *testing.T used by the helpers of the package.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// RouteExpectation is a route expected by AssertRoutes, made of the http
+// method and the full routing pattern of the route, ie. "GET" and
+// "/admin/users/{id}" for a route of a router mounted along "/admin". The
+// method of a route handling any method, defined with Handle or HandleFunc,
+// is "*".
+type RouteExpectation struct {
+	Method  string
+	Pattern string
+}
+
+func (e RouteExpectation) String() string {
+	return e.Method + " " + e.Pattern
+}
+
+// AssertRoutes checks the routes of the router `r` and its sub-routers are
+// exactly the `expected` ones, and fails the test with the missing and the
+// unexpected routes otherwise. It reports whether the routes match.
+func AssertRoutes(t TestingT, r chi.Routes, expected []RouteExpectation) bool {
+	missing, unexpected := diffRoutes(RouteExpectations(r), expected)
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return true
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("chitest: the routes don't match the expected routes:")
+	for _, e := range missing {
+		fmt.Fprintf(&buf, "\n\t- %s", e)
+	}
+	for _, e := range unexpected {
+		fmt.Fprintf(&buf, "\n\t+ %s", e)
+	}
+	t.Errorf("%s", buf.String())
+	return false
+}
+
+// RouteExpectations returns the routes of the router `r` and its sub-routers,
+// sorted by pattern and method, in the form expected by AssertRoutes.
+func RouteExpectations(r chi.Routes) []RouteExpectation {
+	routes := routeExpectations(r, "")
+	sort.Sort(byPatternMethod(routes))
+	return routes
+}
+
+func routeExpectations(r chi.Routes, prefix string) []RouteExpectation {
+	var routes []RouteExpectation
+	for _, route := range r.Routes() {
+		if route.SubRoutes != nil {
+			subPrefix := prefix + strings.TrimSuffix(route.Pattern, "/*")
+			routes = append(routes, routeExpectations(route.SubRoutes, subPrefix)...)
+			continue
+		}
+
+		// The route of any method also has a handler for each of them
+		if route.Handlers["*"] != nil {
+			routes = append(routes, RouteExpectation{"*", prefix + route.Pattern})
+			continue
+		}
+		for method := range route.Handlers {
+			routes = append(routes, RouteExpectation{method, prefix + route.Pattern})
+		}
+	}
+	return routes
+}
+
+// diffRoutes returns the sorted `expected` routes missing from the `actual`
+// routes, and the `actual` routes which aren't expected.
+func diffRoutes(actual, expected []RouteExpectation) (missing, unexpected []RouteExpectation) {
+	set := make(map[RouteExpectation]bool, len(actual))
+	for _, e := range actual {
+		set[e] = true
+	}
+	for _, e := range expected {
+		e.Method = strings.ToUpper(e.Method)
+		if !set[e] {
+			missing = append(missing, e)
+		}
+		delete(set, e)
+	}
+	for e := range set {
+		unexpected = append(unexpected, e)
+	}
+	sort.Sort(byPatternMethod(missing))
+	sort.Sort(byPatternMethod(unexpected))
+	return missing, unexpected
+}
+
+type byPatternMethod []RouteExpectation
+
+func (s byPatternMethod) Len() int      { return len(s) }
+func (s byPatternMethod) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byPatternMethod) Less(i, j int) bool {
+	if s[i].Pattern != s[j].Pattern {
+		return s[i].Pattern < s[j].Pattern
+	}
+	return s[i].Method < s[j].Method
+}
@@ -0,0 +1,64 @@
+package chitest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRoutes(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	admin := chi.NewRouter()
+	admin.Get("/users/{id}", h)
+	admin.Delete("/users/{id}", h)
+
+	r := chi.NewRouter()
+	r.Get("/", h)
+	r.HandleFunc("/ping", h)
+	r.Route("/articles", func(r chi.Router) {
+		r.Post("/", h)
+	})
+	r.Mount("/admin", admin)
+
+	expected := []RouteExpectation{
+		{"GET", "/"},
+		{"*", "/ping"},
+		{"post", "/articles/"},
+		{"GET", "/admin/users/{id}"},
+		{"DELETE", "/admin/users/{id}"},
+	}
+	if !AssertRoutes(t, r, expected) {
+		t.Fatalf("expecting the routes to match")
+	}
+
+	rt := &recordingT{}
+	ok := AssertRoutes(rt, r, []RouteExpectation{
+		{"GET", "/"},
+		{"*", "/ping"},
+		{"PUT", "/articles/"},
+		{"GET", "/admin/users/{id}"},
+		{"GET", "/admin/users"},
+	})
+	if ok {
+		t.Fatalf("expecting the routes not to match")
+	}
+	want := "chitest: the routes don't match the expected routes:" +
+		"\n\t- GET /admin/users" +
+		"\n\t- PUT /articles/" +
+		"\n\t+ DELETE /admin/users/{id}" +
+		"\n\t+ POST /articles/"
+	if len(rt.errors) != 1 || rt.errors[0] != want {
+		t.Fatalf("expecting the error:\n%s\ngot %q", want, rt.errors)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -18,6 +20,10 @@ var (
 type Context struct {
 	Routes Routes
 
+	// The innermost mux serving the request, which responds to the errors of
+	// the request, see Error
+	mux *Mux
+
 	// Routing path/method override used during the route search.
 	// See Mux#routeHTTP method.
 	RoutePath   string
@@ -44,6 +50,72 @@ type Context struct {
 
 	// methodNotAllowed hint
 	methodNotAllowed bool
+
+	// methodNotAllowedNode is the tree node matching the routing path, which
+	// has no handler for the routing method
+	methodNotAllowedNode *node
+
+	// routed is set once a handler for the request has been found
+	routed bool
+
+	// chainedHandler is the handler of the request ending the middleware
+	// chains built once, being the matched route once rewritten by the route
+	// rewriters or the miss handler, see serveChained
+	chainedHandler http.Handler
+
+	// Route configuration of the matched routes, see RouteConfig
+	routeConfig map[string]interface{}
+
+	// The original request served by the root router, see Request
+	request *http.Request
+
+	// Path prefix consumed by the mounts of the sub-routers, see MountPrefix
+	mountPrefix string
+
+	// Headers of the request being routed, matched by the routes requiring
+	// header values, or nil to ignore the required headers, see
+	// Mux#RequireHeader
+	requestHeader http.Header
+
+	// Time spent in each middleware, see WithMiddlewareTiming
+	middlewareTimings []MiddlewareTiming
+
+	// Tree node of the matched route, and the Route built from it on demand,
+	// see RouteFromCtx
+	routeNode *node
+	route     *Route
+
+	// Matching route of highest priority while searching the routing tree,
+	// see Mux#Priority
+	routeCandidate *routeCandidate
+
+	// Reason the request matched no route, and the tree node matching the
+	// longest prefix of the routing path along with the rest of the path,
+	// recorded while searching the tree, see WithDebugMatch
+	matchError *MatchError
+	debugMatch bool
+	matchNode  *node
+	matchRest  string
+
+	// Copy of the request served with the routing context, and its
+	// context.Context, reused across requests, see WithPooledRequests
+	pooledRequest http.Request
+	pooledCtx     requestContext
+}
+
+// requestContext is the context.Context of a request holding its routing
+// context, which is reused along with the routing context instead of a
+// context.WithValue, see WithPooledRequests.
+type requestContext struct {
+	context.Context
+	rctx *Context
+}
+
+func (c *requestContext) Value(key interface{}) interface{} {
+	if key == RouteCtxKey {
+		return c.rctx
+	}
+	return c.Context.Value(key)
 }
 
 // NewRouteContext returns a new routing Context object.
@@ -54,16 +126,72 @@ func NewRouteContext() *Context {
 // Reset a routing context to its initial state.
 func (x *Context) Reset() {
 	x.Routes = nil
+	x.mux = nil
 	x.RoutePath = ""
 	x.RouteMethod = ""
 	x.RoutePatterns = x.RoutePatterns[:0]
 	x.URLParams.Keys = x.URLParams.Keys[:0]
 	x.URLParams.Values = x.URLParams.Values[:0]
+	x.URLParams.ints = x.URLParams.ints[:0]
 
 	x.routePattern = ""
 	x.routeParams.Keys = x.routeParams.Keys[:0]
 	x.routeParams.Values = x.routeParams.Values[:0]
 	x.methodNotAllowed = false
+	x.methodNotAllowedNode = nil
+	x.routed = false
+	x.chainedHandler = nil
+	x.routeConfig = nil
+	x.request = nil
+	x.mountPrefix = ""
+	x.requestHeader = nil
+	x.middlewareTimings = x.middlewareTimings[:0]
+	x.routeNode = nil
+	x.route = nil
+	x.routeCandidate = nil
+	x.matchError = nil
+	x.debugMatch = false
+	x.matchNode = nil
+	x.matchRest = ""
+}
+
+// Detach returns a copy of the routing context that remains valid once the
+// request is served, unlike the context itself which is recycled for other
+// requests. It's meant for the goroutines of a handler that outlive the
+// request, to read its URL params and routing pattern:
+//
+//   rctx := chi.RouteContext(r.Context()).Detach()
+//   go func() {
+//     process(rctx.URLParam("id"), rctx.RoutePattern())
+//   }()
+//
+// The copy is taken when Detach is called, so it doesn't reflect the routing
+// of the request that happens afterwards, ie. in a middleware ahead of the
+// sub-routers. A context.Context with the copy, as returned by
+// context.WithValue(ctx, chi.RouteCtxKey, rctx), works with URLParamFromCtx.
+func (x *Context) Detach() *Context {
+	c := &Context{
+		Routes:           x.Routes,
+		RoutePath:        x.RoutePath,
+		RouteMethod:      x.RouteMethod,
+		RoutePatterns:    append([]string(nil), x.RoutePatterns...),
+		routePattern:     x.routePattern,
+		methodNotAllowed: x.methodNotAllowed,
+		routed:           x.routed,
+		routeConfig:      x.routeConfig,
+		request:          x.request,
+		mountPrefix:      x.mountPrefix,
+		routeNode:        x.routeNode,
+		route:            x.route,
+		matchError:       x.matchError,
+	}
+	c.URLParams.Keys = append([]string(nil), x.URLParams.Keys...)
+	c.URLParams.Values = append([]string(nil), x.URLParams.Values...)
+	c.URLParams.ints = append([]intParam(nil), x.URLParams.ints...)
+	c.routeParams.Keys = append([]string(nil), x.routeParams.Keys...)
+	c.routeParams.Values = append([]string(nil), x.routeParams.Values...)
+	c.middlewareTimings = append([]MiddlewareTiming(nil), x.middlewareTimings...)
+	return c
 }
 
 // URLParam returns the corresponding URL parameter value from the request
@@ -77,6 +205,149 @@ func (x *Context) URLParam(key string) string {
 	return ""
 }
 
+// Routed reports whether the request was matched to a route handler, across
+// all of the sub-routers the request passed through. It's meant to be checked
+// after calling the next handler, for example to skip logging 404's.
+func (x *Context) Routed() bool {
+	return x.routed
+}
+
+// Request returns the original request as received by the root router, before
+// any middleware replaced it, ie. to recover the initial URL of a request whose
+// path was rewritten. It returns nil if the routing context was not created by a
+// router. The returned request must not be modified.
+func (x *Context) Request() *http.Request {
+	return x.request
+}
+
+// MountPrefix returns the path prefix consumed by the routers mounting the
+// current sub-router, accumulated across nested mounts, ie. "/api/v1" for a
+// sub-router mounted along "/v1" in a router mounted along "/api". The prefix
+// is the actual request path, with the values of any URL parameters in the
+// mount patterns. It's empty for the requests served by the root router.
+func (x *Context) MountPrefix() string {
+	return x.mountPrefix
+}
+
+// MiddlewareTimings returns the time spent in each middleware of the routers
+// created with WithMiddlewareTiming, in the order the middlewares were called.
+// Like RoutePattern, it's meant to be checked after calling the next handler,
+// once the inner middlewares returned. The returned slice must not be modified.
+func (x *Context) MiddlewareTimings() []MiddlewareTiming {
+	return x.middlewareTimings
+}
+
+// MiddlewareTiming is the time spent in a middleware while serving a request,
+// see WithMiddlewareTiming.
+type MiddlewareTiming struct {
+	// Name of the middleware function, ie. "github.com/go-chi/chi/middleware.Logger",
+	// or the name of the function returning a middleware closure followed by
+	// a suffix such as ".func1".
+	Name string
+
+	// Duration spent in the middleware, excluding the next handler.
+	Duration time.Duration
+
+	// The timed middleware, and the time spent in its next handler
+	id   *int
+	next time.Duration
+}
+
+// middlewareTiming returns the index of the latest timing of the middleware
+// `id`, or -1 if it has none.
+func (x *Context) middlewareTiming(id *int) int {
+	for i := len(x.middlewareTimings) - 1; i >= 0; i-- {
+		if x.middlewareTimings[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// MatchError returns the reason the request matched no route of a router
+// created with WithDebugMatch, or nil if it was routed. It's meant for the
+// NotFound and MethodNotAllowed handlers, to log why a request wasn't routed.
+func (x *Context) MatchError() *MatchError {
+	return x.matchError
+}
+
+// MatchReason is the reason a request matched no route, see MatchError.
+type MatchReason int
+
+const (
+	// MatchNoRoute is the reason of a routing path matched by no route, not
+	// even partially.
+	MatchNoRoute MatchReason = iota
+
+	// MatchPartialPath is the reason of a routing path whose prefix matches a
+	// route, but not the rest of the path.
+	MatchPartialPath
+
+	// MatchWrongMethod is the reason of a routing path matching a route which
+	// doesn't handle the routing method.
+	MatchWrongMethod
+
+	// MatchWrongHeaders is the reason of a routing path and method matching a
+	// route which requires other header values, see Mux#RequireHeader.
+	MatchWrongHeaders
+)
+
+// MatchError describes why a request matched no route of a router created with
+// WithDebugMatch, see Context.MatchError.
+type MatchError struct {
+	Reason MatchReason
+
+	// Routing method and path of the request, relative to the router, see
+	// MountPrefix
+	Method string
+	Path   string
+
+	// Longest prefix of the path matched by a route, and the pattern of the
+	// route, for MatchPartialPath. The pattern of the route matching the path
+	// for MatchWrongMethod and MatchWrongHeaders.
+	Prefix  string
+	Pattern string
+}
+
+func (e *MatchError) Error() string {
+	route := e.Method + " " + e.Path
+	switch e.Reason {
+	case MatchPartialPath:
+		return "chi: the route '" + e.Pattern + "' matches '" + e.Prefix + "' but not the rest of " + route
+	case MatchWrongMethod:
+		return "chi: the route '" + e.Pattern + "' matches the path but not the method of " + route
+	case MatchWrongHeaders:
+		return "chi: the route '" + e.Pattern + "' matches " + route + " but not the request headers"
+	default:
+		return "chi: no route matches " + route
+	}
+}
+
+// RouteConfig returns the configuration key/values of the matched route, as
+// registered with the WithConfig() method of a router. The configuration of the
+// routes matched along a stack of sub-routers is merged, where the deeper routes
+// take precedence. The returned map must not be modified.
+func (x *Context) RouteConfig() map[string]interface{} {
+	return x.routeConfig
+}
+
+// RouteFromCtx returns the Route matched by the request of the context `ctx`,
+// or nil if the request isn't routed yet, or no route matched. The Route has
+// the full routing pattern across sub-routers, as per RoutePattern, along with
+// the handlers of the route by http method. For a request routed to a router
+// mounted along the route, the Route is the mount of that router until the
+// request is routed by it. The returned Route must not be modified.
+func RouteFromCtx(ctx context.Context) *Route {
+	rctx, _ := ctx.Value(RouteCtxKey).(*Context)
+	if rctx == nil || rctx.routeNode == nil {
+		return nil
+	}
+	if rctx.route == nil {
+		rctx.route = rctx.routeNode.route(rctx.routePattern, rctx.RoutePattern())
+	}
+	return rctx.route
+}
+
 // RoutePattern builds the routing pattern string for the particular
 // request, at the particular point during routing. This means, the value
 // will change throughout the execution of a request in a router. That is
@@ -92,8 +363,20 @@ func (x *Context) URLParam(key string) string {
 //   	 })
 //   }
 func (x *Context) RoutePattern() string {
-	routePattern := strings.Join(x.RoutePatterns, "")
-	return strings.Replace(routePattern, "/*/", "/", -1)
+	var routePattern string
+	for i, pattern := range x.RoutePatterns {
+		// Trim the wildcard or trailing slash of the patterns mounting a
+		// sub-router, whose patterns begin with a slash
+		if i < len(x.RoutePatterns)-1 {
+			if strings.HasSuffix(pattern, "/*") {
+				pattern = pattern[:len(pattern)-2]
+			} else if strings.HasSuffix(pattern, "/") {
+				pattern = pattern[:len(pattern)-1]
+			}
+		}
+		routePattern += pattern
+	}
+	return routePattern
 }
 
 // RouteContext returns chi's routing Context object from a
@@ -110,6 +393,35 @@ func URLParam(r *http.Request, key string) string {
 	return ""
 }
 
+// URLParamInt returns the url parameter from a http.Request object as an int,
+// and whether it's a valid decimal integer. The value of a param with the int
+// type hint, ie. "{id:int}", is parsed once when the route matches, so that
+// it's returned without parsing it again.
+func URLParamInt(r *http.Request, key string) (int, bool) {
+	if rctx := RouteContext(r.Context()); rctx != nil {
+		return rctx.URLParamInt(key)
+	}
+	return 0, false
+}
+
+// URLParamInt returns the corresponding URL parameter value from the request
+// routing context as an int, see URLParamInt.
+func (x *Context) URLParamInt(key string) (int, bool) {
+	for k := len(x.URLParams.Keys) - 1; k >= 0; k-- {
+		if x.URLParams.Keys[k] != key {
+			continue
+		}
+		for _, p := range x.URLParams.ints {
+			if p.index == k {
+				return p.value, true
+			}
+		}
+		v, err := strconv.Atoi(x.URLParams.Values[k])
+		return v, err == nil
+	}
+	return 0, false
+}
+
 // URLParamFromCtx returns the url parameter from a http.Request Context.
 func URLParamFromCtx(ctx context.Context, key string) string {
 	if rctx := RouteContext(ctx); rctx != nil {
@@ -121,6 +433,15 @@ func URLParamFromCtx(ctx context.Context, key string) string {
 // RouteParams is a structure to track URL routing parameters efficiently.
 type RouteParams struct {
 	Keys, Values []string
+
+	// The parsed values of the params with the int type hint, see URLParamInt
+	ints []intParam
+}
+
+// intParam is the parsed value of the param at `index` in the RouteParams.
+type intParam struct {
+	index int
+	value int
 }
 
 // Add will append a URL parameter to the end of the route param
@@ -129,6 +450,35 @@ func (s *RouteParams) Add(key, value string) {
 	(*s).Values = append((*s).Values, value)
 }
 
+// Len returns the number of URL parameters. Note that the wildcard of each
+// router mounted along the route counts as a "*" parameter.
+func (s *RouteParams) Len() int {
+	return len(s.Keys)
+}
+
+// Range calls `fn` for each URL parameter in the order they were added, ie.
+// their order in the request path, until `fn` returns false.
+func (s *RouteParams) Range(fn func(key, value string) bool) {
+	for i, key := range s.Keys {
+		if !fn(key, s.Values[i]) {
+			return
+		}
+	}
+}
+
+// mergeRouteConfig returns a new route configuration map with the key/values
+// of `b` set over the ones of `a`.
+func mergeRouteConfig(a, b map[string]interface{}) map[string]interface{} {
+	cfg := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range a {
+		cfg[k] = v
+	}
+	for k, v := range b {
+		cfg[k] = v
+	}
+	return cfg
+}
+
 // ServerBaseContext wraps an http.Handler to set the request context to the
 // `baseCtx`.
 func ServerBaseContext(baseCtx context.Context, h http.Handler) http.Handler {
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+)
+
+var (
+	// CapturedBodyCtxKey is the context.Context key to store the request body
+	// captured by the CaptureBody middleware.
+	CapturedBodyCtxKey = &contextKey{"CapturedBody"}
+)
+
+// CaptureBody is a middleware that captures up to `maxBytes` of the request
+// body and stores them on the context under the key
+// `middleware.CapturedBodyCtxKey`, ie. to log or audit request bodies. The
+// request body is restored with the captured bytes, so the handlers can still
+// read all of it.
+//
+// A body over the limit is only captured up to `maxBytes`, while the remainder
+// is streamed to the handlers untouched, as read from the client.
+//
+//  r := chi.NewRouter()
+//  r.Use(middleware.CaptureBody(4096))
+//  r.Post("/articles", func(w http.ResponseWriter, r *http.Request) {
+//    log.Printf("body: %s", middleware.GetCapturedBody(r.Context()))
+//  })
+func CaptureBody(maxBytes int) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || maxBytes <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Capture the body as it's read, up to the limit, so a small body
+			// doesn't cost a buffer of `maxBytes`
+			var buf bytes.Buffer
+			_, err := buf.ReadFrom(io.LimitReader(r.Body, int64(maxBytes)))
+			body := buf.Bytes()
+
+			// Restore the body with the captured bytes, followed by the read
+			// error if any, or the remainder of the original body.
+			var rest io.Reader = r.Body
+			if err != nil {
+				rest = &errReader{err}
+			}
+			r.Body = &restoredBody{io.MultiReader(bytes.NewReader(body), rest), r.Body}
+
+			ctx := context.WithValue(r.Context(), CapturedBodyCtxKey, body)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetCapturedBody returns the request body captured by the CaptureBody
+// middleware, which is at most the configured number of bytes.
+func GetCapturedBody(ctx context.Context) []byte {
+	if ctx == nil {
+		return nil
+	}
+	if body, ok := ctx.Value(CapturedBodyCtxKey).([]byte); ok {
+		return body
+	}
+	return nil
+}
+
+// errReader is a reader that returns the error of a failed read.
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCaptureBody(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(CaptureBody(8))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(string(GetCapturedBody(r.Context())) + "|" + string(body)))
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(string(GetCapturedBody(r.Context())) + "|"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   string
+	}{
+		{"empty body", "GET", "", "|"},
+		{"small body", "POST", "hello", "hello|hello"},
+		{"exact body", "POST", "12345678", "12345678|12345678"},
+		{"oversized body", "POST", "hello, world!", "hello, w|hello, world!"},
+	}
+
+	for _, tt := range tests {
+		_, body := testRequest(t, ts, tt.method, "/", strings.NewReader(tt.body))
+		if body != tt.want {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.name, tt.want, body)
+		}
+	}
+}
+
+func TestCaptureBodyLargeLimit(t *testing.T) {
+	var captured string
+	h := CaptureBody(1 << 30)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured = string(GetCapturedBody(r.Context()))
+	}))
+
+	// The captured bytes are buffered as they're read, rather than in a buffer
+	// of the limit
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	before := ms.TotalAlloc
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", strings.NewReader("hello")))
+	runtime.ReadMemStats(&ms)
+	if n := ms.TotalAlloc - before; n > 1<<20 {
+		t.Fatalf("expecting a small body to allocate little, got %d bytes", n)
+	}
+	if captured != "hello" {
+		t.Fatalf("expecting 'hello', got '%s'", captured)
+	}
+}
@@ -8,15 +8,13 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 )
 
 var encoders = map[string]EncoderFunc{}
 
-var acceptEncodingAlgorithmsRe = regexp.MustCompile(`([a-z]{2,}|\*)`)
-
 func init() {
 	// TODO:
 	// lzma: Opera.
@@ -136,31 +134,77 @@ func Compress(level int, types ...string) func(next http.Handler) http.Handler {
 	}
 }
 
+// selectEncoder returns the encoder of the most preferred encoding accepted by
+// the Accept-Encoding header `h`, as per its quality values, or else as per the
+// performance of the encodings. An encoding with a zero quality value is never
+// selected, and the "*" wildcard stands for the encodings not otherwise listed.
 func selectEncoder(h http.Header) (EncoderFunc, string) {
 	header := h.Get("Accept-Encoding")
 
 	// Parse the names of all accepted algorithms from the header.
-	var accepted []string
-	for _, m := range acceptEncodingAlgorithmsRe.FindAllStringSubmatch(header, -1) {
-		accepted = append(accepted, m[1])
+	var accepted byPreference
+	listed := map[string]bool{}
+	wildcard := 0.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		q, ok := 1.0, true
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				q, err = strconv.ParseFloat(param[2:], 64)
+				ok = err == nil
+			}
+		}
+		if !ok {
+			continue
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		listed[name] = true
+		if q > 0 {
+			accepted = append(accepted, acceptedEncoding{name, q})
+		}
+	}
+	if wildcard > 0 {
+		for name := range encoders {
+			if !listed[name] {
+				accepted = append(accepted, acceptedEncoding{name, wildcard})
+			}
+		}
 	}
 
-	sort.Sort(byPerformance(accepted))
+	sort.Sort(accepted)
 
 	// Select the first mutually supported algorithm.
-	for _, name := range accepted {
-		if fn, ok := encoders[name]; ok {
-			return fn, name
+	for _, a := range accepted {
+		if fn, ok := encoders[a.name]; ok {
+			return fn, a.name
 		}
 	}
 	return nil, ""
 }
 
-type byPerformance []string
+type acceptedEncoding struct {
+	name string
+	q    float64
+}
+
+type byPreference []acceptedEncoding
+
+func (l byPreference) Len() int      { return len(l) }
+func (l byPreference) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l byPreference) Less(i, j int) bool {
+	if l[i].q != l[j].q {
+		return l[i].q > l[j].q
+	}
 
-func (l byPerformance) Len() int      { return len(l) }
-func (l byPerformance) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
-func (l byPerformance) Less(i, j int) bool {
 	// Higher number = higher preference. This causes unknown names, which map
 	// to 0, to always be less prefered.
 	scores := map[string]int{
@@ -168,7 +212,10 @@ func (l byPerformance) Less(i, j int) bool {
 		"gzip":    2,
 		"deflate": 1,
 	}
-	return scores[l[i]] > scores[l[j]]
+	if scores[l[i].name] != scores[l[j].name] {
+		return scores[l[i].name] > scores[l[j].name]
+	}
+	return l[i].name < l[j].name
 }
 
 type maybeCompressResponseWriter struct {
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressEncodingNegotiation(t *testing.T) {
+	// A stand-in for a Brotli encoder, which isn't in the standard library
+	SetEncoder("br", func(w http.ResponseWriter, level int) io.Writer {
+		return w
+	})
+	defer delete(encoders, "br")
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip, deflate", "gzip"},
+		{"deflate;q=0.9, gzip;q=0.8", "deflate"},
+		{"GZIP ; q=0.5", "gzip"},
+		{"gzip;q=oops, deflate", "deflate"},
+		{"*", "br"},
+		{"br;q=0, *;q=0.1", "gzip"},
+		{"*;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set("Accept-Encoding", tt.acceptEncoding)
+		if _, encoding := selectEncoder(h); encoding != tt.encoding {
+			t.Errorf("'%s': expecting encoding '%s', got '%s'", tt.acceptEncoding, tt.encoding, encoding)
+		}
+	}
+
+	h := Compress(5)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello"))
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("expecting br Content-Encoding, got '%s'", w.Header().Get("Content-Encoding"))
+	}
+}
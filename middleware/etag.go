@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ETag is a middleware that buffers the 2xx responses to GET requests up to
+// 1MB, and sets their ETag header to a weak SHA-1 based entity tag of the body,
+// unless the handler already set one. A 304 Not Modified is sent instead of the
+// body when the If-None-Match request header matches the entity tag.
+func ETag(next http.Handler) http.Handler {
+	return ETagWith(1<<20, sha1.New)(next)
+}
+
+// ETagWith is a middleware like ETag, that hashes the body with the hash of
+// `newHash`, and buffers up to `maxBytes` of the body. A response over the
+// `maxBytes`, or which is flushed by the handler, is streamed to the client
+// as is, without an ETag header.
+func ETagWith(maxBytes int, newHash func() hash.Hash) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// The body of a HEAD response is empty, so is its hash
+			if r.Method != "GET" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ew := &etagResponseWriter{ResponseWriter: w, hash: newHash(), maxBytes: maxBytes}
+			next.ServeHTTP(ew, r)
+			if ew.streaming {
+				return
+			}
+
+			if !ew.wroteHeader {
+				ew.code = http.StatusOK
+			}
+			if ew.code < 200 || ew.code > 299 || w.Header().Get("ETag") != "" {
+				ew.stream()
+				return
+			}
+
+			etag := `W/"` + hex.EncodeToString(ew.hash.Sum(nil)) + `"`
+			w.Header().Set("ETag", etag)
+			if etagMatch(r.Header.Get("If-None-Match"), etag) {
+				h := w.Header()
+				h.Del("Content-Type")
+				h.Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			ew.stream()
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// etagMatch reports whether the If-None-Match header value `inm` matches the
+// `etag`, with the weak comparison of RFC 7232.
+func etagMatch(inm, etag string) bool {
+	for _, v := range strings.Split(inm, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// etagResponseWriter buffers the response, and hashes its body, until it's
+// streamed to the client.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	hash        hash.Hash
+	buf         bytes.Buffer
+	maxBytes    int
+	code        int
+	wroteHeader bool
+	streaming   bool
+}
+
+func (w *etagResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	if w.code < 200 || w.code > 299 {
+		w.stream()
+	}
+}
+
+func (w *etagResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.streaming {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.buf.Len()+len(p) > w.maxBytes {
+		if err := w.stream(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.hash.Write(p)
+	return w.buf.Write(p)
+}
+
+// stream writes the buffered response to the client, and proxies the rest of
+// the response from then on.
+func (w *etagResponseWriter) stream() error {
+	if w.streaming {
+		return nil
+	}
+	w.streaming = true
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	_, err := io.Copy(w.ResponseWriter, &w.buf)
+	return err
+}
+
+func (w *etagResponseWriter) Flush() {
+	w.stream()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *etagResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.streaming = true
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("chi/middleware: http.Hijacker is unavailable on the writer")
+}
+
+func (w *etagResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool, 1)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/md5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestETag(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(ETagWith(16, md5.New))
+	r.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("hello "))
+		w.Write([]byte("world"))
+	})
+	r.Get("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 17)))
+	})
+	r.Get("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", 500)
+	})
+
+	etag := `W/"5eb63bbbe01eeed093cb22bb8f5acdc3"`
+
+	tests := []struct {
+		name        string
+		path        string
+		ifNoneMatch string
+		status      int
+		etag        string
+		body        string
+	}{
+		{"200 with etag", "/hello", "", 200, etag, "hello world"},
+		{"304", "/hello", etag, 304, etag, ""},
+		{"304 strong", "/hello", `"x", "5eb63bbbe01eeed093cb22bb8f5acdc3"`, 304, etag, ""},
+		{"200 on mismatch", "/hello", `W/"x"`, 200, etag, "hello world"},
+		{"over the threshold", "/large", "", 200, "", strings.Repeat("a", 17)},
+		{"non-2xx", "/error", "*", 500, "", "oops\n"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.name, tt.status, tt.body, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("ETag"); got != tt.etag {
+			t.Fatalf("%s: expecting ETag '%s', got '%s'", tt.name, tt.etag, got)
+		}
+	}
+}
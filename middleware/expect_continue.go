@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// ExpectContinue is a middleware that decides whether to accept the body of
+// the requests with an "Expect: 100-continue" header before it's sent. The
+// requests for which `accept` returns false are rejected with a 417
+// Expectation Failed status, responded by the error handler of the router, see
+// chi.Mux.SetErrorHandler.
+//
+// The http server only sends the 100 Continue response once the body is first
+// read, so a client waiting for it doesn't upload the body of a request which
+// is responded without reading it, ie. by ExpectContinue or RequestSize.
+func ExpectContinue(accept func(r *http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if expectsContinue(r) && !accept(r) {
+				chi.Error(w, r, http.StatusExpectationFailed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// expectsContinue reports whether the request `r` waits for a 100 Continue
+// response to send its body.
+func expectsContinue(r *http.Request) bool {
+	return r.ProtoAtLeast(1, 1) && strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
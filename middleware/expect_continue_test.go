@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestExpectContinue(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(RequestSize(10))
+	r.Use(ExpectContinue(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") != ""
+	}))
+	r.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		header string
+		length string
+		status int
+	}{
+		{"oversized", "Authorization: token\r\n", "100", 413},
+		{"rejected", "", "5", 417},
+		{"accepted", "Authorization: token\r\n", "5", 100},
+	}
+
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: example.com\r\n" + tt.header +
+			"Content-Length: " + tt.length + "\r\nExpect: 100-continue\r\n\r\n"))
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s: expecting %d before the body is sent, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+		if tt.status != 100 {
+			continue
+		}
+
+		// The body is sent once the client is told to continue
+		conn.Write([]byte("hello"))
+		resp, err = http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 || string(body) != "hello" {
+			t.Fatalf("%s: expecting 200 'hello', got %d '%s'", tt.name, resp.StatusCode, body)
+		}
+	}
+
+	// A request without the header is never rejected
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/upload", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expecting 200, got %d", w.Code)
+	}
+}
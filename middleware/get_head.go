@@ -7,6 +7,12 @@ import (
 )
 
 // GetHead automatically route undefined HEAD requests to GET handlers.
+//
+// A HEAD request is routed exactly as a GET request to the same path would be,
+// so a path without a GET handler, such as a POST-only route, responds to HEAD
+// with the 405 Method Not Allowed of the router rather than a 200. Note that
+// chi doesn't set the Allow header of a 405, so a custom MethodNotAllowed
+// handler which sets one should list HEAD along with GET.
 func GetHead(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "HEAD" {
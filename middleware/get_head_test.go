@@ -38,29 +38,66 @@ func TestGetHead(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/hi", nil); body != "bye" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if req, body := testRequest(t, ts, "HEAD", "/hi", nil); body != "" || req.Header.Get("X-Test") != "yes" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/", nil); body != "404 page not found\n" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if req, body := testRequest(t, ts, "HEAD", "/", nil); body != "" || req.StatusCode != 404 {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	if _, body := testRequest(t, ts, "GET", "/articles/5", nil); body != "article:5" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if req, body := testRequest(t, ts, "HEAD", "/articles/5", nil); body != "" || req.Header.Get("X-Article") != "5" {
 		t.Fatalf("expecting X-Article header '5' but got '%s'", req.Header.Get("X-Article"))
 	}
 
 	if _, body := testRequest(t, ts, "GET", "/users/1", nil); body != "user:1" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if req, body := testRequest(t, ts, "HEAD", "/users/1", nil); body != "" || req.Header.Get("X-User") != "-" {
 		t.Fatalf("expecting X-User header '-' but got '%s'", req.Header.Get("X-User"))
 	}
 }
+
+func TestGetHeadWithoutGet(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(GetHead)
+	r.Post("/submit", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("submitted"))
+	})
+	r.Get("/files/*", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file"))
+	})
+	r.Post("/files/upload", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("uploaded"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"POST", "/submit", 200},
+		{"HEAD", "/submit", 405},
+		{"GET", "/submit", 405},
+		{"HEAD", "/files/a.txt", 200},
+		{"GET", "/files/upload", 200},
+		{"HEAD", "/files/upload", 200},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s %s: expecting status %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
+	}
+}
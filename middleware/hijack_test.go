@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestHijackThroughMiddlewares(t *testing.T) {
+	logger := RequestLogger(&DefaultLogFormatter{Logger: log.New(ioutil.Discard, "", 0), NoColor: true})
+
+	r := chi.NewRouter()
+	r.Use(RequestID)
+	r.Use(RealIP)
+	r.Use(logger)
+	r.Use(Recoverer)
+	r.Use(DefaultCompress)
+	r.Use(Timeout(5 * time.Second))
+	r.Use(NoCache)
+
+	ws := chi.NewRouter()
+	ws.Use(SetHeader("X-Sub", "ws"))
+	ws.With(GetHead).Get("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "hijacker unavailable", 500)
+			return
+		}
+		conn, bufrw, err := hj.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		defer conn.Close()
+
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		bufrw.Flush()
+
+		line, _ := bufrw.ReadString('\n')
+		bufrw.WriteString("echo " + line)
+		bufrw.Flush()
+	})
+	r.Mount("/ws", ws)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte("GET /ws/upgrade HTTP/1.1\r\nHost: test\r\nAccept-Encoding: gzip\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expecting status 101, got %d: %s", resp.StatusCode, body)
+	}
+
+	conn.Write([]byte("ping\n"))
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(line, []byte("echo ping\n")) {
+		t.Fatalf("expecting 'echo ping', got '%s'", line)
+	}
+}
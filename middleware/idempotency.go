@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotentResponse is a response recorded by the IdempotencyKey middleware,
+// which is replayed to the requests repeating its idempotency key.
+type IdempotentResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyStore stores the responses of the IdempotencyKey middleware by
+// key, until their TTL expires. It must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Get returns the response stored for the key, if any and not expired.
+	Get(key string) (*IdempotentResponse, bool)
+
+	// Set stores the response for the key, for the duration of the ttl.
+	Set(key string, res *IdempotentResponse, ttl time.Duration)
+}
+
+// IdempotencyKey is a middleware that replays the response of a POST, PUT,
+// PATCH or DELETE request to the requests repeating its Idempotency-Key header
+// within the `ttl`, instead of executing the handler again. The responses are
+// stored in the `store` by key, method and path, unless they're a server error,
+// so that the request can be retried. A replayed response has the
+// Idempotent-Replayed header set to "true".
+//
+// The keys are scoped by the Authorization header of the requests, so that
+// the clients authenticated otherwise, ie. with a cookie, or not at all, share
+// their keys and may be replayed each other's responses. Unless the keys are
+// unique across all of the clients, use IdempotencyKeyWith to scope them by
+// the authenticated user instead.
+//
+// Note that the handler may still execute more than once for concurrent
+// requests with the same key, before the first response is stored.
+func IdempotencyKey(store IdempotencyStore, ttl time.Duration) func(next http.Handler) http.Handler {
+	return IdempotencyKeyWith(store, ttl, authorizationScope)
+}
+
+// IdempotencyKeyWith is an IdempotencyKey middleware with the keys scoped by
+// the `scope` of the request, ie. the id of the authenticated user, so that
+// the keys of a client never replay the responses of another one. A request
+// with an empty scope is served without replay.
+func IdempotencyKeyWith(store IdempotencyStore, ttl time.Duration, scope func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" || !idempotentMethods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s := scope(r)
+			if s == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = s + " " + r.Method + " " + r.URL.Path + " " + key
+
+			if res, ok := store.Get(key); ok {
+				h := w.Header()
+				for k, v := range res.Header {
+					h[k] = append([]string(nil), v...)
+				}
+				h.Set("Idempotent-Replayed", "true")
+				w.WriteHeader(res.Status)
+				w.Write(res.Body)
+				return
+			}
+
+			var buf bytes.Buffer
+			ww := NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+			var header http.Header
+			ww.BeforeWrite(func() {
+				header = cloneHeader(w.Header())
+			})
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+				header = cloneHeader(w.Header())
+			}
+			if status < 500 {
+				store.Set(key, &IdempotentResponse{Status: status, Header: header, Body: buf.Bytes()}, ttl)
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+var idempotentMethods = map[string]bool{
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// authorizationScope is the scope of the keys of IdempotencyKey, being a hash
+// of the Authorization header so that the credentials aren't kept in the store.
+func authorizationScope(r *http.Request) string {
+	sum := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+	return hex.EncodeToString(sum[:])
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore keeping the responses
+// in memory, which drops the expired responses as new ones are stored.
+func NewMemoryIdempotencyStore() IdempotencyStore {
+	return &memoryIdempotencyStore{entries: map[string]memoryIdempotencyEntry{}}
+}
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryIdempotencyEntry
+}
+
+type memoryIdempotencyEntry struct {
+	res     *IdempotentResponse
+	expires time.Time
+}
+
+func (s *memoryIdempotencyStore) Get(key string) (*IdempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.res, true
+}
+
+func (s *memoryIdempotencyStore) Set(key string, res *IdempotentResponse, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = memoryIdempotencyEntry{res: res, expires: now.Add(ttl)}
+}
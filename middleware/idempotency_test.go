@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	var orders int
+	r := chi.NewRouter()
+	r.Use(IdempotencyKey(NewMemoryIdempotencyStore(), 50*time.Millisecond))
+	r.Post("/orders", func(w http.ResponseWriter, r *http.Request) {
+		orders++
+		w.Header().Set("Location", fmt.Sprintf("/orders/%d", orders))
+		w.WriteHeader(201)
+		w.Write([]byte(fmt.Sprintf("order %d", orders)))
+	})
+	r.Delete("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", 503)
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		key      string
+		status   int
+		body     string
+		replayed bool
+	}{
+		{"first request", "POST", "/orders", "a", 201, "order 1", false},
+		{"repeated key", "POST", "/orders", "a", 201, "order 1", true},
+		{"other key", "POST", "/orders", "b", 201, "order 2", false},
+		{"no key", "POST", "/orders", "", 201, "order 3", false},
+		{"repeated key again", "POST", "/orders", "a", 201, "order 1", true},
+		{"server error", "DELETE", "/orders/1", "c", 503, "unavailable\n", false},
+		{"server error retry", "DELETE", "/orders/1", "c", 503, "unavailable\n", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set("Idempotency-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.name, tt.status, tt.body, w.Code, w.Body.String())
+		}
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+			t.Fatalf("%s: expecting replayed %v, got %v", tt.name, tt.replayed, replayed)
+		}
+		if tt.status == 201 && w.Header().Get("Location") == "" {
+			t.Fatalf("%s: expecting a Location header", tt.name)
+		}
+	}
+
+	// The response expires after the ttl
+	time.Sleep(60 * time.Millisecond)
+	req, _ := http.NewRequest("POST", "/orders", nil)
+	req.Header.Set("Idempotency-Key", "a")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Body.String() != "order 4" {
+		t.Fatalf("expecting 'order 4' after the ttl, got '%s'", w.Body.String())
+	}
+}
+
+func TestIdempotencyKeyWith(t *testing.T) {
+	var orders int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		orders++
+		w.Write([]byte(fmt.Sprintf("order %d", orders)))
+	}
+
+	// The keys are scoped by the Authorization header by default
+	r := chi.NewRouter()
+	r.Use(IdempotencyKey(NewMemoryIdempotencyStore(), time.Minute))
+	r.Post("/orders", handler)
+
+	// The keys are scoped by the user of the request
+	r2 := chi.NewRouter()
+	r2.Use(IdempotencyKeyWith(NewMemoryIdempotencyStore(), time.Minute, func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+	r2.Post("/orders", handler)
+
+	tests := []struct {
+		name   string
+		router http.Handler
+		header string
+		value  string
+		body   string
+	}{
+		{"default scope", r, "Authorization", "Bearer alice", "order 1"},
+		{"default scope, same client", r, "Authorization", "Bearer alice", "order 1"},
+		{"default scope, other client", r, "Authorization", "Bearer bob", "order 2"},
+		{"user scope", r2, "X-User", "alice", "order 3"},
+		{"user scope, same user", r2, "X-User", "alice", "order 3"},
+		{"user scope, other user", r2, "X-User", "bob", "order 4"},
+		{"user scope, no user", r2, "X-User", "", "order 5"},
+		{"user scope, no user again", r2, "X-User", "", "order 6"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/orders", nil)
+		req.Header.Set("Idempotency-Key", "a")
+		if tt.value != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, req)
+
+		if w.Body.String() != tt.body {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.name, tt.body, w.Body.String())
+		}
+	}
+}
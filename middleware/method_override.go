@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+var xHTTPMethodOverride = http.CanonicalHeaderKey("X-HTTP-Method-Override")
+
+// maxMethodOverrideFormSize is the maximum number of bytes of a form body
+// that are read while looking up the `_method` field.
+const maxMethodOverrideFormSize = 10 << 20
+
+var defaultOverrideMethods = []string{"PUT", "PATCH", "DELETE"}
+
+// MethodOverride is a middleware that allows POST requests to be routed as
+// another http method, which is useful for HTML forms that can only send GET
+// and POST requests. The method is read from the X-HTTP-Method-Override header,
+// or otherwise from the `_method` field of an urlencoded form body. The request
+// body is restored after parsing the form, so the handlers can still read it.
+//
+// Only the given `methods` are accepted as overrides, which default to PUT,
+// PATCH and DELETE, any other value is ignored. It panics if one of the
+// `methods` isn't a valid http method token. The middleware must be placed
+// before the routing of the request, ie. via the Use() method of the router.
+//
+//  r := chi.NewRouter()
+//  r.Use(middleware.MethodOverride())
+//  r.Delete("/articles/{id}", deleteArticle)
+func MethodOverride(methods ...string) func(next http.Handler) http.Handler {
+	if len(methods) == 0 {
+		methods = defaultOverrideMethods
+	}
+	allowed := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		if !chi.ValidMethod(m) {
+			panic(fmt.Sprintf("chi/middleware: invalid method override %q", m))
+		}
+		allowed[strings.ToUpper(m)] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				method := r.Header.Get(xHTTPMethodOverride)
+				if method == "" {
+					method = formMethodOverride(r)
+				}
+				method = strings.ToUpper(method)
+				if _, ok := allowed[method]; ok {
+					r.Method = method
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// formMethodOverride returns the `_method` field of an urlencoded form body,
+// restoring the request body for the next handlers.
+func formMethodOverride(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	ct := strings.ToLower(r.Header.Get("Content-Type"))
+	if i := strings.Index(ct, ";"); i > -1 {
+		ct = ct[0:i]
+	}
+	if strings.TrimSpace(ct) != "application/x-www-form-urlencoded" {
+		return ""
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMethodOverrideFormSize))
+	r.Body = &restoredBody{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+
+	form, err := url.ParseQuery(string(buf))
+	if err != nil {
+		return ""
+	}
+	return form.Get("_method")
+}
+
+// restoredBody is a request body made of the bytes read ahead of the handler
+// and the remainder of the original body.
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
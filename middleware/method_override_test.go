@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestMethodOverride(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + string(body)))
+	}
+
+	r := chi.NewRouter()
+	r.Use(MethodOverride())
+	r.Post("/articles", handler)
+	r.Put("/articles", handler)
+	r.Patch("/articles", handler)
+	r.Delete("/articles", handler)
+	r.Get("/articles", handler)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		ctype  string
+		body   string
+		want   string
+	}{
+		{"plain post", "POST", "", "", "", "POST "},
+		{"header put", "POST", "PUT", "", "", "PUT "},
+		{"header lower case", "POST", "delete", "", "", "DELETE "},
+		{"header not allowed", "POST", "GET", "", "", "POST "},
+		{"header on get", "GET", "DELETE", "", "", "GET "},
+		{"form patch", "POST", "", "application/x-www-form-urlencoded", "_method=PATCH&title=hi", "PATCH _method=PATCH&title=hi"},
+		{"form charset", "POST", "", "application/x-www-form-urlencoded; charset=utf-8", "_method=delete", "DELETE _method=delete"},
+		{"form not allowed", "POST", "", "application/x-www-form-urlencoded", "_method=TRACE", "POST _method=TRACE"},
+		{"form other content type", "POST", "", "application/json", `{"_method":"PUT"}`, `POST {"_method":"PUT"}`},
+		{"header over form", "POST", "PUT", "application/x-www-form-urlencoded", "_method=DELETE", "PUT _method=DELETE"},
+		{"form crlf", "POST", "", "application/x-www-form-urlencoded", "_method=PUT%0D%0AX-Evil%3A+1", "POST _method=PUT%0D%0AX-Evil%3A+1"},
+		{"form nul", "POST", "", "application/x-www-form-urlencoded", "_method=DELETE%00", "POST _method=DELETE%00"},
+		{"form space", "POST", "", "application/x-www-form-urlencoded", "_method=+PUT", "POST _method=+PUT"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+"/articles", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set("X-HTTP-Method-Override", tt.header)
+		}
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != tt.want {
+			t.Errorf("%s: expecting '%s', got '%s'", tt.name, tt.want, body)
+		}
+	}
+}
+
+func TestMethodOverrideAllowed(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MethodOverride("delete"))
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Method))
+	})
+
+	for override, want := range map[string]string{"DELETE": "DELETE", "PUT": "POST", "PATCH": "POST"} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("X-HTTP-Method-Override", override)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Body.String() != want {
+			t.Errorf("override %s: expecting '%s', got '%s'", override, want, w.Body.String())
+		}
+	}
+}
+
+func TestMethodOverrideInvalidMethods(t *testing.T) {
+	for _, method := range []string{"PUT\r\nX-Evil: 1", "DEL ETE", "PATCH\x00", "", "PÜT"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expecting a panic for the method override %q", method)
+				}
+			}()
+			MethodOverride(method)
+		}()
+	}
+}
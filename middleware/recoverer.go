@@ -8,32 +8,77 @@ import (
 	"net/http"
 	"os"
 	"runtime/debug"
+
+	"github.com/go-chi/chi"
 )
 
 // Recoverer is a middleware that recovers from panics, logs the panic (and a
 // backtrace), and returns a HTTP 500 (Internal Server Error) status if
-// possible. Recoverer prints a request ID if one is provided.
+// possible. Recoverer prints a request ID if one is provided. The 500 response
+// is the error handler of the router, see chi.Mux.SetErrorHandler.
 //
 // Alternatively, look at https://github.com/pressly/lg middleware pkgs.
 func Recoverer(next http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if rvr := recover(); rvr != nil {
-
-				logEntry := GetLogEntry(r)
-				if logEntry != nil {
-					logEntry.Panic(rvr, debug.Stack())
-				} else {
-					fmt.Fprintf(os.Stderr, "Panic: %+v\n", rvr)
-					debug.PrintStack()
+	return RecoverWith(defaultRecover)(next)
+}
+
+// Recovery holds the details of a panic recovered by the RecoverWith middleware.
+type Recovery struct {
+	// Value is the value passed to panic().
+	Value interface{}
+
+	// Stack is the backtrace of the goroutine that panicked.
+	Stack []byte
+
+	// Routed reports whether the panic occurred after the request was routed
+	// to its handler, as per chi's routing context. A panic in the middleware
+	// stack of the router, or in its not found handler, is not routed. Note that
+	// inline middlewares and the middlewares of sub-routers execute after the
+	// request is routed, and are reported as such.
+	Routed bool
+
+	// Context is the routing context of the request, or nil outside of a chi
+	// router. The routing happens within the middleware, so the context holds
+	// the route pattern and the URL params matched before the panic, ie. to
+	// log them along with the request ID of GetReqID. It's recycled once the
+	// request is served, see chi.Context.Detach to use it afterwards.
+	Context *chi.Context
+}
+
+// RecoverWith is a middleware that recovers from panics and calls `fn` with the
+// recovered details to respond to the request, ie. to log which layer of the
+// router failed. The middleware must be used within a chi router, such as via
+// the Use() method, to report whether the panic was routed and the routing
+// context of the request.
+func RecoverWith(fn func(w http.ResponseWriter, r *http.Request, rec *Recovery)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					rec := &Recovery{Value: rvr, Stack: debug.Stack()}
+					if rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context); ok {
+						rec.Routed = rctx.Routed()
+						rec.Context = rctx
+					}
+					fn(w, r, rec)
 				}
+			}()
+
+			next.ServeHTTP(w, r)
+		}
 
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			}
-		}()
+		return http.HandlerFunc(h)
+	}
+}
 
-		next.ServeHTTP(w, r)
+func defaultRecover(w http.ResponseWriter, r *http.Request, rec *Recovery) {
+	logEntry := GetLogEntry(r)
+	if logEntry != nil {
+		logEntry.Panic(rec.Value, rec.Stack)
+	} else {
+		fmt.Fprintf(os.Stderr, "Panic: %+v\n", rec.Value)
+		os.Stderr.Write(rec.Stack)
 	}
 
-	return http.HandlerFunc(fn)
+	chi.Error(w, r, http.StatusInternalServerError)
 }
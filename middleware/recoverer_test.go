@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRecoverWith(t *testing.T) {
+	var recovered *Recovery
+
+	r := chi.NewRouter()
+	r.Use(RecoverWith(func(w http.ResponseWriter, r *http.Request, rec *Recovery) {
+		recovered = rec
+		w.WriteHeader(500)
+		w.Write([]byte(fmt.Sprintf("recovered %v", rec.Value)))
+	}))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("mw") != "" {
+				panic("middleware")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler")
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		body   string
+		routed bool
+	}{
+		{"/?mw=1", "recovered middleware", false},
+		{"/", "recovered handler", true},
+	}
+
+	for _, tt := range tests {
+		recovered = nil
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != 500 || body != tt.body {
+			t.Fatalf("%s: expecting 500 '%s', got %d '%s'", tt.path, tt.body, resp.StatusCode, body)
+		}
+		if recovered == nil {
+			t.Fatalf("%s: expecting a recovery", tt.path)
+		}
+		if recovered.Routed != tt.routed {
+			t.Fatalf("%s: expecting routed %v, got %v", tt.path, tt.routed, recovered.Routed)
+		}
+		if len(recovered.Stack) == 0 {
+			t.Fatalf("%s: expecting a stack trace", tt.path)
+		}
+	}
+
+	recovered = nil
+	if _, body := testRequest(t, ts, "GET", "/ok", nil); body != "ok" || recovered != nil {
+		t.Fatalf("expecting no recovery, got '%s'", body)
+	}
+}
+
+func TestRecoverWithContext(t *testing.T) {
+	var pattern, id, reqID string
+	admin := chi.NewRouter()
+	admin.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("admin")
+	})
+
+	r := chi.NewRouter()
+	r.Use(RequestID)
+	r.Use(RecoverWith(func(w http.ResponseWriter, r *http.Request, rec *Recovery) {
+		pattern, id, reqID = "", "", GetReqID(r.Context())
+		if rec.Context != nil {
+			pattern, id = rec.Context.RoutePattern(), rec.Context.URLParam("id")
+		}
+		w.WriteHeader(500)
+	}))
+	r.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("article")
+	})
+	r.Mount("/admin", admin)
+
+	tests := []struct {
+		path    string
+		pattern string
+		id      string
+	}{
+		{"/articles/1", "/articles/{id}", "1"},
+		{"/admin/users/2", "/admin/users/{id}", "2"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != 500 || pattern != tt.pattern || id != tt.id {
+			t.Fatalf("%s: expecting the route '%s' with id '%s', got %d '%s' '%s'", tt.path, tt.pattern, tt.id, w.Code, pattern, id)
+		}
+		if reqID == "" {
+			t.Fatalf("%s: expecting a request ID", tt.path)
+		}
+	}
+}
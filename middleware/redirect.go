@@ -0,0 +1,26 @@
+package middleware
+
+import "net/http"
+
+// Redirect replies to the request with a redirect to `url`, which may be a
+// path relative to the request path, in the same manner as http.Redirect. A
+// `permanent` redirect responds with a 308 Permanent Redirect, and a temporary
+// one with a 307 Temporary Redirect, so that the client repeats the request
+// with the same method and body. The GET and HEAD requests are redirected
+// with a 301 Moved Permanently or a 302 Found instead, which are understood
+// by any client, as there's no body to preserve.
+func Redirect(w http.ResponseWriter, r *http.Request, url string, permanent bool) {
+	var code int
+	switch {
+	case r.Method == "GET" || r.Method == "HEAD":
+		code = http.StatusFound
+		if permanent {
+			code = http.StatusMovedPermanently
+		}
+	case permanent:
+		code = http.StatusPermanentRedirect
+	default:
+		code = http.StatusTemporaryRedirect
+	}
+	http.Redirect(w, r, url, code)
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		method    string
+		permanent bool
+		status    int
+	}{
+		{"GET", true, 301},
+		{"GET", false, 302},
+		{"HEAD", true, 301},
+		{"HEAD", false, 302},
+		{"POST", true, 308},
+		{"POST", false, 307},
+		{"PUT", true, 308},
+		{"DELETE", false, 307},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/articles/1", nil)
+		Redirect(w, r, "/posts/1", tt.permanent)
+
+		if w.Code != tt.status {
+			t.Errorf("%s permanent=%v: expecting %d, got %d", tt.method, tt.permanent, tt.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != "/posts/1" {
+			t.Errorf("%s permanent=%v: expecting the location '/posts/1', got '%s'", tt.method, tt.permanent, loc)
+		}
+	}
+
+	// A relative url resolves against the request path
+	w := httptest.NewRecorder()
+	Redirect(w, httptest.NewRequest("POST", "/articles/1", nil), "2", true)
+	if loc := w.Header().Get("Location"); w.Code != http.StatusPermanentRedirect || loc != "/articles/2" {
+		t.Errorf("expecting a 308 to '/articles/2', got %d to '%s'", w.Code, loc)
+	}
+}
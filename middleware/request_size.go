@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// RequestSize is a middleware that limits the size of the request body to
+// `bytes`. A request with a larger Content-Length is rejected with a 413
+// Request Entity Too Large status, responded by the error handler of the
+// router, see chi.Mux.SetErrorHandler. Otherwise, reading past the limit from
+// the body fails and closes the connection, see http.MaxBytesReader.
+//
+// A request with an "Expect: 100-continue" header and a larger Content-Length
+// is rejected before the client sends its body, see ExpectContinue.
+func RequestSize(bytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > bytes {
+				chi.Error(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, bytes)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
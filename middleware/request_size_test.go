@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRequestSize(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(RequestSize(5))
+	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	})
+
+	tests := []struct {
+		body   string
+		status int
+		resp   string
+	}{
+		{"hello", 200, "hello"},
+		{"hello world", 413, "Request Entity Too Large\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+		if w.Code != tt.status || w.Body.String() != tt.resp {
+			t.Errorf("%q: expecting %d %q, got %d %q", tt.body, tt.status, tt.resp, w.Code, w.Body.String())
+		}
+	}
+
+	// A body of unknown length fails to read past the limit
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/", strings.NewReader("hello world"))
+	req.ContentLength = -1
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expecting a failed read, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestSizeErrorHandler(t *testing.T) {
+	r := chi.NewRouter()
+	r.SetErrorHandler(http.StatusRequestEntityTooLarge, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(`{"error":"too large"}`))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(RequestSize(5))
+		r.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("uploaded"))
+		})
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("hello world")))
+	if w.Code != http.StatusRequestEntityTooLarge || w.Body.String() != `{"error":"too large"}` {
+		t.Fatalf("expecting the custom 413 response, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Outside of a router, the response is the default one
+	w = httptest.NewRecorder()
+	h := RequestSize(5)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/upload", strings.NewReader("hello world")))
+	if w.Code != http.StatusRequestEntityTooLarge || w.Body.String() != "Request Entity Too Large\n" {
+		t.Fatalf("expecting the default 413 response, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+)
+
+// RequireQuery is a middleware that responds with a 400 Bad Request status to
+// the requests missing any of the query parameters `keys`, before calling the
+// next handler. A parameter with an empty value, ie. "?q=", is present. The
+// 400 response is the error handler of the router, see chi.Mux.SetErrorHandler.
+func RequireQuery(keys ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			for _, key := range keys {
+				if _, ok := query[key]; !ok {
+					chi.Error(w, r, http.StatusBadRequest)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
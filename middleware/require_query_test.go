@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRequireQuery(t *testing.T) {
+	r := chi.NewRouter()
+	r.SetErrorHandler(http.StatusBadRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("missing query"))
+	})
+	r.With(RequireQuery("q", "page")).Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("results"))
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("index"))
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/search?q=chi&page=2", 200, "results"},
+		{"/search?page=2&q=", 200, "results"},
+		{"/search?q=chi", 400, "missing query"},
+		{"/search?page=2", 400, "missing query"},
+		{"/search", 400, "missing query"},
+		{"/", 200, "index"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s: expecting %d %q, got %d %q", tt.path, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	// Outside of a router, the response is the default one
+	w := httptest.NewRecorder()
+	h := RequireQuery("q")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/search", nil))
+	if w.Code != http.StatusBadRequest || w.Body.String() != "Bad Request\n" {
+		t.Fatalf("expecting the default 400 response, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Skip is a middleware that wraps the middleware `mw`, which only runs for the
+// requests matching the `condition`, while the other requests skip it and go
+// on to the next handler.
+//
+//  r.Use(middleware.Skip(func(r *http.Request) bool {
+//    return r.URL.Path != "/healthz"
+//  }, middleware.Logger))
+func Skip(condition func(r *http.Request) bool, mw func(http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := mw(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if condition(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestSkip(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Skip(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/")
+	}, SetHeader("X-API", "yes")))
+	r.Get("/api/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		body   string
+		header string
+	}{
+		{"/api/ping", "pong", "yes"},
+		{"/healthz", "ok", ""},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if body != tt.body {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.path, tt.body, body)
+		}
+		if h := resp.Header.Get("X-API"); h != tt.header {
+			t.Fatalf("%s: expecting X-API header '%s', got '%s'", tt.path, tt.header, h)
+		}
+	}
+}
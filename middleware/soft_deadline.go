@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// SoftDeadline is a middleware that cancels ctx after the `deadline`, like
+// Timeout, while leaving a response that is already being written untouched.
+// If the handler hasn't written anything by the time it returns past the
+// deadline, a 503 Service Unavailable is returned to the client, otherwise
+// the canceled context is the only signal, so that a partial streaming
+// response isn't corrupted.
+//
+// As with Timeout, the handler must select the ctx.Done() channel to check for
+// the signal and return, otherwise it will be just ignored.
+func SoftDeadline(deadline time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), deadline)
+			ww := NewWrapResponseWriter(w, r.ProtoMajor)
+			defer func() {
+				cancel()
+				if ctx.Err() == context.DeadlineExceeded && ww.Status() == 0 && ww.BytesWritten() == 0 {
+					ww.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}()
+
+			r = r.WithContext(ctx)
+			next.ServeHTTP(ww, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
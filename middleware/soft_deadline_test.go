@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestSoftDeadline(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(SoftDeadline(50 * time.Millisecond))
+
+	r.Get("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	})
+	r.Get("/idle", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	r.Get("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+		w.Write([]byte(" " + r.Context().Err().Error()))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/fast", 200, "fast"},
+		{"/idle", 503, ""},
+		{"/stream", 200, "partial context deadline exceeded"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
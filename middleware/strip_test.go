@@ -35,19 +35,19 @@ func TestStripSlashes(t *testing.T) {
 	defer ts.Close()
 
 	if _, resp := testRequest(t, ts, "GET", "/", nil); resp != "root" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "//", nil); resp != "root" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin", nil); resp != "admin" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin/", nil); resp != "admin" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/nothing-here", nil); resp != "nothing here" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 }
 
@@ -78,22 +78,22 @@ func TestStripSlashesInRoute(t *testing.T) {
 	defer ts.Close()
 
 	if _, resp := testRequest(t, ts, "GET", "/hi", nil); resp != "hi" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/hi/", nil); resp != "nothing here" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin", nil); resp != "accounts index" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin/", nil); resp != "accounts index" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin/query", nil); resp != "admin" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 	if _, resp := testRequest(t, ts, "GET", "/accounts/admin/query/", nil); resp != "admin" {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 }
 
@@ -124,24 +124,24 @@ func TestRedirectSlashes(t *testing.T) {
 	defer ts.Close()
 
 	if req, resp := testRequest(t, ts, "GET", "/", nil); resp != "root" && req.StatusCode != 200 {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 
 	// NOTE: the testRequest client will follow the redirection..
 	if req, resp := testRequest(t, ts, "GET", "//", nil); resp != "root" && req.StatusCode != 200 {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 
 	if req, resp := testRequest(t, ts, "GET", "/accounts/admin", nil); resp != "admin" && req.StatusCode != 200 {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 
 	// NOTE: the testRequest client will follow the redirection..
 	if req, resp := testRequest(t, ts, "GET", "/accounts/admin/", nil); resp != "admin" && req.StatusCode != 200 {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 
 	if req, resp := testRequest(t, ts, "GET", "/nothing-here", nil); resp != "nothing here" && req.StatusCode != 200 {
-		t.Fatalf(resp)
+		t.Fatal(resp)
 	}
 }
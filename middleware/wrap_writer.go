@@ -26,6 +26,11 @@ type WrapResponseWriter interface {
 	// io.Writer. It is illegal for the tee'd writer to be modified
 	// concurrently with writes.
 	Tee(io.Writer)
+	// BeforeWrite sets a callback that is called once, just before the
+	// response header is written to the client, ie. on the first call to
+	// WriteHeader, Write or Flush, so that the callback can still set the
+	// response headers. Setting a second callback will overwrite the first.
+	BeforeWrite(func())
 	// Unwrap returns the original proxied target.
 	Unwrap() http.ResponseWriter
 }
@@ -38,10 +43,12 @@ type basicWriter struct {
 	code        int
 	bytes       int
 	tee         io.Writer
+	beforeWrite func()
 }
 
 func (b *basicWriter) WriteHeader(code int) {
 	if !b.wroteHeader {
+		b.callBeforeWrite()
 		b.code = code
 		b.wroteHeader = true
 		b.ResponseWriter.WriteHeader(code)
@@ -74,6 +81,15 @@ func (b *basicWriter) BytesWritten() int {
 func (b *basicWriter) Tee(w io.Writer) {
 	b.tee = w
 }
+func (b *basicWriter) BeforeWrite(fn func()) {
+	b.beforeWrite = fn
+}
+func (b *basicWriter) callBeforeWrite() {
+	if fn := b.beforeWrite; fn != nil {
+		b.beforeWrite = nil
+		fn()
+	}
+}
 func (b *basicWriter) Unwrap() http.ResponseWriter {
 	return b.ResponseWriter
 }
@@ -83,6 +99,7 @@ type flushWriter struct {
 }
 
 func (f *flushWriter) Flush() {
+	f.callBeforeWrite()
 	f.wroteHeader = true
 
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
@@ -104,6 +121,7 @@ func (f *httpFancyWriter) CloseNotify() <-chan bool {
 	return cn.CloseNotify()
 }
 func (f *httpFancyWriter) Flush() {
+	f.callBeforeWrite()
 	f.wroteHeader = true
 
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
@@ -144,6 +162,7 @@ func (f *http2FancyWriter) CloseNotify() <-chan bool {
 	return cn.CloseNotify()
 }
 func (f *http2FancyWriter) Flush() {
+	f.callBeforeWrite()
 	f.wroteHeader = true
 
 	fl := f.basicWriter.ResponseWriter.(http.Flusher)
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"testing"
 )
@@ -31,3 +32,34 @@ func TestHttp2FancyWriterRemembersWroteHeaderWhenFlushed(t *testing.T) {
 		t.Fatal("want Flush to have set wroteHeader=true")
 	}
 }
+
+func TestWrapWriterBeforeWrite(t *testing.T) {
+	var calls int
+	rec := httptest.NewRecorder()
+	ww := NewWrapResponseWriter(rec, 1)
+	ww.BeforeWrite(func() {
+		calls++
+		ww.Header().Set("X-Before", "yes")
+	})
+
+	ww.WriteHeader(201)
+	ww.Write([]byte("a"))
+	ww.(http.Flusher).Flush()
+	ww.Write([]byte("b"))
+
+	if calls != 1 {
+		t.Fatalf("want BeforeWrite to be called once, got %d", calls)
+	}
+	if rec.Code != 201 || rec.Header().Get("X-Before") != "yes" {
+		t.Fatalf("want 201 with the X-Before header, got %d %v", rec.Code, rec.Header())
+	}
+
+	f := &flushWriter{basicWriter{ResponseWriter: httptest.NewRecorder()}}
+	f.BeforeWrite(func() { calls++ })
+	f.Flush()
+	f.Write([]byte("c"))
+
+	if calls != 2 {
+		t.Fatal("want BeforeWrite to be called on Flush")
+	}
+}
@@ -1,15 +1,43 @@
 package chi
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 var _ Router = &Mux{}
 
+var (
+	// ErrRouteNotFound is returned by MatchRoute when no route matches the path.
+	ErrRouteNotFound = errors.New("chi: route not found")
+
+	// ErrMethodNotAllowed is returned by MatchRoute when a route matches the
+	// path, but not the method.
+	ErrMethodNotAllowed = errors.New("chi: method not allowed")
+
+	// ErrUnsupportedMediaType is the decoding error of a PostJSON request with
+	// another content type than JSON.
+	ErrUnsupportedMediaType = errors.New("chi: unsupported media type")
+)
+
 // Mux is a simple HTTP route multiplexer that parses a request path,
 // records any URL params, and executes an end handler. It implements
 // the http.Handler interface and is friendly with the standard library.
@@ -18,13 +46,42 @@ var _ Router = &Mux{}
 // modular and composable HTTP services with a large set of handlers. It's
 // particularly useful for writing large REST API services that break a handler
 // into many smaller parts composed of middlewares and end handlers.
+//
+// The zero value of a Mux is ready to use, as a Mux of NewMux without options.
 type Mux struct {
+	// Number of requests being served, see WithInFlightTracking. It's
+	// first in the struct for 64-bit alignment of the atomic operations.
+	inFlight int64
+
 	// The radix trie router
 	tree *node
 
+	// Guards the routing tree between route registration and Routes(),
+	// shared with inline muxs
+	mu *sync.RWMutex
+
+	// Set once the tree, the lock and the pool are initialized, see lazyInit
+	initialized uint32
+
 	// The middleware stack
 	middlewares []func(http.Handler) http.Handler
 
+	// The middleware stacks of the matched and unmatched requests, see
+	// UseOnMatch and UseOnMiss
+	matchMiddlewares []func(http.Handler) http.Handler
+	missMiddlewares  []func(http.Handler) http.Handler
+
+	// The UseOnMiss middleware stack chained once, see serveMiss
+	missHandler http.Handler
+
+	// The middleware stacks of the requests matching a routing pattern glob,
+	// see UseFor
+	patternMiddlewares []patternMiddlewares
+
+	// Hooks substituting the handler of the matched routes, see
+	// UseRouteRewriter
+	routeRewriters []func(rctx *Context, current http.Handler) http.Handler
+
 	// Controls the behaviour of middleware chain generation when a mux
 	// is registered as an inline group inside another mux.
 	inline bool
@@ -34,39 +91,424 @@ type Mux struct {
 	// the tree router
 	handler http.Handler
 
+	// The mux handler published to ServeHTTP as a muxHandler, which reads it
+	// without taking the lock
+	served atomic.Value
+
 	// Routing context pool
 	pool *sync.Pool
 
 	// Custom route not found handler
 	notFoundHandler http.HandlerFunc
 
+	// Whether the notFoundHandler is inherited from the parent router, which
+	// keeps it in sync when the parent's handler is updated
+	notFoundInherited bool
+
+	// Custom route not found handlers by http method, see NotFoundFor
+	notFoundHandlers map[string]http.HandlerFunc
+
+	// Custom route not found handlers by path prefix, see NotFoundPrefix
+	notFoundPrefixes map[string]http.HandlerFunc
+
+	// Handler of the requests matching no route, see Fallback
+	fallbackHandler http.Handler
+
 	// Custom method not allowed handler
 	methodNotAllowedHandler http.HandlerFunc
+
+	// Custom malformed request path handler, see BadRequest
+	badRequestHandler http.HandlerFunc
+
+	// Custom error handlers by status code, see SetErrorHandler
+	errorHandlers map[int]http.HandlerFunc
+
+	// Error handlers of the parent router, which a mounted router without its
+	// own handler of a status responds with
+	inheritedErrorHandlers map[int]http.HandlerFunc
+
+	// Number of routes registered along a method and pattern, see Len
+	routeCount int
+
+	// Fallback to the longest matching ancestor route, see PrefixMatch
+	prefixMatch bool
+
+	// Response headers set on every request served by the mux
+	defaultHeaders http.Header
+
+	// Route configuration of an inline mux, see WithConfig
+	config map[string]interface{}
+
+	// Media types served by the routes of an inline mux, see Accept
+	accepts []string
+
+	// Request header values required by the routes of an inline mux, see
+	// RequireHeader
+	headers []headerMatch
+
+	// Matching priority of the routes of an inline mux, see Priority
+	priority int
+
+	// Request context timeout of the routes of an inline mux, see Timeout
+	timeout time.Duration
+
+	// Outermost panic handler of the mux, see WithRecover
+	recoverFn func(w http.ResponseWriter, r *http.Request, rvr interface{})
+
+	// Track the number of requests being served, see WithInFlightTracking
+	trackInFlight bool
+
+	// Reuse the request copies carrying the routing context, see
+	// WithPooledRequests
+	pooledRequests bool
+
+	// Route the base path of trailing wildcards, see WithEmptyWildcard
+	emptyWildcard bool
+
+	// Maximum length of the routing path, see WithMaxURLLength
+	maxURLLength int
+
+	// Status code of the rejected TRACE requests, see DisableTrace
+	traceStatus int
+
+	// Decorator of the endpoint handlers, see WithHandlerWrapper
+	handlerWrapper func(pattern string, h http.Handler) http.Handler
+
+	// Hook called before the response is written, see WithBeforeWrite
+	beforeWriteFn func(w http.ResponseWriter, r *http.Request)
+
+	// Record the time spent in each middleware, see WithMiddlewareTiming
+	middlewareTiming bool
+
+	// Record why the requests match no route, see WithDebugMatch
+	debugMatch bool
+
+	// Request body decoder and decoding error handler of PostJSON, see
+	// WithBodyDecoder and WithDecodeErrorHandler
+	bodyDecoder        func(r *http.Request, v interface{}) error
+	decodeErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// MuxOption configures a Mux on creation, see NewMux.
+type MuxOption func(*Mux)
+
+// WithRecover returns a MuxOption that recovers from any panic while serving
+// a request, and calls `fn` with the recovered value to respond to it. Unlike
+// a Recoverer middleware, the recover is guaranteed to wrap the whole mux
+// handler, including its middleware stack. A panic with http.ErrAbortHandler
+// is re-panicked to abort the response as intended.
+func WithRecover(fn func(w http.ResponseWriter, r *http.Request, rvr interface{})) MuxOption {
+	return func(mx *Mux) {
+		mx.recoverFn = fn
+	}
+}
+
+// WithInFlightTracking returns a MuxOption that tracks the number of requests
+// being served by the mux, as reported by InFlight(), ie. as a signal for
+// autoscaling. The tracking is disabled by default to spare its overhead.
+func WithInFlightTracking() MuxOption {
+	return func(mx *Mux) {
+		mx.trackInFlight = true
+	}
+}
+
+// WithPooledRequests returns a MuxOption that serves the requests without any
+// allocation on the part of the mux, by reusing the copy of the request that
+// carries the routing context, along with its context.Context, from the pool
+// of routing contexts. The request, its context and the routing context are
+// recycled once the mux handler returns, so neither may be used afterwards,
+// ie. by a goroutine of the handler that outlives the request, which must
+// copy what it needs beforehand, see Context.Detach. By default, the mux
+// allocates a copy of each request and its context, which remain valid.
+func WithPooledRequests() MuxOption {
+	return func(mx *Mux) {
+		mx.pooledRequests = true
+	}
+}
+
+// WithEmptyWildcard returns a MuxOption that routes the base path of a pattern
+// ending with a wildcard as well, with an empty wildcard, ie. "/a/*" matches
+// "/a" in addition to "/a/" and "/a/b". By default, the base path of "/a/*"
+// isn't matched and must be routed explicitly. The option doesn't apply to
+// the pattern "/*", nor to the patterns of Mount, which routes the base path
+// of a sub-router regardless.
+func WithEmptyWildcard() MuxOption {
+	return func(mx *Mux) {
+		mx.emptyWildcard = true
+	}
+}
+
+// WithBeforeWrite returns a MuxOption that calls `fn` once per request, just
+// before the response header is written to the client, ie. to set response
+// headers based on the routing pattern of the request, which is matched by
+// then. The response writer passed to the handlers is wrapped to hook the
+// first call to WriteHeader, Write or Flush, and it supports http.Flusher,
+// http.Hijacker and http.CloseNotifier as long as the original writer does.
+func WithBeforeWrite(fn func(w http.ResponseWriter, r *http.Request)) MuxOption {
+	return func(mx *Mux) {
+		mx.beforeWriteFn = fn
+	}
+}
+
+// WithMaxURLLength returns a MuxOption that responds with a 414 Request URI Too
+// Long to the requests whose path is longer than `n` bytes, before routing
+// them. The length is unlimited by default, or when `n` is 0.
+func WithMaxURLLength(n int) MuxOption {
+	return func(mx *Mux) {
+		mx.maxURLLength = n
+	}
+}
+
+// WithHandlerWrapper returns a MuxOption that wraps the handler of every route
+// with `fn` as it's registered, along with the routing pattern of the route,
+// ie. to trace each route in a span named by its pattern. Unlike a middleware,
+// the wrapper is inside the inline middlewares of the route, next to the
+// handler. The routes of a sub-router created with Route() are wrapped too,
+// with their full pattern, while mounted routers must set their own wrapper.
+// The wrapper is called before the mux is locked to register the route, so it
+// may call the methods of the mux, ie. Routes.
+func WithHandlerWrapper(fn func(pattern string, h http.Handler) http.Handler) MuxOption {
+	return func(mx *Mux) {
+		mx.handlerWrapper = fn
+	}
+}
+
+// WithMiddlewareTiming returns a MuxOption that records the time spent in each
+// middleware of the mux on the routing context of the request, excluding the
+// time spent in the next handler, see Context.MiddlewareTimings. It applies to
+// the middleware stack of the mux, of its inline groups and sub-routers created
+// with Route(), and to the middlewares set with UseOnMatch, UseOnMiss and
+// UseFor, while mounted routers must enable it themselves. The middlewares
+// must call their next handler in the goroutine of the request.
+func WithMiddlewareTiming() MuxOption {
+	return func(mx *Mux) {
+		mx.middlewareTiming = true
+	}
+}
+
+// WithDebugMatch returns a MuxOption that records why a request matched no
+// route of the mux on its routing context, ie. for the NotFound handler to log
+// whether the path matched no route at all, or the prefix of a route, or a
+// route with other methods, see Context.MatchError. It applies to the mux, its
+// inline groups and sub-routers created with Route(), while mounted routers
+// must enable it themselves.
+func WithDebugMatch() MuxOption {
+	return func(mx *Mux) {
+		mx.debugMatch = true
+	}
+}
+
+// WithBodyDecoder returns a MuxOption that decodes the request bodies of the
+// PostJSON routes into their values with `fn`, instead of the encoding/json
+// package, ie. to disallow unknown fields.
+func WithBodyDecoder(fn func(r *http.Request, v interface{}) error) MuxOption {
+	return func(mx *Mux) {
+		mx.bodyDecoder = fn
+	}
+}
+
+// WithDecodeErrorHandler returns a MuxOption that responds to the requests of
+// the PostJSON routes whose body can't be decoded with `fn`, instead of a 415
+// Unsupported Media Type for ErrUnsupportedMediaType, or a 400 Bad Request.
+func WithDecodeErrorHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) MuxOption {
+	return func(mx *Mux) {
+		mx.decodeErrorHandler = fn
+	}
 }
 
 // NewMux returns a newly initialized Mux object that implements the Router
 // interface.
-func NewMux() *Mux {
-	mux := &Mux{tree: &node{}, pool: &sync.Pool{}}
-	mux.pool.New = func() interface{} {
-		return NewRouteContext()
+func NewMux(opts ...MuxOption) *Mux {
+	mux := &Mux{}
+	mux.lazyInit()
+	for _, opt := range opts {
+		opt(mux)
 	}
 	return mux
 }
 
+// muxInit guards the initialization of the zero-value muxes, see lazyInit.
+var muxInit sync.Mutex
+
+// lazyInit initializes the routing tree, the lock and the routing context pool
+// of the mux, once, so a zero-value Mux is ready to use as one of NewMux.
+func (mx *Mux) lazyInit() {
+	if atomic.LoadUint32(&mx.initialized) == 1 {
+		return
+	}
+	muxInit.Lock()
+	defer muxInit.Unlock()
+	if mx.initialized == 0 {
+		mx.tree = &node{}
+		mx.pool = &sync.Pool{New: func() interface{} { return NewRouteContext() }}
+		mx.mu = &sync.RWMutex{}
+		atomic.StoreUint32(&mx.initialized, 1)
+	}
+}
+
+// mutex returns the lock of the mux, once initialized.
+func (mx *Mux) mutex() *sync.RWMutex {
+	mx.lazyInit()
+	return mx.mu
+}
+
+// Clone returns a copy of the mux, with a copy of its routing tree and its
+// settings, which may be modified without affecting the mux, ie. to derive
+// variants of a router in tests. The middleware stack of the clone may be
+// extended with Use until its routes are served or more routes are defined.
+// The routers mounted on the mux, including the sub-routers of Route(), are
+// shared with the clone though, as are their not found handlers, so routes
+// are added to the clone along new patterns only. The handlers of the routes
+// are shared as well, while the ones of PostJSON decode the requests with the
+// settings of the mux serving them, being the clone for the clone.
+func (mx *Mux) Clone() *Mux {
+	if mx.inline {
+		panic("chi: Clone is unavailable on an inline mux")
+	}
+
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
+
+	// Build the clone field by field rather than copying the mux, whose
+	// in-flight counter and served handler are accessed atomically, and
+	// give the clone its own
+	c := Mux{
+		tree:                    mx.tree.clone(),
+		mu:                      &sync.RWMutex{},
+		initialized:             1,
+		pool:                    &sync.Pool{New: func() interface{} { return NewRouteContext() }},
+		middlewares:             append(Middlewares(nil), mx.middlewares...),
+		matchMiddlewares:        append(Middlewares(nil), mx.matchMiddlewares...),
+		missMiddlewares:         append(Middlewares(nil), mx.missMiddlewares...),
+		patternMiddlewares:      append([]patternMiddlewares(nil), mx.patternMiddlewares...),
+		routeRewriters:          append([]func(*Context, http.Handler) http.Handler(nil), mx.routeRewriters...),
+		notFoundHandler:         mx.notFoundHandler,
+		notFoundInherited:       mx.notFoundInherited,
+		fallbackHandler:         mx.fallbackHandler,
+		methodNotAllowedHandler: mx.methodNotAllowedHandler,
+		badRequestHandler:       mx.badRequestHandler,
+		routeCount:              mx.routeCount,
+		prefixMatch:             mx.prefixMatch,
+		config:                  mx.config,
+		accepts:                 mx.accepts,
+		headers:                 mx.headers,
+		priority:                mx.priority,
+		timeout:                 mx.timeout,
+		recoverFn:               mx.recoverFn,
+		trackInFlight:           mx.trackInFlight,
+		pooledRequests:          mx.pooledRequests,
+		emptyWildcard:           mx.emptyWildcard,
+		maxURLLength:            mx.maxURLLength,
+		traceStatus:             mx.traceStatus,
+		handlerWrapper:          mx.handlerWrapper,
+		beforeWriteFn:           mx.beforeWriteFn,
+		middlewareTiming:        mx.middlewareTiming,
+		debugMatch:              mx.debugMatch,
+		bodyDecoder:             mx.bodyDecoder,
+		decodeErrorHandler:      mx.decodeErrorHandler,
+	}
+	if mx.notFoundHandlers != nil {
+		c.notFoundHandlers = make(map[string]http.HandlerFunc, len(mx.notFoundHandlers))
+		for k, v := range mx.notFoundHandlers {
+			c.notFoundHandlers[k] = v
+		}
+	}
+	if mx.notFoundPrefixes != nil {
+		c.notFoundPrefixes = make(map[string]http.HandlerFunc, len(mx.notFoundPrefixes))
+		for k, v := range mx.notFoundPrefixes {
+			c.notFoundPrefixes[k] = v
+		}
+	}
+	if mx.errorHandlers != nil {
+		c.errorHandlers = make(map[int]http.HandlerFunc, len(mx.errorHandlers))
+		for k, v := range mx.errorHandlers {
+			c.errorHandlers[k] = v
+		}
+	}
+	if mx.inheritedErrorHandlers != nil {
+		c.inheritedErrorHandlers = make(map[int]http.HandlerFunc, len(mx.inheritedErrorHandlers))
+		for k, v := range mx.inheritedErrorHandlers {
+			c.inheritedErrorHandlers[k] = v
+		}
+	}
+	if mx.defaultHeaders != nil {
+		c.defaultHeaders = make(http.Header, len(mx.defaultHeaders))
+		for k, v := range mx.defaultHeaders {
+			c.defaultHeaders[k] = append([]string(nil), v...)
+		}
+	}
+	return &c
+}
+
 // ServeHTTP is the single method of the http.Handler interface that makes
 // Mux interoperable with the standard library. It uses a sync.Pool to get and
 // reuse routing contexts for each request.
 func (mx *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Ensure the mux has some routes defined on the mux
-	if mx.handler == nil {
+	// The handler is published once it's built, and again whenever it's
+	// rebuilt by ReplaceMiddlewares, so serving a request takes no lock.
+	if h, ok := mx.served.Load().(muxHandler); ok {
+		mx.serve(w, r, h.Handler)
+		return
+	}
+
+	// Ensure the mux has some routes defined on the mux. A clone has routes,
+	// but builds its handler once it's served.
+	mx.mutex().Lock()
+	if mx.handler == nil && !mx.tree.isEmpty() {
+		mx.buildRouteHandler()
+	}
+	handler := mx.handler
+	mx.mutex().Unlock()
+	if handler == nil {
 		panic("chi: attempting to route to a mux with no handlers.")
 	}
+	mx.serve(w, r, handler)
+}
+
+// muxHandler is the mux handler published to ServeHTTP, wrapped in a type of
+// its own for the atomic.Value holding it.
+type muxHandler struct {
+	http.Handler
+}
+
+// serve serves the request `r` with the mux `handler`, along with a routing
+// context unless the request is routed by a parent router.
+func (mx *Mux) serve(w http.ResponseWriter, r *http.Request, handler http.Handler) {
+	if mx.trackInFlight {
+		atomic.AddInt64(&mx.inFlight, 1)
+		defer atomic.AddInt64(&mx.inFlight, -1)
+	}
+
+	if mx.recoverFn != nil {
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+				mx.recoverFn(w, r, rvr)
+			}
+		}()
+	}
+
+	// Set the default response headers ahead of the handlers, which may
+	// override them.
+	if mx.defaultHeaders != nil {
+		h := w.Header()
+		for k, v := range mx.defaultHeaders {
+			h[k] = append([]string(nil), v...)
+		}
+	}
 
 	// Check if a routing context already exists from a parent router.
 	rctx, _ := r.Context().Value(RouteCtxKey).(*Context)
 	if rctx != nil {
-		mx.handler.ServeHTTP(w, r)
+		if mx.beforeWriteFn != nil {
+			w = newBeforeWriteWriter(w, r, mx.beforeWriteFn)
+		}
+		parent := rctx.mux
+		rctx.mux = mx
+		handler.ServeHTTP(w, r)
+		rctx.mux = parent
 		return
 	}
 
@@ -77,11 +519,35 @@ func (mx *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rctx = mx.pool.Get().(*Context)
 	rctx.Reset()
 	rctx.Routes = mx
-	r = r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx))
-	mx.handler.ServeHTTP(w, r)
+	rctx.mux = mx
+	rctx.request = r
+	if mx.pooledRequests {
+		// Reuse the request copy and its context.Context held by the routing
+		// context, see WithPooledRequests
+		rctx.pooledCtx = requestContext{r.Context(), rctx}
+		rctx.pooledRequest = *r.WithContext(&rctx.pooledCtx)
+		r = &rctx.pooledRequest
+	} else {
+		r = r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx))
+	}
+	if mx.beforeWriteFn != nil {
+		w = newBeforeWriteWriter(w, r, mx.beforeWriteFn)
+	}
+	handler.ServeHTTP(w, r)
+	if mx.pooledRequests {
+		rctx.pooledCtx = requestContext{}
+		rctx.pooledRequest = http.Request{}
+	}
 	mx.pool.Put(rctx)
 }
 
+// InFlight returns the number of requests currently being served by the mux,
+// which is tracked when the mux is created with the WithInFlightTracking option,
+// otherwise it's always 0.
+func (mx *Mux) InFlight() int64 {
+	return atomic.LoadInt64(&mx.inFlight)
+}
+
 // Use appends a middleware handler to the Mux middleware stack.
 //
 // The middleware stack for any Mux will execute before searching for a matching
@@ -89,12 +555,190 @@ func (mx *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // change the course of the request execution, or set request-scoped values for
 // the next http.Handler.
 func (mx *Mux) Use(middlewares ...func(http.Handler) http.Handler) {
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
 	if mx.handler != nil {
 		panic("chi: all middlewares must be defined before routes on a mux")
 	}
 	mx.middlewares = append(mx.middlewares, middlewares...)
 }
 
+// MiddlewareAt returns the middleware at index `i` of the Mux middleware stack,
+// in the order of Use, see Middlewares.
+func (mx *Mux) MiddlewareAt(i int) func(http.Handler) http.Handler {
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
+	return mx.middlewares[i]
+}
+
+// ReorderMiddlewares rearranges the Mux middleware stack, such that the
+// middleware at index `i` is the one previously at index `indices[i]`, ie.
+// []int{2, 0, 1} moves the third middleware first, for middlewares registered
+// in an arbitrary order, ie. by plugins, which must run in a given order. The
+// `indices` must be a permutation of the indexes of the stack. Like Use, it
+// must be called before the routes are defined.
+func (mx *Mux) ReorderMiddlewares(indices []int) {
+	if mx.inline {
+		panic("chi: ReorderMiddlewares is unavailable on an inline mux, as its middlewares are part of the route handlers")
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	if mx.handler != nil {
+		panic("chi: all middlewares must be defined before routes on a mux")
+	}
+	if len(indices) != len(mx.middlewares) {
+		panic(fmt.Sprintf("chi: ReorderMiddlewares expects %d indices, got %d", len(mx.middlewares), len(indices)))
+	}
+
+	mws := make(Middlewares, len(indices))
+	seen := make([]bool, len(indices))
+	for i, idx := range indices {
+		if idx < 0 || idx >= len(indices) || seen[idx] {
+			panic(fmt.Sprintf("chi: ReorderMiddlewares indices %v are not a permutation of the middleware stack", indices))
+		}
+		seen[idx] = true
+		mws[i] = mx.middlewares[idx]
+	}
+	mx.middlewares = mws
+}
+
+// ReplaceMiddlewares replaces the Mux middleware stack with the `middlewares`.
+// Unlike Use, it may be called after the routes are defined, ie. while the mux
+// is serving requests, to toggle a maintenance mode middleware at runtime. The
+// requests in flight complete with the previous stack.
+func (mx *Mux) ReplaceMiddlewares(middlewares ...func(http.Handler) http.Handler) {
+	if mx.inline {
+		panic("chi: ReplaceMiddlewares is unavailable on an inline mux, as its middlewares are part of the route handlers")
+	}
+	mws := make(Middlewares, len(middlewares))
+	copy(mws, middlewares)
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	mx.middlewares = mws
+	if mx.handler != nil {
+		mx.buildRouteHandler()
+	}
+}
+
+// UseOnMatch appends a middleware handler to the middleware stack of the
+// requests that match a route, which executes after the Mux middleware stack
+// once the route is found, ahead of its inline middlewares and handler. A
+// request routed to a mounted router is matched by the router it's mounted on.
+func (mx *Mux) UseOnMatch(middlewares ...func(http.Handler) http.Handler) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	if m.handler != nil {
+		panic("chi: all middlewares must be defined before routes on a mux")
+	}
+	m.matchMiddlewares = append(m.matchMiddlewares, middlewares...)
+}
+
+// UseRouteRewriter appends a hook called with the routing context and the
+// handler of the route matched by a request, which returns the handler to
+// execute instead, or `current` to keep it, ie. to serve different handlers of
+// the same route for A/B testing. The handler of the route includes its inline
+// middlewares, and a request routed to a mounted router is matched by the mount
+// of the router it's mounted on. The hooks are called in order once the route
+// is found, ahead of the middlewares set with UseFor and UseOnMatch.
+func (mx *Mux) UseRouteRewriter(fn func(rctx *Context, current http.Handler) http.Handler) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	if m.handler != nil {
+		panic("chi: all middlewares must be defined before routes on a mux")
+	}
+	m.routeRewriters = append(m.routeRewriters, fn)
+}
+
+// UseOnMiss appends a middleware handler to the middleware stack of the
+// requests that match no route, which executes after the Mux middleware stack
+// ahead of the not found or method not allowed handler.
+func (mx *Mux) UseOnMiss(middlewares ...func(http.Handler) http.Handler) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	if m.handler != nil {
+		panic("chi: all middlewares must be defined before routes on a mux")
+	}
+	m.missMiddlewares = append(m.missMiddlewares, middlewares...)
+}
+
+// UseFor appends a middleware handler to the middleware stack of the requests
+// whose routing pattern matches the `glob`, which executes after the UseOnMatch
+// middleware stack, in the order the globs were set. The glob has the syntax of
+// path.Match, where a trailing "/*" matches the rest of the pattern, ie.
+// "/admin/*" matches "/admin/users/{id}". A request routed to a mounted router
+// matches with the pattern of the mount, ie. "/admin/*", while the globs of the
+// mounted router match its own patterns, relative to the mount. The globs are
+// matched once, as the routes are registered.
+func (mx *Mux) UseFor(glob string, middlewares ...func(http.Handler) http.Handler) {
+	if _, err := path.Match(glob, ""); err != nil {
+		panic(fmt.Sprintf("chi: invalid UseFor glob '%s': %v", glob, err))
+	}
+
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	if m.handler != nil {
+		panic("chi: all middlewares must be defined before routes on a mux")
+	}
+	m.patternMiddlewares = append(m.patternMiddlewares, patternMiddlewares{glob, middlewares})
+}
+
+// patternMiddlewares is a middleware stack set with UseFor.
+type patternMiddlewares struct {
+	glob        string
+	middlewares []func(http.Handler) http.Handler
+}
+
+// match reports whether the routing `pattern` matches the glob.
+func (pm patternMiddlewares) match(pattern string) bool {
+	if strings.HasSuffix(pm.glob, "/*") && strings.HasPrefix(pattern, pm.glob[:len(pm.glob)-1]) {
+		return true
+	}
+	ok, _ := path.Match(pm.glob, pattern)
+	return ok
+}
+
+// DisableTrace makes the mux respond to any TRACE request with the `status`
+// code, ie. 403 Forbidden, even when a route handles the TRACE method or any
+// method. A zero `status` responds with a 405 Method Not Allowed. The requests
+// are rejected ahead of the routing, so that the routers mounted on the mux
+// never serve them either.
+func (mx *Mux) DisableTrace(status int) {
+	if status == 0 {
+		status = http.StatusMethodNotAllowed
+	}
+
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+	m.traceStatus = status
+}
+
 // Handle adds the route `pattern` that matches any http method to
 // execute the `handler` http.Handler.
 func (mx *Mux) Handle(pattern string, handler http.Handler) {
@@ -107,6 +751,40 @@ func (mx *Mux) HandleFunc(pattern string, handlerFn http.HandlerFunc) {
 	mx.handle(mALL, pattern, handlerFn)
 }
 
+// Any adds the route `pattern` that matches the common http methods, being
+// DELETE, GET, HEAD, OPTIONS, PATCH, POST and PUT, to execute the `handlerFn`
+// http.HandlerFunc. CONNECT, TRACE and custom methods registered with
+// RegisterMethod respond with a 405, use AnyAll to match those as well.
+func (mx *Mux) Any(pattern string, handlerFn http.HandlerFunc) {
+	mx.handle(mANY, pattern, handlerFn)
+}
+
+// AnyAll adds the route `pattern` that matches any http method, including
+// CONNECT, TRACE and custom methods, to execute the `handlerFn`
+// http.HandlerFunc. It's equivalent to HandleFunc.
+func (mx *Mux) AnyAll(pattern string, handlerFn http.HandlerFunc) {
+	mx.handle(mALL, pattern, handlerFn)
+}
+
+// AnyExcept adds the route `pattern` that matches any http method but the
+// `except` methods, which respond with a 405, to execute the `handlerFn`
+// http.HandlerFunc. The methods matched include CONNECT, TRACE and the custom
+// methods registered with RegisterMethod so far.
+func (mx *Mux) AnyExcept(except []string, pattern string, handlerFn http.HandlerFunc) {
+	methods := mALL
+	for _, method := range except {
+		m, ok := methodMap[strings.ToUpper(method)]
+		if !ok {
+			panic(fmt.Sprintf("chi: '%s' http method is not supported.", method))
+		}
+		methods &^= m
+	}
+	if methods == 0 {
+		panic(fmt.Sprintf("chi: AnyExcept excludes every http method of '%s'", pattern))
+	}
+	mx.handle(methods, pattern, handlerFn)
+}
+
 // Method adds the route `pattern` that matches `method` http method to
 // execute the `handler` http.Handler.
 func (mx *Mux) Method(method, pattern string, handler http.Handler) {
@@ -117,12 +795,41 @@ func (mx *Mux) Method(method, pattern string, handler http.Handler) {
 	mx.handle(m, pattern, handler)
 }
 
+// TryHandle adds the route `pattern` that matches `method` http method to
+// execute the `handler` http.Handler, in the same manner as Method, but returns
+// an error rather than panic when the method is not supported or the `pattern`
+// is invalid, ie. to load routes programmatically.
+func (mx *Mux) TryHandle(method, pattern string, handler http.Handler) error {
+	m, ok := methodMap[strings.ToUpper(method)]
+	if !ok {
+		return fmt.Errorf("chi: '%s' http method is not supported.", method)
+	}
+	_, err := mx.tryHandle(m, pattern, handler)
+	return err
+}
+
 // MethodFunc adds the route `pattern` that matches `method` http method to
 // execute the `handlerFn` http.HandlerFunc.
 func (mx *Mux) MethodFunc(method, pattern string, handlerFn http.HandlerFunc) {
 	mx.Method(method, pattern, handlerFn)
 }
 
+// ServeBytes adds the route `pattern` that matches `method` http method to
+// respond with `data` of the `contentType`, ie. to serve a favicon or robots.txt
+// file embedded in the program. The response has an ETag computed from `data`
+// and a Last-Modified time of the route registration, to respond to conditional
+// requests with a 304 Not Modified. Range requests are supported as well.
+func (mx *Mux) ServeBytes(method, pattern string, contentType string, data []byte) {
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data))
+	modtime := time.Now().UTC().Truncate(time.Second)
+
+	mx.Method(method, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", modtime, bytes.NewReader(data))
+	}))
+}
+
 // Connect adds the route `pattern` that matches a CONNECT http method to
 // execute the `handlerFn` http.HandlerFunc.
 func (mx *Mux) Connect(pattern string, handlerFn http.HandlerFunc) {
@@ -147,6 +854,123 @@ func (mx *Mux) Head(pattern string, handlerFn http.HandlerFunc) {
 	mx.handle(mHEAD, pattern, handlerFn)
 }
 
+// Index adds the routes of the root path "/" that match the GET and HEAD http
+// methods to execute the `handlerFn` http.HandlerFunc, ie. for the index page
+// of a router whose other routes are deeper, such as a "/static/*" wildcard. A
+// root path route takes precedence over a wildcard mounted along "/", and a
+// request with an empty path, ie. in the absolute form "GET http://host", is
+// routed to it too.
+func (mx *Mux) Index(handlerFn http.HandlerFunc) {
+	mx.handle(mGET, "/", handlerFn)
+	mx.handle(mHEAD, "/", handlerFn)
+}
+
+// Redirects adds a route for each old routing pattern of the `redirects` that
+// matches a GET http method, to redirect the requests to the new url with the
+// `status`, ie. http.StatusMovedPermanently. The new url is a template of the
+// params of the pattern, where each "{param}" is replaced by the escaped value
+// of the param, and a "*" by the value of the wildcard if the pattern has one,
+// as is so that it keeps its slashes. The leading slashes of a new path are
+// collapsed, so a value starting with a slash doesn't redirect to another
+// host, ie. "//evil.com" for "/*".
+//
+//  r.Redirects(map[string]string{
+//    "/blog":      "/articles",
+//    "/old/{id}":  "/new/{id}",
+//    "/docs/v1/*": "https://docs.example.com/*",
+//  }, http.StatusMovedPermanently)
+func (mx *Mux) Redirects(redirects map[string]string, status int) {
+	if status < 300 || status > 399 {
+		panic(fmt.Sprintf("chi: invalid redirect status %d", status))
+	}
+	for pattern, target := range redirects {
+		parts := redirectParts(pattern, target)
+		hostRelative := strings.HasPrefix(target, "//")
+		mx.handle(mGET, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The values of the params are escaped as path segments, unless
+			// they were routed along the escaped path, see routeHTTP
+			escaped := r.URL.RawPath != ""
+			var location string
+			for _, part := range parts {
+				if part.param == "*" || (part.param != "" && escaped) {
+					location += URLParam(r, part.param)
+				} else if part.param != "" {
+					location += escapePathSegment(URLParam(r, part.param))
+				} else {
+					location += part.text
+				}
+			}
+			// A path starting with two slashes, or a slash and a backslash, is
+			// a protocol-relative url to browsers
+			if !hostRelative && len(location) > 1 && location[0] == '/' && (location[1] == '/' || location[1] == '\\') {
+				location = "/" + strings.TrimLeft(location, `/\`)
+			}
+			http.Redirect(w, r, location, status)
+		}))
+	}
+}
+
+// escapePathSegment escapes the string `s` so it can be safely placed inside
+// a URL path segment, in the manner of url.PathEscape which requires go1.8.
+func escapePathSegment(s string) string {
+	return strings.Replace((&url.URL{Path: s}).EscapedPath(), "/", "%2F", -1)
+}
+
+// redirectPart is a static text or a param of a redirect url template.
+type redirectPart struct {
+	text  string
+	param string
+}
+
+// redirectParts splits the redirect url `target` of the routing `pattern` into
+// its static texts and params, and panics on a param missing from the pattern.
+func redirectParts(pattern, target string) []redirectPart {
+	keys := patParamKeys(pattern)
+	hasKey := func(key string) bool {
+		for _, k := range keys {
+			if k == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	var parts []redirectPart
+	for len(target) > 0 {
+		i := strings.IndexAny(target, "{*")
+		if i < 0 {
+			parts = append(parts, redirectPart{text: target})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, redirectPart{text: target[:i]})
+		}
+		target = target[i:]
+
+		if target[0] == '*' {
+			if hasKey("*") {
+				parts = append(parts, redirectPart{param: "*"})
+			} else {
+				parts = append(parts, redirectPart{text: "*"})
+			}
+			target = target[1:]
+			continue
+		}
+
+		e := strings.IndexByte(target, '}')
+		if e < 0 {
+			panic(fmt.Sprintf("chi: redirect url '%s' has an unclosed param", target))
+		}
+		key := target[1:e]
+		if !hasKey(key) {
+			panic(fmt.Sprintf("chi: redirect url param '{%s}' is missing from the routing pattern '%s'", key, pattern))
+		}
+		parts = append(parts, redirectPart{param: key})
+		target = target[e+1:]
+	}
+	return parts
+}
+
 // Options adds the route `pattern` that matches a OPTIONS http method to
 // execute the `handlerFn` http.HandlerFunc.
 func (mx *Mux) Options(pattern string, handlerFn http.HandlerFunc) {
@@ -171,56 +995,420 @@ func (mx *Mux) Put(pattern string, handlerFn http.HandlerFunc) {
 	mx.handle(mPUT, pattern, handlerFn)
 }
 
-// Trace adds the route `pattern` that matches a TRACE http method to
-// execute the `handlerFn` http.HandlerFunc.
-func (mx *Mux) Trace(pattern string, handlerFn http.HandlerFunc) {
-	mx.handle(mTRACE, pattern, handlerFn)
-}
+// PostJSON adds the route `pattern` that matches a POST http method to execute
+// the `handlerFn` with the request body decoded as JSON into a new value of the
+// type `v` points to, ie. PostJSON("/users", &CreateUser{}, fn) calls fn with a
+// *CreateUser. A request of another content type than JSON, or whose body can't
+// be decoded, is responded to by the decoding error handler of the mux, see
+// WithBodyDecoder and WithDecodeErrorHandler.
+func (mx *Mux) PostJSON(pattern string, v interface{}, handlerFn func(w http.ResponseWriter, r *http.Request, v interface{})) {
+	typ := reflect.TypeOf(v)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic(fmt.Sprintf("chi: PostJSON expects a pointer value, got %T", v))
+	}
+	typ = typ.Elem()
 
-// NotFound sets a custom http.HandlerFunc for routing paths that could
-// not be found. The default 404 handler is `http.NotFound`.
-func (mx *Mux) NotFound(handlerFn http.HandlerFunc) {
-	// Build NotFound handler chain
-	m := mx
-	hFn := handlerFn
-	if mx.inline && mx.parent != nil {
-		m = mx.parent
-		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	root := mx
+	for root.inline && root.parent != nil {
+		root = root.parent
 	}
 
-	// Update the notFoundHandler from this point forward
-	m.notFoundHandler = hFn
-	m.updateSubRoutes(func(subMux *Mux) {
-		if subMux.notFoundHandler == nil {
-			subMux.NotFound(hFn)
+	mx.handle(mPOST, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Decode with the settings of the mux serving the request, which is
+		// a clone of the mux once cloned
+		m := root
+		if rctx, _ := r.Context().Value(RouteCtxKey).(*Context); rctx != nil && rctx.mux != nil {
+			m = rctx.mux
 		}
-	})
+		v := reflect.New(typ).Interface()
+		if err := m.decodeBody(r, v); err != nil {
+			if m.decodeErrorHandler != nil {
+				m.decodeErrorHandler(w, r, err)
+			} else if err == ErrUnsupportedMediaType {
+				Error(w, r, http.StatusUnsupportedMediaType)
+			} else {
+				Error(w, r, http.StatusBadRequest)
+			}
+			return
+		}
+		handlerFn(w, r, v)
+	}))
 }
 
-// MethodNotAllowed sets a custom http.HandlerFunc for routing paths where the
-// method is unresolved. The default handler returns a 405 with an empty body.
-func (mx *Mux) MethodNotAllowed(handlerFn http.HandlerFunc) {
-	// Build MethodNotAllowed handler chain
-	m := mx
-	hFn := handlerFn
+// decodeBody decodes the JSON body of the request `r` into `v`, with the body
+// decoder of the mux if any.
+func (mx *Mux) decodeBody(r *http.Request, v interface{}) error {
+	ct := r.Header.Get("Content-Type")
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	if ct != "application/json" && !(strings.HasPrefix(ct, "application/") && strings.HasSuffix(ct, "+json")) {
+		return ErrUnsupportedMediaType
+	}
+
+	if mx.bodyDecoder != nil {
+		return mx.bodyDecoder(r, v)
+	}
+	if r.Body == nil {
+		return io.EOF
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// Trace adds the route `pattern` that matches a TRACE http method to
+// execute the `handlerFn` http.HandlerFunc.
+func (mx *Mux) Trace(pattern string, handlerFn http.HandlerFunc) {
+	mx.handle(mTRACE, pattern, handlerFn)
+}
+
+// ConnectHandler adds the route `pattern` that matches a CONNECT http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) ConnectHandler(pattern string, handler http.Handler) {
+	mx.handle(mCONNECT, pattern, handler)
+}
+
+// DeleteHandler adds the route `pattern` that matches a DELETE http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) DeleteHandler(pattern string, handler http.Handler) {
+	mx.handle(mDELETE, pattern, handler)
+}
+
+// GetHandler adds the route `pattern` that matches a GET http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) GetHandler(pattern string, handler http.Handler) {
+	mx.handle(mGET, pattern, handler)
+}
+
+// HeadHandler adds the route `pattern` that matches a HEAD http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) HeadHandler(pattern string, handler http.Handler) {
+	mx.handle(mHEAD, pattern, handler)
+}
+
+// OptionsHandler adds the route `pattern` that matches a OPTIONS http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) OptionsHandler(pattern string, handler http.Handler) {
+	mx.handle(mOPTIONS, pattern, handler)
+}
+
+// PatchHandler adds the route `pattern` that matches a PATCH http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) PatchHandler(pattern string, handler http.Handler) {
+	mx.handle(mPATCH, pattern, handler)
+}
+
+// PostHandler adds the route `pattern` that matches a POST http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) PostHandler(pattern string, handler http.Handler) {
+	mx.handle(mPOST, pattern, handler)
+}
+
+// PutHandler adds the route `pattern` that matches a PUT http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) PutHandler(pattern string, handler http.Handler) {
+	mx.handle(mPUT, pattern, handler)
+}
+
+// TraceHandler adds the route `pattern` that matches a TRACE http method to
+// execute the `handler` http.Handler.
+func (mx *Mux) TraceHandler(pattern string, handler http.Handler) {
+	mx.handle(mTRACE, pattern, handler)
+}
+
+// NotFound sets a custom http.HandlerFunc for routing paths that could
+// not be found. The default 404 handler is `http.NotFound`.
+func (mx *Mux) NotFound(handlerFn http.HandlerFunc) {
+	// Build NotFound handler chain
+	m := mx
+	hFn := handlerFn
+	if mx.inline && mx.parent != nil {
+		m = mx.parent
+		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	}
+
+	// Update the notFoundHandler from this point forward
+	m.setNotFound(hFn, false)
+}
+
+// inheritNotFound sets the not found handler `hFn` of the parent router,
+// unless the mux has its own.
+func (mx *Mux) inheritNotFound(hFn http.HandlerFunc) {
+	mx.setNotFound(hFn, true)
+}
+
+// setNotFound sets the not found handler `hFn` of the mux, unless it's
+// `inherited` from the parent router and the mux has its own, and passes it
+// on to the sub-routers.
+func (mx *Mux) setNotFound(hFn http.HandlerFunc, inherited bool) {
+	mx.mutex().Lock()
+	if inherited && mx.notFoundHandler != nil && !mx.notFoundInherited {
+		mx.mutex().Unlock()
+		return
+	}
+	mx.notFoundHandler = hFn
+	mx.notFoundInherited = inherited
+	mx.mutex().Unlock()
+
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.inheritNotFound(hFn)
+	})
+}
+
+// NotFoundFor sets a custom http.HandlerFunc for routing paths that could not
+// be found with the http `method`, which takes precedence over the NotFound
+// handler for requests of that method.
+func (mx *Mux) NotFoundFor(method string, handlerFn http.HandlerFunc) {
+	method = strings.ToUpper(method)
+
+	// Build NotFound handler chain
+	m := mx
+	hFn := handlerFn
+	if mx.inline && mx.parent != nil {
+		m = mx.parent
+		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	}
+
+	// Update the notFoundHandlers from this point forward
+	m.setNotFoundFor(method, hFn, false)
+}
+
+// setNotFoundFor sets the not found handler `hFn` of the http `method` on the
+// mux, unless it's `inherited` from the parent router and the mux has its own,
+// and passes it on to the sub-routers.
+func (mx *Mux) setNotFoundFor(method string, hFn http.HandlerFunc, inherited bool) {
+	mx.mutex().Lock()
+	if inherited && mx.notFoundHandlers[method] != nil {
+		mx.mutex().Unlock()
+		return
+	}
+	if mx.notFoundHandlers == nil {
+		mx.notFoundHandlers = make(map[string]http.HandlerFunc)
+	}
+	mx.notFoundHandlers[method] = hFn
+	mx.mutex().Unlock()
+
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.setNotFoundFor(method, hFn, true)
+	})
+}
+
+// NotFoundPrefix sets a custom http.HandlerFunc for routing paths that could
+// not be found under the `prefix` of the request URL path, ie. to respond with
+// a JSON 404 under "/api" and a HTML one elsewhere. The prefix matches whole
+// path segments, so that "/api" matches "/api" and "/api/missing", but not
+// "/apis". The longest matching prefix wins, and takes precedence over the
+// NotFoundFor and NotFound handlers.
+func (mx *Mux) NotFoundPrefix(prefix string, handlerFn http.HandlerFunc) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	// Build NotFound handler chain
+	m := mx
+	hFn := handlerFn
+	if mx.inline && mx.parent != nil {
+		m = mx.parent
+		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	}
+
+	// Update the notFoundPrefixes from this point forward
+	m.setNotFoundPrefix(prefix, hFn, false)
+}
+
+// setNotFoundPrefix sets the not found handler `hFn` of the `prefix` on the
+// mux, unless it's `inherited` from the parent router and the mux has its own,
+// and passes it on to the sub-routers.
+func (mx *Mux) setNotFoundPrefix(prefix string, hFn http.HandlerFunc, inherited bool) {
+	mx.mutex().Lock()
+	if inherited && mx.notFoundPrefixes[prefix] != nil {
+		mx.mutex().Unlock()
+		return
+	}
+	if mx.notFoundPrefixes == nil {
+		mx.notFoundPrefixes = make(map[string]http.HandlerFunc)
+	}
+	mx.notFoundPrefixes[prefix] = hFn
+	mx.mutex().Unlock()
+
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.setNotFoundPrefix(prefix, hFn, true)
+	})
+}
+
+// MethodNotAllowed sets a custom http.HandlerFunc for routing paths where the
+// method is unresolved. The default handler returns a 405 with a plain text
+// "Method Not Allowed" body.
+func (mx *Mux) MethodNotAllowed(handlerFn http.HandlerFunc) {
+	// Build MethodNotAllowed handler chain
+	m := mx
+	hFn := handlerFn
 	if mx.inline && mx.parent != nil {
 		m = mx.parent
 		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
 	}
 
 	// Update the methodNotAllowedHandler from this point forward
-	m.methodNotAllowedHandler = hFn
+	m.setMethodNotAllowed(hFn, false)
+}
+
+// setMethodNotAllowed sets the method not allowed handler `hFn` of the mux,
+// unless it's `inherited` from the parent router and the mux has its own, and
+// passes it on to the sub-routers.
+func (mx *Mux) setMethodNotAllowed(hFn http.HandlerFunc, inherited bool) {
+	mx.mutex().Lock()
+	if inherited && mx.methodNotAllowedHandler != nil {
+		mx.mutex().Unlock()
+		return
+	}
+	mx.methodNotAllowedHandler = hFn
+	mx.mutex().Unlock()
+
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.setMethodNotAllowed(hFn, true)
+	})
+}
+
+// Fallback sets a http.Handler serving the requests that match no route of the
+// mux, instead of the NotFound and MethodNotAllowed handlers, ie. an existing
+// http.ServeMux to migrate its routes to chi one at a time. The request is
+// delegated as is, with the routing path of the mux, so that the fallback sees
+// the same URL path as the mux, and a chi router as the fallback routes the
+// same path as the mux. Mounted routers don't inherit the fallback. The
+// fallback set on an inline mux runs through its inline middlewares, which
+// include the ones of its parent inline muxes.
+func (mx *Mux) Fallback(h http.Handler) {
+	m := mx
+	if mx.inline && mx.parent != nil {
+		h = Chain(mx.middlewares...).Handler(h)
+	}
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	m.mutex().Lock()
+	m.fallbackHandler = h
+	m.mutex().Unlock()
+}
+
+// BadRequest sets a custom http.HandlerFunc responding to the requests whose
+// URL path is malformed, with an invalid percent-encoding or invalid UTF-8
+// once decoded, which are rejected by the router before any route is matched.
+// Without it, they're answered by the error handler of the 400 Bad Request
+// status, see SetErrorHandler, which also answers the other bad requests, ie.
+// the undecodable bodies of PostJSON, unlike the BadRequest handler.
+func (mx *Mux) BadRequest(handlerFn http.HandlerFunc) {
+	// Build BadRequest handler chain
+	m := mx
+	hFn := handlerFn
+	if mx.inline && mx.parent != nil {
+		m = mx.parent
+		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	}
+
+	m.mutex().Lock()
+	m.badRequestHandler = hFn
+	m.mutex().Unlock()
+}
+
+// SetErrorHandler sets a custom http.HandlerFunc responding with the error
+// `status`, ie. http.StatusRequestEntityTooLarge. The 404 and 405 handlers are
+// the ones of NotFound and MethodNotAllowed, used by the router itself, while
+// the handlers of the other statuses are used by the router's own errors, ie.
+// a 414 with WithMaxURLLength, and by the middlewares responding with an error
+// through chi.Error, such as middleware.RequestSize and middleware.Recoverer.
+// A mounted router inherits the handlers it doesn't set itself.
+func (mx *Mux) SetErrorHandler(status int, handlerFn http.HandlerFunc) {
+	switch status {
+	case http.StatusNotFound:
+		mx.NotFound(handlerFn)
+		return
+	case http.StatusMethodNotAllowed:
+		mx.MethodNotAllowed(handlerFn)
+		return
+	}
+
+	// Build the error handler chain
+	m := mx
+	hFn := handlerFn
+	if mx.inline && mx.parent != nil {
+		hFn = Chain(mx.middlewares...).HandlerFunc(hFn).ServeHTTP
+	}
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	if m.errorHandlers == nil {
+		m.errorHandlers = make(map[int]http.HandlerFunc)
+	}
+	m.errorHandlers[status] = hFn
 	m.updateSubRoutes(func(subMux *Mux) {
-		if subMux.methodNotAllowedHandler == nil {
-			subMux.MethodNotAllowed(hFn)
-		}
+		subMux.inheritErrorHandler(status, hFn)
 	})
 }
 
+// inheritErrorHandler sets the error handler `hFn` of the parent router for the
+// `status`, unless the mux has its own.
+func (mx *Mux) inheritErrorHandler(status int, hFn http.HandlerFunc) {
+	if mx.errorHandlers[status] != nil {
+		return
+	}
+	if mx.inheritedErrorHandlers == nil {
+		mx.inheritedErrorHandlers = make(map[int]http.HandlerFunc)
+	}
+	mx.inheritedErrorHandlers[status] = hFn
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.inheritErrorHandler(status, hFn)
+	})
+}
+
+// PrefixMatch enables or disables longest-prefix matching on the Mux. When
+// enabled and no route matches the request path, the Mux falls back to the
+// handler of the longest registered route that is an ancestor of the path,
+// similar to a gateway. For example, a "/api/" route will also handle requests
+// to "/api/anything" and "/api/v1/users".
+//
+// Exact, param and wildcard routes (including Mount's) always take precedence,
+// as the fallback is only attempted once the regular route search has found no
+// route for the path. If the path itself is routed, but not for the request
+// method, the Mux still responds with a 405 instead. The fallback applies to
+// the routes of this Mux only, sub-routers must enable it on their own.
+func (mx *Mux) PrefixMatch(enabled bool) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	m.mutex().Lock()
+	m.prefixMatch = enabled
+	m.mutex().Unlock()
+}
+
+// SetDefaultHeader sets a response header key/value on every request served
+// by the Mux, including not found and method not allowed responses. Handlers
+// and middlewares may override the header as it's set before they execute.
+// Multiple calls accumulate, while setting an existing key replaces its value.
+func (mx *Mux) SetDefaultHeader(key, value string) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+
+	// Replace the headers rather than updating them, as they're read by the
+	// requests being served without the lock
+	m.mutex().Lock()
+	h := make(http.Header, len(m.defaultHeaders)+1)
+	for k, v := range m.defaultHeaders {
+		h[k] = v
+	}
+	h.Set(key, value)
+	m.defaultHeaders = h
+	m.mutex().Unlock()
+}
+
 // With adds inline middlewares for an endpoint handler.
 func (mx *Mux) With(middlewares ...func(http.Handler) http.Handler) Router {
 	// Similarly as in handle(), we must build the mux handler once further
 	// middleware registration isn't allowed for this stack, like now.
+	mx.mutex().Lock()
 	if !mx.inline && mx.handler == nil {
 		mx.buildRouteHandler()
 	}
@@ -231,13 +1419,100 @@ func (mx *Mux) With(middlewares ...func(http.Handler) http.Handler) Router {
 		mws = make(Middlewares, len(mx.middlewares))
 		copy(mws, mx.middlewares)
 	}
+	mx.mutex().Unlock()
 	mws = append(mws, middlewares...)
 
-	im := &Mux{pool: mx.pool, inline: true, parent: mx, tree: mx.tree, middlewares: mws}
+	im := &Mux{pool: mx.pool, inline: true, parent: mx, tree: mx.tree, mu: mx.mu, initialized: 1, middlewares: mws}
+
+	// Inherit the route configuration and media types from parent inline muxs
+	if mx.inline {
+		im.config = mx.config
+		im.accepts = mx.accepts
+		im.headers = mx.headers
+		im.priority = mx.priority
+		im.timeout = mx.timeout
+	}
 
 	return im
 }
 
+// WithConfig adds an inline-Mux that attaches the `config` key/values to its
+// routes, in addition to the configuration of a parent inline-Router. The route
+// configuration is available via the routing context's RouteConfig() once the
+// request is routed, for example to an inline middleware or the handler, which
+// allows for data-driven middleware behavior per route.
+//
+//  r.WithConfig(map[string]interface{}{"cache": "60s"}).Get("/articles", listArticles)
+func (mx *Mux) WithConfig(config map[string]interface{}) *Mux {
+	im := mx.With().(*Mux)
+	im.config = mergeRouteConfig(im.config, config)
+	return im
+}
+
+// Accept adds an inline-Mux whose routes serve the `mediaTypes` only, which
+// are negotiated with the Accept request header. The same route can be
+// registered for different media types, where the handler serving the most
+// acceptable media type is selected once the request matches the route's
+// path and method. A handler of the route registered without Accept serves
+// requests accepting none of the media types, otherwise they're responded with
+// a 406 Not Acceptable. Requests without an Accept header are served by that
+// handler too, or else by the handler registered first.
+//
+//  r.Accept("application/json").Get("/data", dataJSON)
+//  r.Accept("text/csv").Get("/data", dataCSV)
+func (mx *Mux) Accept(mediaTypes ...string) *Mux {
+	im := mx.With().(*Mux)
+	im.accepts = mediaTypes
+	return im
+}
+
+// RequireHeader adds an inline-Mux whose routes only match the requests with
+// the header `key` equal to `value`, in addition to the headers required by a
+// parent inline-Router. The same route can be registered with different
+// headers, where the first handler registered with matching headers is
+// selected, or else the handler of the route registered without headers. When
+// none of them matches, the search goes on with the other routes matching the
+// path, or else the request is responded with a 404, ie. for feature-gating.
+//
+//  r.RequireHeader("X-Beta", "1").Get("/search", searchBeta)
+//  r.Get("/search", search)
+func (mx *Mux) RequireHeader(key, value string) *Mux {
+	im := mx.With().(*Mux)
+	im.headers = append(im.headers[:len(im.headers):len(im.headers)], headerMatch{http.CanonicalHeaderKey(key), value})
+	return im
+}
+
+// Priority adds an inline-Mux whose routes have the matching `priority`,
+// which is 0 by default. When several routes match a path, the route with the
+// highest priority is routed, ie. to route "/users/{id}" rather than the static
+// "/users/me", while routes of the same priority fall back to the default
+// precedence of static over param over wildcard segments. Note that once a
+// priority is set, every search of the routing tree goes over all the routes
+// matching the path.
+//
+//  r.Priority(1).Get("/users/{id}", getUser)
+func (mx *Mux) Priority(priority int) *Mux {
+	im := mx.With().(*Mux)
+	im.priority = priority
+	return im
+}
+
+// Timeout adds an inline-Mux whose routes serve the requests with a context
+// canceled once the `timeout` elapses, ie. to give a report route longer than a
+// health check. The timeout starts once the route matches, and covers the
+// middlewares set with UseOnMatch and UseFor, the inline middlewares and the
+// handler, which must return once the context is done. Unlike the
+// middleware.Timeout, no 504 Gateway Timeout status is responded on expiry.
+// The timeout of a mounted router applies to all of its routes, in addition to
+// their own timeouts.
+//
+//  r.Timeout(30 * time.Second).Get("/report", report)
+func (mx *Mux) Timeout(timeout time.Duration) *Mux {
+	im := mx.With().(*Mux)
+	im.timeout = timeout
+	return im
+}
+
 // Group creates a new inline-Mux with a fresh middleware stack. It's useful
 // for a group of handlers along the same routing path that use an additional
 // set of middlewares. See _examples/.
@@ -254,69 +1529,569 @@ func (mx *Mux) Group(fn func(r Router)) Router {
 // call to Mount. See _examples/.
 func (mx *Mux) Route(pattern string, fn func(r Router)) Router {
 	subRouter := NewRouter()
+
+	// Inherit the route registration options of the mux
+	root := mx
+	for root.inline && root.parent != nil {
+		root = root.parent
+	}
+	subRouter.emptyWildcard = root.emptyWildcard
+	subRouter.bodyDecoder = root.bodyDecoder
+	subRouter.decodeErrorHandler = root.decodeErrorHandler
+	subRouter.middlewareTiming = root.middlewareTiming
+	subRouter.debugMatch = root.debugMatch
+	if wrap := root.handlerWrapper; wrap != nil {
+		prefix := strings.TrimSuffix(pattern, "/")
+		subRouter.handlerWrapper = func(p string, h http.Handler) http.Handler {
+			return wrap(prefix+p, h)
+		}
+	}
+
 	if fn != nil {
 		fn(subRouter)
 	}
-	mx.Mount(pattern, subRouter)
-	return subRouter
+
+	// Build the sub-router's handler even if no routes were defined, so its
+	// middleware stack still applies to the not found responses of the subtree.
+	subRouter.mutex().Lock()
+	if subRouter.handler == nil {
+		subRouter.buildRouteHandler()
+	}
+	subRouter.mutex().Unlock()
+	mx.Mount(pattern, subRouter)
+	return subRouter
+}
+
+// Prefix adds the routes matching the `prefix` path and any path under it for
+// any http method, to execute the `handler` http.Handler, ie. to reverse-proxy a
+// whole subtree. Unlike Mount, the handler is a plain route of the wildcard
+// pattern `prefix/*`, which matches the prefix itself with an empty wildcard,
+// such that "/api" matches "/api", "/api/" and "/api/x/y", and the rest of the
+// path after the prefix is the "*" URL param. The routing path isn't scoped to
+// the rest of the path either, so a chi Router as the handler routes the full
+// path. The more specific routes registered along or under the prefix, ie.
+// "/api/users", take precedence over the prefix for their http methods, while
+// the requests of other methods fall back to the prefix, as with any wildcard.
+func (mx *Mux) Prefix(prefix string, handler http.Handler) {
+	im := mx.With().(*Mux)
+	im.emptyWildcard = true
+	im.handle(mALL, strings.TrimSuffix(prefix, "/")+"/*", handler)
+}
+
+// Mount attaches another http.Handler or chi Router as a subrouter along a routing
+// path. It's very useful to split up a large API as many independent routers and
+// compose them as a single service using Mount. See _examples/.
+//
+// Note that Mount() simply sets a wildcard along the `pattern` that will continue
+// routing at the `handler`, which in most cases is another chi.Router. As a result,
+// if you define two Mount() routes on the exact same pattern the mount will panic.
+// The same `handler` may be mounted along several patterns though, in which case
+// the URL params and the routing pattern of a request are those of the pattern
+// it was routed along. The `pattern` may have URL params, ie. "/tenants/{tenant}",
+// which are available to the mounted handler with URLParam. A mounted chi Router
+// inherits the not found and method not allowed handlers of the router it's
+// mounted on first, unless it has its own.
+//
+// A request to a mounted router executes the middlewares of the parent router
+// first, followed by the inline middlewares of the Mount() route, if any, then
+// the middlewares of the mounted router and its inline middlewares, ahead of
+// the handler. This applies along any number of nested mounts.
+func (mx *Mux) Mount(pattern string, handler http.Handler) {
+	if err := mx.TryMount(pattern, handler); err != nil {
+		panic(err.Error())
+	}
+}
+
+// TryMount attaches another http.Handler or chi Router as a subrouter along a
+// routing path, in the same manner as Mount, but returns an error rather than
+// panic when the `pattern` is invalid or already mounted, ie. to load routes
+// programmatically.
+func (mx *Mux) TryMount(pattern string, handler http.Handler) error {
+	return mx.tryMount(pattern, handler, MountOpts{})
+}
+
+// MountOpts are the options of a mount, see MountWithOpts.
+type MountOpts struct {
+	// NoStub disables the routes of the bare `pattern` and the `pattern` with a
+	// trailing slash, which Mount registers along with the wildcard when the
+	// pattern has no trailing slash.
+	NoStub bool
+}
+
+// MountWithOpts attaches another http.Handler or chi Router as a subrouter
+// along a routing path, in the same manner as Mount, with the options `opts`.
+//
+// With NoStub, only the "/*" wildcard of the `pattern` routes to the mounted
+// handler. A request to the bare pattern, ie. "/api" for a mount along "/api",
+// is no longer routed to the handler but to a sibling route of the same
+// pattern, if any, or else it's responded with the not found handler of the
+// mux. The pattern with a trailing slash, ie. "/api/", is still routed to the
+// mounted handler by the wildcard, unless a sibling route of that pattern
+// takes precedence.
+func (mx *Mux) MountWithOpts(pattern string, handler http.Handler, opts MountOpts) {
+	if err := mx.tryMount(pattern, handler, opts); err != nil {
+		panic(err.Error())
+	}
+}
+
+func (mx *Mux) tryMount(pattern string, handler http.Handler, opts MountOpts) error {
+	if err := checkPattern(pattern); err != nil {
+		return err
+	}
+
+	// Provide runtime safety for ensuring a pattern isn't mounted on an existing
+	// routing pattern.
+	mx.mutex().RLock()
+	exists := mx.tree.findPattern(pattern+"*") || mx.tree.findPattern(pattern+"/*")
+	mx.mutex().RUnlock()
+	if exists {
+		return fmt.Errorf("chi: attempting to Mount() a handler on an existing path, '%s'", pattern)
+	}
+
+	// Assign sub-Router's with the parent not found & method not allowed handler if not specified.
+	subr, ok := handler.(*Mux)
+	if ok {
+		mx.mutex().RLock()
+		notFound, methodNotAllowed := mx.notFoundHandler, mx.methodNotAllowedHandler
+		notFoundHandlers := make(map[string]http.HandlerFunc, len(mx.notFoundHandlers))
+		for method, hFn := range mx.notFoundHandlers {
+			notFoundHandlers[method] = hFn
+		}
+		notFoundPrefixes := make(map[string]http.HandlerFunc, len(mx.notFoundPrefixes))
+		for prefix, hFn := range mx.notFoundPrefixes {
+			notFoundPrefixes[prefix] = hFn
+		}
+		mx.mutex().RUnlock()
+
+		if notFound != nil {
+			subr.inheritNotFound(notFound)
+		}
+		for method, hFn := range notFoundHandlers {
+			subr.setNotFoundFor(method, hFn, true)
+		}
+		for prefix, hFn := range notFoundPrefixes {
+			subr.setNotFoundPrefix(prefix, hFn, true)
+		}
+		if methodNotAllowed != nil {
+			subr.setMethodNotAllowed(methodNotAllowed, true)
+		}
+	}
+	if ok {
+		for status, hFn := range mx.inheritedErrorHandlers {
+			subr.inheritErrorHandler(status, hFn)
+		}
+		for status, hFn := range mx.errorHandlers {
+			subr.inheritErrorHandler(status, hFn)
+		}
+	}
+
+	// Wrap the sub-router in a handlerFunc to scope the request path for routing.
+	mountHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rctx := RouteContext(r.Context())
+		routePath := nextRoutePath(rctx)
+		rctx.mountPrefix += mountPrefix(rctx, r, routePath)
+		rctx.RoutePath = routePath
+		handler.ServeHTTP(w, r)
+	})
+
+	if pattern[len(pattern)-1] != '/' {
+		if !opts.NoStub {
+			if _, err := mx.tryHandle(mALL|mSTUB, pattern, mountHandler); err != nil {
+				return err
+			}
+			if _, err := mx.tryHandle(mALL|mSTUB, pattern+"/", mountHandler); err != nil {
+				return err
+			}
+		}
+		pattern += "/"
+	}
+
+	method := mALL
+	subroutes, _ := handler.(Routes)
+	if subroutes != nil {
+		method |= mSTUB
+	}
+	n, err := mx.tryHandle(method, pattern+"*", mountHandler)
+	if err != nil {
+		return err
+	}
+
+	if subroutes != nil {
+		mx.mutex().Lock()
+		n.subroutes = subroutes
+		mx.mutex().Unlock()
+	}
+	return nil
+}
+
+// MountBalanced attaches several backend handlers along a routing path in the
+// same manner as Mount, and distributes the requests across them with a smooth
+// weighted round-robin, ie. the weights 2 and 1 serve the backends in the order
+// of 0, 1, 0. A nil `weights` balances the requests evenly, otherwise it must
+// have a weight for each of the `handlers`. The cycle of the backends is
+// computed once, as long as the sum of the weights divided by their greatest
+// common divisor, so the weights are meant as small ratios. The backends don't
+// inherit the not found and method not allowed handlers of the mux, as they're
+// not mounted routers but a single handler.
+func (mx *Mux) MountBalanced(pattern string, handlers []http.Handler, weights []int) {
+	mx.Mount(pattern, newBalancedHandler(handlers, weights))
+}
+
+// newBalancedHandler returns a balancedHandler of the `handlers` as per their
+// `weights`, see MountBalanced.
+func newBalancedHandler(handlers []http.Handler, weights []int) *balancedHandler {
+	if len(handlers) == 0 {
+		panic("chi: MountBalanced requires at least one handler")
+	}
+	if weights == nil {
+		weights = make([]int, len(handlers))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	if len(weights) != len(handlers) {
+		panic(fmt.Sprintf("chi: MountBalanced expects %d weights, got %d", len(handlers), len(weights)))
+	}
+
+	var total int
+	for _, w := range weights {
+		if w < 0 {
+			panic(fmt.Sprintf("chi: invalid MountBalanced weight %d", w))
+		}
+		total += w
+	}
+	if total == 0 {
+		panic("chi: MountBalanced requires a positive weight")
+	}
+
+	// The cycle of the weights divided by their greatest common divisor is the
+	// same, ie. 1, 0 for the weights 100 and 200 as for 1 and 2
+	divisor := 0
+	for _, w := range weights {
+		divisor = gcd(divisor, w)
+	}
+	total /= divisor
+
+	// Compute a full cycle of the smooth weighted round-robin ahead of time, so
+	// that picking a backend is a lock-free lookup
+	schedule := make([]http.Handler, total)
+	current := make([]int, len(weights))
+	for i := range schedule {
+		best := 0
+		for j, w := range weights {
+			current[j] += w / divisor
+			if current[j] > current[best] {
+				best = j
+			}
+		}
+		current[best] -= total
+		schedule[i] = handlers[best]
+	}
+	return &balancedHandler{schedule: schedule}
+}
+
+// gcd returns the greatest common divisor of `a` and `b`.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// balancedHandler serves the requests with the handlers of its schedule in turn.
+type balancedHandler struct {
+	next     uint64 // first field for the 64-bit alignment of atomic operations
+	schedule []http.Handler
+}
+
+func (b *balancedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	i := (atomic.AddUint64(&b.next, 1) - 1) % uint64(len(b.schedule))
+	b.schedule[i].ServeHTTP(w, r)
+}
+
+// Subrouter returns the router mounted along the routing `pattern`, including
+// the routers mounted by sub-routers, ie. to test or serve a slice of the API on
+// its own. It returns false if no chi Router is mounted along the `pattern`.
+func (mx *Mux) Subrouter(pattern string) (http.Handler, bool) {
+	pattern = strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "/")
+	for _, route := range mx.Routes() {
+		if route.SubRoutes == nil {
+			continue
+		}
+		prefix := strings.TrimSuffix(route.Pattern, "/*")
+		if prefix == pattern {
+			h, ok := route.SubRoutes.(http.Handler)
+			return h, ok
+		}
+		if subMux, ok := route.SubRoutes.(*Mux); ok && strings.HasPrefix(pattern, prefix+"/") {
+			if h, ok := subMux.Subrouter(pattern[len(prefix):]); ok {
+				return h, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Routes returns a slice of routing information from the tree,
+// useful for traversing available routes of a router.
+//
+// The returned slice is a snapshot of the routing tree, which is safe to call
+// at any time, including while serving requests or concurrently with the
+// registration of routes. Note that routes must still be registered before
+// serving requests, as the routing of requests doesn't synchronize with it.
+func (mx *Mux) Routes() []Route {
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
+	return mx.tree.routes()
+}
+
+// Validate returns the conflicts between the routes of the mux and its
+// sub-routers, being the pairs of routes handling a common http method whose
+// patterns both match some request path. Such a path is routed to the route
+// with the static, regexp, param and wildcard segments in that order of
+// precedence, or to the route registered first for regexp params in the same
+// segment, so a conflict may be intended, ie. "/users/new" ahead of
+// "/users/{id}", while it's often a mistake, ie. "/users/{id:[0-9]+}" and
+// "/users/{code:[0-9]{3}}". The former are marked with Conflict.Precedence.
+// The conflicts are sorted by pattern.
+func (mx *Mux) Validate() []Conflict {
+	var routes []validateRoute
+	collectValidateRoutes(mx, "", &routes)
+	sort.Sort(byValidatePattern(routes))
+
+	var conflicts []Conflict
+	for i, a := range routes {
+		for _, b := range routes[i+1:] {
+			var methods []string
+			for m := range a.methods {
+				if b.methods[m] {
+					methods = append(methods, m)
+				}
+			}
+			if len(methods) == 0 {
+				continue
+			}
+			if path, ok := patOverlap(a.overlap, b.overlap); ok {
+				sort.Strings(methods)
+				conflicts = append(conflicts, Conflict{[2]string{a.pattern, b.pattern}, methods, path, patPrecedence(a.pattern, b.pattern)})
+			}
+		}
+	}
+	return conflicts
+}
+
+// validateRoute is a route checked by Validate, with its full pattern and the
+// set of its http methods.
+type validateRoute struct {
+	pattern string
+	methods map[string]bool
+	overlap *overlapPattern
+}
+
+// collectValidateRoutes appends the routes of `r` and its sub-routers to the
+// `routes`, with the `prefix` of the mount of `r`.
+func collectValidateRoutes(r Routes, prefix string, routes *[]validateRoute) {
+	for _, route := range r.Routes() {
+		if route.SubRoutes != nil {
+			collectValidateRoutes(route.SubRoutes, prefix+strings.TrimSuffix(route.Pattern, "/*"), routes)
+			continue
+		}
+		methods := make(map[string]bool, len(route.Handlers))
+		for m := range route.Handlers {
+			if m != "*" {
+				methods[m] = true
+			}
+		}
+		*routes = append(*routes, validateRoute{prefix + route.Pattern, methods, newOverlapPattern(prefix + route.Pattern)})
+	}
+}
+
+type byValidatePattern []validateRoute
+
+func (s byValidatePattern) Len() int           { return len(s) }
+func (s byValidatePattern) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byValidatePattern) Less(i, j int) bool { return s[i].pattern < s[j].pattern }
+
+// Len returns the number of routes registered on the mux and its inline muxes,
+// counting each call to Handle, a http method function such as Get, or Mount
+// once, regardless of the number of http methods it routes. Registering a
+// handler again along the same http methods and routing path replaces the
+// route, and doesn't count again. The routes of sub-routers aren't counted, see
+// Routes to walk them.
+func (mx *Mux) Len() int {
+	root := mx
+	for root.inline && root.parent != nil {
+		root = root.parent
+	}
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
+	return root.routeCount
+}
+
+// RoutePatterns returns the sorted routing patterns of the mux, including the
+// full patterns of the routes of its sub-routers, ie. "/admin/users/{id}" for a
+// route of a router mounted along "/admin". See PatternToRegexp to convert them
+// into regular expressions.
+func (mx *Mux) RoutePatterns() []string {
+	patterns := routePatterns(mx, "", "")
+	sort.Strings(patterns)
+	return patterns
+}
+
+// PatternsForMethod returns the sorted routing patterns of the mux which have
+// a handler for the http `method`, including the routes handling any method,
+// in the same manner as RoutePatterns.
+func (mx *Mux) PatternsForMethod(method string) []string {
+	patterns := routePatterns(mx, "", strings.ToUpper(method))
+	sort.Strings(patterns)
+	return patterns
+}
+
+// routePatterns returns the full routing patterns of the routes of `r` with a
+// handler for the `method`, or of all the routes if it's empty.
+func routePatterns(r Routes, prefix, method string) []string {
+	var patterns []string
+	for _, route := range r.Routes() {
+		if route.SubRoutes != nil {
+			subPrefix := prefix + strings.TrimSuffix(route.Pattern, "/*")
+			patterns = append(patterns, routePatterns(route.SubRoutes, subPrefix, method)...)
+			continue
+		}
+		if method != "" && route.Handlers[method] == nil && route.Handlers["*"] == nil {
+			continue
+		}
+		patterns = append(patterns, prefix+route.Pattern)
+	}
+	return patterns
 }
 
-// Mount attaches another http.Handler or chi Router as a subrouter along a routing
-// path. It's very useful to split up a large API as many independent routers and
-// compose them as a single service using Mount. See _examples/.
-//
-// Note that Mount() simply sets a wildcard along the `pattern` that will continue
-// routing at the `handler`, which in most cases is another chi.Router. As a result,
-// if you define two Mount() routes on the exact same pattern the mount will panic.
-func (mx *Mux) Mount(pattern string, handler http.Handler) {
-	// Provide runtime safety for ensuring a pattern isn't mounted on an existing
-	// routing pattern.
-	if mx.tree.findPattern(pattern+"*") || mx.tree.findPattern(pattern+"/*") {
-		panic(fmt.Sprintf("chi: attempting to Mount() a handler on an existing path, '%s'", pattern))
-	}
+// ExportJSON writes the routes of the mux and its sub-routers to `w` as a JSON
+// array sorted by pattern and method, ie. for documentation generators or API
+// gateways. Each route has its http method, or "*" for a route of any method,
+// its full routing pattern, its configuration set with WithConfig, and the
+// names of the functions of its middlewares from the outermost one, qualified
+// by their package name without its import path, ie. "middleware.Logger". The
+// configuration values must be serializable as JSON.
+func (mx *Mux) ExportJSON(w io.Writer) error {
+	routes := exportRoutes(mx, "", nil, nil)
+	sort.Sort(exportedRoutes(routes))
 
-	// Assign sub-Router's with the parent not found & method not allowed handler if not specified.
-	subr, ok := handler.(*Mux)
-	if ok && subr.notFoundHandler == nil && mx.notFoundHandler != nil {
-		subr.NotFound(mx.notFoundHandler)
-	}
-	if ok && subr.methodNotAllowedHandler == nil && mx.methodNotAllowedHandler != nil {
-		subr.MethodNotAllowed(mx.methodNotAllowedHandler)
-	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(routes)
+}
 
-	// Wrap the sub-router in a handlerFunc to scope the request path for routing.
-	mountHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rctx := RouteContext(r.Context())
-		rctx.RoutePath = mx.nextRoutePath(rctx)
-		handler.ServeHTTP(w, r)
-	})
+// exportedRoute is a route written by ExportJSON.
+type exportedRoute struct {
+	Method      string                 `json:"method"`
+	Pattern     string                 `json:"pattern"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	Middlewares []string               `json:"middlewares,omitempty"`
+}
 
-	if pattern == "" || pattern[len(pattern)-1] != '/' {
-		mx.handle(mALL|mSTUB, pattern, mountHandler)
-		mx.handle(mALL|mSTUB, pattern+"/", mountHandler)
-		pattern += "/"
+type exportedRoutes []exportedRoute
+
+func (s exportedRoutes) Len() int      { return len(s) }
+func (s exportedRoutes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s exportedRoutes) Less(i, j int) bool {
+	if s[i].Pattern != s[j].Pattern {
+		return s[i].Pattern < s[j].Pattern
 	}
+	return s[i].Method < s[j].Method
+}
 
-	method := mALL
-	subroutes, _ := handler.(Routes)
-	if subroutes != nil {
-		method |= mSTUB
+// exportRoutes returns the routes of `r` along the `prefix`, with the route
+// configuration merged over the `config` and the middlewares following the
+// `mws` of the routers `r` is mounted on.
+func exportRoutes(r Routes, prefix string, config map[string]interface{}, mws Middlewares) []exportedRoute {
+	mws = append(mws[:len(mws):len(mws)], r.Middlewares()...)
+	configs := exportRouteConfigs(r)
+
+	var routes []exportedRoute
+	for _, route := range r.Routes() {
+		if route.SubRoutes != nil {
+			subMws := mws
+			if chain, ok := route.Handlers["*"].(*ChainHandler); ok {
+				subMws = append(mws[:len(mws):len(mws)], chain.Middlewares...)
+			}
+			subConfig := mergeRouteConfig(config, configs[exportRouteKey{mALL, route.Pattern}])
+			subPrefix := prefix + strings.TrimSuffix(route.Pattern, "/*")
+			routes = append(routes, exportRoutes(route.SubRoutes, subPrefix, subConfig, subMws)...)
+			continue
+		}
+
+		for method, handler := range route.Handlers {
+			// The route of any method also has a handler for each of them
+			if route.Handlers["*"] != nil && method != "*" {
+				continue
+			}
+
+			routeMws := mws
+			if chain, ok := handler.(*ChainHandler); ok {
+				routeMws = append(mws[:len(mws):len(mws)], chain.Middlewares...)
+			}
+			names := make([]string, len(routeMws))
+			for i, mw := range routeMws {
+				names[i] = exportFuncName(mw)
+			}
+			mt := mALL
+			if method != "*" {
+				mt = methodMap[method]
+			}
+
+			routes = append(routes, exportedRoute{
+				Method:      method,
+				Pattern:     prefix + route.Pattern,
+				Config:      mergeRouteConfig(config, configs[exportRouteKey{mt, route.Pattern}]),
+				Middlewares: names,
+			})
+		}
 	}
-	n := mx.handle(method, pattern+"*", mountHandler)
+	return routes
+}
 
-	if subroutes != nil {
-		n.subroutes = subroutes
+// exportRouteConfigs returns the configurations of the routes of `r` by http
+// method and pattern, if `r` is a *Mux.
+func exportRouteConfigs(r Routes) map[exportRouteKey]map[string]interface{} {
+	mx, ok := r.(*Mux)
+	if !ok {
+		return nil
 	}
+
+	configs := map[exportRouteKey]map[string]interface{}{}
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
+	mx.tree.walk(func(eps endpoints, subroutes Routes) bool {
+		for mt, ep := range eps {
+			if ep.config != nil && ep.paramDefaults == nil {
+				configs[exportRouteKey{mt, ep.pattern}] = ep.config
+			}
+		}
+		return false
+	})
+	return configs
 }
 
-// Routes returns a slice of routing information from the tree,
-// useful for traversing available routes of a router.
-func (mx *Mux) Routes() []Route {
-	return mx.tree.routes()
+// exportRouteKey identifies the route of a http method along a pattern.
+type exportRouteKey struct {
+	method  methodTyp
+	pattern string
+}
+
+// exportFuncName returns the name of the function `fn` qualified by its package
+// name, without the import path, ie. "middleware.Logger".
+func exportFuncName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
 }
 
 // Middlewares returns a slice of middleware handler functions.
 func (mx *Mux) Middlewares() Middlewares {
+	mx.mutex().RLock()
+	defer mx.mutex().RUnlock()
 	return mx.middlewares
 }
 
@@ -327,19 +2102,173 @@ func (mx *Mux) Middlewares() Middlewares {
 // Note: the *Context state is updated during execution, so manage
 // the state carefully or make a NewRouteContext().
 func (mx *Mux) Match(rctx *Context, method, path string) bool {
+	return mx.MatchRoute(rctx, method, path) == nil
+}
+
+// MatchRoute searches the routing tree for a handler that matches the
+// method/path like Match, and returns ErrRouteNotFound or ErrMethodNotAllowed
+// when there's no such handler, in the same manner as routing a http request
+// responds with a 404 or a 405. A sub-router which isn't a *Mux only reports
+// ErrRouteNotFound.
+func (mx *Mux) MatchRoute(rctx *Context, method, path string) error {
 	m, ok := methodMap[method]
 	if !ok {
-		return false
+		return ErrMethodNotAllowed
 	}
 
-	node, _, h := mx.tree.FindRoute(rctx, m, path)
+	_, node, h := mx.resolve(rctx, m, path)
 
 	if node != nil && node.subroutes != nil {
-		rctx.RoutePath = mx.nextRoutePath(rctx)
-		return node.subroutes.Match(rctx, method, rctx.RoutePath)
+		rctx.RoutePath = nextRoutePath(rctx)
+		if !node.subroutes.Match(rctx, method, rctx.RoutePath) {
+			return ErrRouteNotFound
+		}
+		return nil
+	}
+
+	if h == nil {
+		if rctx.methodNotAllowed {
+			return ErrMethodNotAllowed
+		}
+		return ErrRouteNotFound
+	}
+	return nil
+}
+
+// FindRouteInto searches the routing tree for the endpoint handler of the
+// method/path like Match, with the routing context `rctx` of the caller, and
+// returns the handler without executing it or any middleware. The routes of a
+// mounted chi Router are searched in that router, while the mount of another
+// http.Handler is returned, which expects `rctx` as the routing context of the
+// request it serves. It's meant for embedding the router as a matcher in a
+// tight loop, where reusing the same context avoids allocations.
+//
+// The context is reset ahead of the search, and holds the URL params and the
+// routing patterns of the matched route once it returns, which are overwritten
+// by the next search. A context must not be shared across goroutines, nor be
+// the routing context of a request being served.
+func (mx *Mux) FindRouteInto(rctx *Context, method, path string) (http.Handler, bool) {
+	rctx.Reset()
+	rctx.Routes = mx
+	m, ok := methodMap[method]
+	if !ok {
+		return nil, false
+	}
+	_, _, h := mx.resolve(rctx, m, path)
+	return h, h != nil
+}
+
+// resolve searches the routing tree for the route of the method/path, and the
+// routes of the chi Routers mounted along the way, and returns the mux of the
+// route along with its node and handler, or the node of the route matching the
+// path without a handler for the method. It's the search shared by Match,
+// FindRouteInto, LookupRoute and Handler.
+func (mx *Mux) resolve(rctx *Context, method methodTyp, path string) (*Mux, *node, http.Handler) {
+	rn, h := mx.findRoute(rctx, method, path)
+	if rn != nil && rn.subroutes != nil {
+		if subMux, ok := rn.subroutes.(*Mux); ok {
+			rctx.RoutePath = nextRoutePath(rctx)
+			return subMux.resolve(rctx, method, rctx.RoutePath)
+		}
+	}
+	return mx, rn, h
+}
+
+// ResolveChain searches the routing tree for the route of the method/path, and
+// returns the full handler serving the requests to that route, made of the mux
+// middleware stack, the middlewares set with UseOnMatch and UseFor, and the
+// inline middlewares and handler of the route, along with the routing pattern
+// of the route. It's meant for a gateway to cache the handlers of its hot paths
+// and skip the search of the routing tree, as the handler matches the path of
+// each request it serves against the resolved route alone.
+//
+// The URL params are extracted from the path of each request, so a handler may
+// be cached by pattern, while a request whose path doesn't match the resolved
+// route is routed by the mux as usual. The routes of a mounted router are still
+// searched by that router once the request reaches its mount, whose pattern is
+// returned. The handler is safe for concurrent use, and is resolved with the
+// middlewares of the mux at the time of the call.
+func (mx *Mux) ResolveChain(method, path string) (http.Handler, string, bool) {
+	m, ok := methodMap[method]
+	if !ok {
+		return nil, "", false
+	}
+	root := mx
+	for root.inline && root.parent != nil {
+		root = root.parent
+	}
+
+	root.lazyInit()
+	rctx := NewRouteContext()
+	rctx.Routes = root
+	rn, h := root.findRoute(rctx, m, path)
+	if h == nil {
+		return nil, "", false
+	}
+	route := rn.endpoints[m].tree(m)
+
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root.route(w, r, route)
+	})
+
+	root.mutex().RLock()
+	handler := root.chain(root.middlewares, routed)
+	root.mutex().RUnlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root.serve(w, r, handler)
+	}), rctx.RoutePattern(), true
+}
+
+// LookupRoute searches the routing tree for the route that the request would
+// be routed to, without executing its handler, and returns the full routing
+// pattern of the route across sub-routers. It's meant for middlewares that
+// need to peek at the route before the request is routed, for example to skip
+// authentication for public routes.
+//
+// The search uses a throwaway routing context, so the request context is left
+// untouched. Note that the routing tree is searched once more when the request
+// is routed, so each call roughly doubles the cost of routing the request.
+func (mx *Mux) LookupRoute(r *http.Request) (string, bool) {
+	routePath, routeMethod := r.URL.Path, r.Method
+	if r.URL.RawPath != "" {
+		routePath = r.URL.RawPath
+	}
+	if rctx, _ := r.Context().Value(RouteCtxKey).(*Context); rctx != nil {
+		if rctx.RoutePath != "" {
+			routePath = rctx.RoutePath
+		}
+		if rctx.RouteMethod != "" {
+			routeMethod = rctx.RouteMethod
+		}
 	}
 
-	return h != nil
+	mx.lazyInit()
+	tctx := mx.pool.Get().(*Context)
+	tctx.Reset()
+	tctx.requestHeader = r.Header
+	defer mx.pool.Put(tctx)
+
+	if !mx.Match(tctx, routeMethod, routePath) {
+		return "", false
+	}
+	return tctx.RoutePattern(), true
+}
+
+// Handler returns the handler that would serve a request for the method/path,
+// composed of the middleware stack of the router and the route's endpoint
+// handler, without serving it. It's useful to unit test an endpoint along with
+// its middlewares. The handler is resolved like ResolveChain, so the requests
+// it serves go through everything a request served by the mux does, ie. the
+// default headers and the middlewares set with UseOnMatch, with their URL
+// params extracted from their own path.
+func (mx *Mux) Handler(method, path string) (http.Handler, bool) {
+	// Search the routing tree, including sub-routers
+	if !mx.Match(NewRouteContext(), method, path) {
+		return nil, false
+	}
+	h, _, ok := mx.ResolveChain(method, path)
+	return h, ok
 }
 
 // NotFoundHandler returns the default Mux 404 responder whenever a route
@@ -351,6 +2280,37 @@ func (mx *Mux) NotFoundHandler() http.HandlerFunc {
 	return http.NotFound
 }
 
+// notFoundHandlerFor returns the 404 responder for the request `r` routed with
+// the http `method`, being the handler set with NotFoundPrefix for the longest
+// prefix of the URL path, or the handler set with NotFoundFor for the method,
+// or else NotFoundHandler.
+func (mx *Mux) notFoundHandlerFor(method string, r *http.Request) http.HandlerFunc {
+	if len(mx.notFoundPrefixes) > 0 {
+		path := r.URL.Path
+		if r.URL.RawPath != "" {
+			path = r.URL.RawPath
+		}
+		var longest string
+		var hFn http.HandlerFunc
+		for prefix, h := range mx.notFoundPrefixes {
+			if len(prefix) < len(longest) || !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			if len(path) > len(prefix) && path[len(prefix)] != '/' {
+				continue
+			}
+			longest, hFn = prefix, h
+		}
+		if hFn != nil {
+			return hFn
+		}
+	}
+	if hFn := mx.notFoundHandlers[method]; hFn != nil {
+		return hFn
+	}
+	return mx.NotFoundHandler()
+}
+
 // MethodNotAllowedHandler returns the default Mux 405 responder whenever
 // a method cannot be resolved for a route.
 func (mx *Mux) MethodNotAllowedHandler() http.HandlerFunc {
@@ -360,42 +2320,221 @@ func (mx *Mux) MethodNotAllowedHandler() http.HandlerFunc {
 	return methodNotAllowedHandler
 }
 
+// ErrorHandler returns the Mux responder of the error `status`, set with
+// SetErrorHandler, NotFound or MethodNotAllowed, or else a plain text response
+// with the status text.
+func (mx *Mux) ErrorHandler(status int) http.HandlerFunc {
+	switch status {
+	case http.StatusNotFound:
+		return mx.NotFoundHandler()
+	case http.StatusMethodNotAllowed:
+		return mx.MethodNotAllowedHandler()
+	}
+	if hFn := mx.errorHandlers[status]; hFn != nil {
+		return hFn
+	}
+	if hFn := mx.inheritedErrorHandlers[status]; hFn != nil {
+		return hFn
+	}
+	return errorHandler(status)
+}
+
+// Error responds to the request `r` with the error `status`, using the error
+// handler set with SetErrorHandler on the innermost router serving the request,
+// or inherited by a mounted router from its parent, or else a plain text
+// response with the status text. It's meant for middlewares responding with an
+// error, to let the router customize the response.
+func Error(w http.ResponseWriter, r *http.Request, status int) {
+	if rctx, _ := r.Context().Value(RouteCtxKey).(*Context); rctx != nil {
+		if rctx.mux != nil {
+			rctx.mux.ErrorHandler(status)(w, r)
+			return
+		}
+		if mx, ok := rctx.Routes.(*Mux); ok {
+			mx.ErrorHandler(status)(w, r)
+			return
+		}
+	}
+	errorHandler(status)(w, r)
+}
+
+func errorHandler(status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(status), status)
+	}
+}
+
 // buildRouteHandler builds the single mux handler that is a chain of the middleware
 // stack, as defined by calls to Use(), and the tree router (Mux) itself. After this
 // point, no other middlewares can be registered on this Mux's stack. But you can still
 // compose additional middlewares via Group()'s or using a chained middleware handler.
+//
+// The mux lock must be held by the caller, so that the check of Use() against
+// routes that are registered concurrently is deterministic.
 func (mx *Mux) buildRouteHandler() {
-	mx.handler = chain(mx.middlewares, http.HandlerFunc(mx.routeHTTP))
+	// The routes of a clone are chained with the middlewares of the original
+	// mux, so rechain them with its own on the first build
+	if mx.handler == nil {
+		mx.tree.walk(func(eps endpoints, subroutes Routes) bool {
+			mx.chainEndpoints(eps, mALL|mSTUB)
+			return false
+		})
+		mx.missHandler = nil
+		if len(mx.missMiddlewares) > 0 {
+			mx.missHandler = mx.chain(mx.missMiddlewares, http.HandlerFunc(serveChained))
+		}
+	}
+	mx.handler = mx.chain(mx.middlewares, http.HandlerFunc(mx.routeHTTP))
+	mx.served.Store(muxHandler{mx.handler})
+}
+
+// chain builds a http.Handler of the middlewares `mws` and the endpoint `h`,
+// with the middlewares timed if the mux has WithMiddlewareTiming.
+func (mx *Mux) chain(mws []func(http.Handler) http.Handler, h http.Handler) http.Handler {
+	if mx.middlewareTiming {
+		mws = timeMiddlewares(mws)
+	}
+	return chain(mws, h)
 }
 
 // handle registers a http.Handler in the routing tree for a particular http method
 // and routing pattern.
 func (mx *Mux) handle(method methodTyp, pattern string, handler http.Handler) *node {
-	if len(pattern) == 0 || pattern[0] != '/' {
-		panic(fmt.Sprintf("chi: routing pattern must begin with '/' in '%s'", pattern))
+	n, err := mx.tryHandle(method, pattern, handler)
+	if err != nil {
+		panic(err.Error())
+	}
+	return n
+}
+
+// tryHandle registers a http.Handler in the routing tree in the same manner as
+// handle, but returns an error for an invalid routing pattern.
+func (mx *Mux) tryHandle(method methodTyp, pattern string, handler http.Handler) (n *node, err error) {
+	if err := checkPattern(pattern); err != nil {
+		return nil, err
 	}
 
+	root := mx
+	for root.inline && root.parent != nil {
+		root = root.parent
+	}
+	// Wrap the handler before locking the mux, as the wrapper may inspect it
+	if root.handlerWrapper != nil && method&mSTUB == 0 {
+		handler = root.handlerWrapper(pattern, handler)
+	}
+
+	mx.mutex().Lock()
+	defer mx.mutex().Unlock()
+
 	// Build the final routing handler for this Mux.
 	if !mx.inline && mx.handler == nil {
 		mx.buildRouteHandler()
 	}
 
-	// Build endpoint handler with inline middlewares for the route
+	// Build endpoint handler with inline middlewares for the route. The
+	// middlewares wrap each route's own handler, so the chain can't be shared
+	// across the routes of an inline mux.
 	var h http.Handler
 	if mx.inline {
-		mx.handler = http.HandlerFunc(mx.routeHTTP)
-		h = Chain(mx.middlewares...).Handler(handler)
+		if mx.handler == nil {
+			mx.handler = http.HandlerFunc(mx.routeHTTP)
+			mx.served.Store(muxHandler{mx.handler})
+		}
+		if root.middlewareTiming && len(mx.middlewares) > 0 {
+			h = &ChainHandler{mx.middlewares, handler, root.chain(mx.middlewares, handler)}
+		} else {
+			h = Chain(mx.middlewares...).Handler(handler)
+		}
 	} else {
 		h = handler
 	}
+	if len(mx.accepts) > 0 {
+		h = &acceptHandler{mediaTypes: mx.accepts, handler: h}
+	}
+	if len(mx.headers) > 0 && method&mSTUB == 0 {
+		h = &headerHandler{headers: mx.headers, handler: h}
+	}
+
+	// Add the endpoint to the tree and return the node, counting the routes
+	// registered for the first time along their http methods, as well as the
+	// mounts but not their stubs
+	n = mx.tree.InsertRoute(method, pattern, h)
+	if (method&mSTUB == 0 || strings.HasSuffix(pattern, "*")) && n.register(method) {
+		root.routeCount++
+	}
+	n.setEndpointConfig(method, mx.config)
+	n.setEndpointPriority(method, mx.priority)
+	n.setEndpointTimeout(method, mx.timeout)
+	if mx.priority != 0 {
+		mx.tree.prioritized = true
+	}
+	root.chainEndpoints(n.endpoints, method)
+
+	// Route the path without the segment of a param default as well, for the
+	// http methods without an explicit route along that path, which takes
+	// precedence regardless of the order of registration
+	if path, value, ok := patDefaultParam(pattern); ok {
+		dmethod := method &^ mx.tree.explicitMethods(method, path)
+		if dmethod&^mSTUB != 0 {
+			dn := mx.tree.insertRoute(dmethod, path, pattern, h)
+			dn.setEndpointDefaults(dmethod, []string{value})
+			dn.setEndpointConfig(dmethod, mx.config)
+			dn.setEndpointPriority(dmethod, mx.priority)
+			dn.setEndpointTimeout(dmethod, mx.timeout)
+			root.chainEndpoints(dn.endpoints, dmethod)
+		}
+	}
+
+	// Route the base path of a trailing wildcard, see WithEmptyWildcard and
+	// Prefix
+	if method&mSTUB == 0 && len(pattern) > 2 && strings.HasSuffix(pattern, "/*") {
+		if root.emptyWildcard || mx.emptyWildcard {
+			dn := mx.tree.insertRoute(method, pattern[:len(pattern)-2], pattern, h)
+			dn.setEndpointDefaults(method, []string{""})
+			dn.setEndpointConfig(method, mx.config)
+			dn.setEndpointPriority(method, mx.priority)
+			dn.setEndpointTimeout(method, mx.timeout)
+			root.chainEndpoints(dn.endpoints, method)
+		}
+	}
+	return n, nil
+}
+
+// checkPattern returns an error if the routing `pattern` is invalid, before
+// any of it is added to the routing tree.
+func checkPattern(pattern string) (err error) {
+	if len(pattern) == 0 {
+		return errors.New("chi: routing pattern must not be empty")
+	}
+	if pattern[0] != '/' {
+		return fmt.Errorf("chi: routing pattern must begin with '/' in '%s'", pattern)
+	}
 
-	// Add the endpoint to the tree and return the node
-	return mx.tree.InsertRoute(method, pattern, h)
+	// The tree panics on an invalid pattern segment, ie. a param without its
+	// closing delimiter
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			msg, ok := rvr.(string)
+			if !ok {
+				panic(rvr)
+			}
+			err = errors.New(msg)
+		}
+	}()
+	patValidate(pattern)
+	return nil
 }
 
 // routeHTTP routes a http.Request through the Mux routing tree to serve
 // the matching handler for a particular http method.
 func (mx *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
+	mx.route(w, r, nil)
+}
+
+// route routes a http.Request like routeHTTP, matching its path against the
+// routing tree `resolved` of a single route ahead of the routing tree of the
+// mux, see ResolveChain.
+func (mx *Mux) route(w http.ResponseWriter, r *http.Request, resolved *node) {
 	// Grab the route context object
 	rctx := r.Context().Value(RouteCtxKey).(*Context)
 
@@ -407,6 +2546,35 @@ func (mx *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
 		} else {
 			routePath = r.URL.Path
 		}
+		// The empty path of a request in the absolute form is the root path
+		if routePath == "" {
+			routePath = "/"
+		}
+
+		// Reject a malformed path, whose routing is undefined
+		if !validPath(r.URL) {
+			rctx.routed = false
+			hFn := mx.badRequestHandler
+			if hFn == nil {
+				hFn = mx.ErrorHandler(http.StatusBadRequest)
+			}
+			mx.serveMiss(w, r, hFn)
+			return
+		}
+	}
+
+	// Reject a path longer than the limit before searching the tree
+	if mx.maxURLLength > 0 && len(routePath) > mx.maxURLLength {
+		rctx.routed = false
+		mx.serveMiss(w, r, mx.ErrorHandler(http.StatusRequestURITooLong))
+		return
+	}
+
+	// Reject the TRACE requests regardless of the routes
+	if mx.traceStatus != 0 && (r.Method == "TRACE" || rctx.RouteMethod == "TRACE") {
+		rctx.routed = false
+		mx.serveMiss(w, r, mx.ErrorHandler(mx.traceStatus))
+		return
 	}
 
 	// Check if method is supported by chi
@@ -415,23 +2583,164 @@ func (mx *Mux) routeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	method, ok := methodMap[rctx.RouteMethod]
 	if !ok {
-		mx.MethodNotAllowedHandler().ServeHTTP(w, r)
+		rctx.routed = false
+		if mx.debugMatch {
+			rctx.matchError = &MatchError{Reason: MatchWrongMethod, Method: rctx.RouteMethod, Path: routePath}
+		}
+		mx.serveMiss(w, r, mx.MethodNotAllowedHandler())
 		return
 	}
 
 	// Find the route
-	if _, _, h := mx.tree.FindRoute(rctx, method, routePath); h != nil {
-		h.ServeHTTP(w, r)
+	rctx.requestHeader = r.Header
+	rctx.debugMatch = mx.debugMatch
+	if resolved != nil {
+		if rn, _, h := resolved.FindRoute(rctx, method, routePath); h != nil {
+			mx.serveRoute(w, r, rctx, rn.endpoints[method], h)
+			return
+		}
+	}
+	if rn, h := mx.findRoute(rctx, method, routePath); h != nil {
+		mx.serveRoute(w, r, rctx, rn.endpoints[method], h)
+		return
+	}
+	rctx.routed = false
+	if mx.debugMatch {
+		rctx.matchError = matchError(rctx, method, routePath)
+	}
+	if mx.fallbackHandler != nil {
+		mx.serveMiss(w, r, mx.fallbackHandler.ServeHTTP)
 		return
 	}
 	if rctx.methodNotAllowed {
-		mx.MethodNotAllowedHandler().ServeHTTP(w, r)
+		mx.serveMiss(w, r, mx.MethodNotAllowedHandler())
 	} else {
-		mx.NotFoundHandler().ServeHTTP(w, r)
+		mx.serveMiss(w, r, mx.notFoundHandlerFor(rctx.RouteMethod, r))
+	}
+}
+
+// serveRoute serves a request routed to the endpoint `ep` with its handler `h`,
+// through the middlewares set with UseOnMatch and UseFor.
+func (mx *Mux) serveRoute(w http.ResponseWriter, r *http.Request, rctx *Context, ep *endpoint, h http.Handler) {
+	rctx.routed = true
+	if ep.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), ep.timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if len(mx.routeRewriters) > 0 {
+		for _, fn := range mx.routeRewriters {
+			h = fn(rctx, h)
+		}
+		rctx.chainedHandler = h
+	}
+	ep.chain.ServeHTTP(w, r)
+}
+
+// chainEndpoints builds the handlers of the endpoints `eps` for the http
+// methods `method`, served through the middlewares set with UseOnMatch and
+// the ones set with UseFor whose glob matches the routing pattern, so the
+// middlewares of a route are chained once rather than on every request.
+//
+// With route rewriters, the chains end with the handler they rewrote for the
+// request, see serveChained.
+func (mx *Mux) chainEndpoints(eps endpoints, method methodTyp) {
+	for mt, ep := range eps {
+		if mt&method == 0 || ep.handler == nil {
+			continue
+		}
+		var h http.Handler = ep.handler
+		if len(mx.routeRewriters) > 0 {
+			h = http.HandlerFunc(serveChained)
+		}
+		var mws Middlewares
+		for _, pm := range mx.patternMiddlewares {
+			if pm.match(ep.pattern) {
+				mws = append(mws, pm.middlewares...)
+			}
+		}
+		if len(mws) > 0 {
+			h = mx.chain(mws, h)
+		}
+		ep.chain = mx.chain(mx.matchMiddlewares, h)
+	}
+}
+
+// serveChained serves the handler of the request at the end of a middleware
+// chain built once, being the matched route as rewritten by the route
+// rewriters or the miss handler, see serveRoute and serveMiss.
+func serveChained(w http.ResponseWriter, r *http.Request) {
+	RouteContext(r.Context()).chainedHandler.ServeHTTP(w, r)
+}
+
+// validPath reports whether the path of the url `u` has valid escapes and
+// decodes to valid UTF-8. The escapes are validated by url.QueryUnescape, as
+// url.PathUnescape would, since the '+' of a path isn't decoded either way.
+func validPath(u *url.URL) bool {
+	if u.RawPath != "" {
+		if _, err := url.QueryUnescape(u.RawPath); err != nil {
+			return false
+		}
+	}
+	return utf8.ValidString(u.Path)
+}
+
+// matchError returns why the routing `path` matched no route, from the search
+// of the tree recorded on the routing context.
+func matchError(rctx *Context, method methodTyp, path string) *MatchError {
+	e := &MatchError{Reason: MatchNoRoute, Method: rctx.RouteMethod, Path: path}
+	switch {
+	case rctx.methodNotAllowedNode != nil:
+		e.Reason = MatchWrongMethod
+		e.Pattern = rctx.methodNotAllowedNode.anyPattern(method)
+	case rctx.matchNode != nil && rctx.matchRest == "":
+		e.Reason = MatchWrongHeaders
+		e.Pattern = rctx.matchNode.anyPattern(method)
+	case rctx.matchNode != nil:
+		e.Reason = MatchPartialPath
+		e.Prefix = path[:len(path)-len(rctx.matchRest)]
+		e.Pattern = rctx.matchNode.anyPattern(method)
+	}
+	return e
+}
+
+// serveMiss serves a request that matches no route with the handler `hFn`,
+// through the middlewares set with UseOnMiss.
+func (mx *Mux) serveMiss(w http.ResponseWriter, r *http.Request, hFn http.HandlerFunc) {
+	if len(mx.missMiddlewares) == 0 {
+		hFn(w, r)
+		return
+	}
+	if mx.missHandler == nil {
+		mx.chain(mx.missMiddlewares, hFn).ServeHTTP(w, r)
+		return
+	}
+	RouteContext(r.Context()).chainedHandler = hFn
+	mx.missHandler.ServeHTTP(w, r)
+}
+
+// findRoute searches the routing tree for the handler of the method/path,
+// falling back to the longest ancestor route when prefix matching is enabled.
+func (mx *Mux) findRoute(rctx *Context, method methodTyp, path string) (*node, http.Handler) {
+	rn, _, h := mx.tree.FindRoute(rctx, method, path)
+	if h != nil || !mx.prefixMatch || rctx.methodNotAllowed {
+		return rn, h
 	}
+
+	rn, _, h = mx.tree.FindPrefixRoute(rctx, method, path)
+	if h == nil {
+		// An ancestor route without a handler for the method doesn't make
+		// the path itself a 405.
+		rctx.methodNotAllowed = false
+		rctx.methodNotAllowedNode = nil
+		return nil, nil
+	}
+	return rn, h
 }
 
-func (mx *Mux) nextRoutePath(rctx *Context) string {
+// nextRoutePath returns the routing path of a mounted router, being the value
+// of the wildcard of the mount.
+func nextRoutePath(rctx *Context) string {
 	routePath := "/"
 	nx := len(rctx.routeParams.Keys) - 1 // index of last param in list
 	if nx >= 0 && rctx.routeParams.Keys[nx] == "*" && len(rctx.routeParams.Values) > nx {
@@ -440,9 +2749,24 @@ func (mx *Mux) nextRoutePath(rctx *Context) string {
 	return routePath
 }
 
+// mountPrefix returns the path prefix consumed by a mount, being the current
+// routing path of the request without the `nextRoutePath` of the sub-router.
+func mountPrefix(rctx *Context, r *http.Request, nextRoutePath string) string {
+	routePath := rctx.RoutePath
+	if routePath == "" {
+		if r.URL.RawPath != "" {
+			routePath = r.URL.RawPath
+		} else {
+			routePath = r.URL.Path
+		}
+	}
+	prefix := strings.TrimSuffix(routePath, nextRoutePath[1:])
+	return strings.TrimSuffix(prefix, "/")
+}
+
 // Recursively update data on child routers.
 func (mx *Mux) updateSubRoutes(fn func(subMux *Mux)) {
-	for _, r := range mx.tree.routes() {
+	for _, r := range mx.Routes() {
 		subMux, ok := r.SubRoutes.(*Mux)
 		if !ok {
 			continue
@@ -452,8 +2776,120 @@ func (mx *Mux) updateSubRoutes(fn func(subMux *Mux)) {
 }
 
 // methodNotAllowedHandler is a helper function to respond with a 405,
-// method not allowed.
+// method not allowed, in the same manner as http.NotFound.
 func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(405)
-	w.Write(nil)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// beforeWriteWriter is a http.ResponseWriter calling a hook before the response
+// header is written, see WithBeforeWrite. The variants below expose the
+// optional interfaces of the original writer, in the same manner as
+// middleware.NewWrapResponseWriter.
+type beforeWriteWriter struct {
+	http.ResponseWriter
+	fn func()
+}
+
+func (b *beforeWriteWriter) beforeWrite() {
+	if fn := b.fn; fn != nil {
+		b.fn = nil
+		fn()
+	}
+}
+
+func (b *beforeWriteWriter) WriteHeader(code int) {
+	b.beforeWrite()
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *beforeWriteWriter) Write(p []byte) (int, error) {
+	b.beforeWrite()
+	return b.ResponseWriter.Write(p)
+}
+
+func (b *beforeWriteWriter) flush() {
+	b.beforeWrite()
+	b.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (b *beforeWriteWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return b.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+type beforeWriteFlushWriter struct {
+	*beforeWriteWriter
+}
+
+func (f *beforeWriteFlushWriter) Flush() {
+	f.flush()
+}
+
+type beforeWriteHijackWriter struct {
+	*beforeWriteWriter
+}
+
+func (f *beforeWriteHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return f.hijack()
+}
+
+type beforeWriteFlushHijackWriter struct {
+	*beforeWriteWriter
+}
+
+func (f *beforeWriteFlushHijackWriter) Flush() {
+	f.flush()
+}
+
+func (f *beforeWriteFlushHijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return f.hijack()
+}
+
+// beforeWriteHTTPFancyWriter is the variant for the writer of a HTTP/1.x
+// request, that satisfies http.CloseNotifier, http.Flusher, http.Hijacker and
+// io.ReaderFrom.
+type beforeWriteHTTPFancyWriter struct {
+	*beforeWriteWriter
+}
+
+func (f *beforeWriteHTTPFancyWriter) CloseNotify() <-chan bool {
+	return f.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (f *beforeWriteHTTPFancyWriter) Flush() {
+	f.flush()
+}
+
+func (f *beforeWriteHTTPFancyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return f.hijack()
+}
+
+func (f *beforeWriteHTTPFancyWriter) ReadFrom(r io.Reader) (int64, error) {
+	f.beforeWrite()
+	return f.ResponseWriter.(io.ReaderFrom).ReadFrom(r)
+}
+
+// beforeWriteHTTP2FancyWriter is the variant for the writer of a HTTP/2
+// request, that satisfies http.CloseNotifier and http.Flusher, as well as
+// http.Pusher since go1.8.
+type beforeWriteHTTP2FancyWriter struct {
+	*beforeWriteWriter
+}
+
+func (f *beforeWriteHTTP2FancyWriter) CloseNotify() <-chan bool {
+	return f.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func (f *beforeWriteHTTP2FancyWriter) Flush() {
+	f.flush()
 }
+
+var _ http.Flusher = &beforeWriteFlushWriter{}
+var _ http.Hijacker = &beforeWriteHijackWriter{}
+var _ http.Flusher = &beforeWriteFlushHijackWriter{}
+var _ http.Hijacker = &beforeWriteFlushHijackWriter{}
+var _ http.CloseNotifier = &beforeWriteHTTPFancyWriter{}
+var _ http.Flusher = &beforeWriteHTTPFancyWriter{}
+var _ http.Hijacker = &beforeWriteHTTPFancyWriter{}
+var _ io.ReaderFrom = &beforeWriteHTTPFancyWriter{}
+var _ http.CloseNotifier = &beforeWriteHTTP2FancyWriter{}
+var _ http.Flusher = &beforeWriteHTTP2FancyWriter{}
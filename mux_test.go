@@ -1,6 +1,7 @@
 package chi
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
@@ -9,8 +10,14 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -122,7 +129,7 @@ func TestMuxBasic(t *testing.T) {
 
 	// GET /
 	if _, body := testRequest(t, ts, "GET", "/", nil); body != "hi peter" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	tlogmsg, _ := logbuf.ReadString(0)
 	if tlogmsg != logmsg {
@@ -131,37 +138,37 @@ func TestMuxBasic(t *testing.T) {
 
 	// GET /ping
 	if _, body := testRequest(t, ts, "GET", "/ping", nil); body != "." {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /pingall
 	if _, body := testRequest(t, ts, "GET", "/pingall", nil); body != "ping all" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /ping/all
 	if _, body := testRequest(t, ts, "GET", "/ping/all", nil); body != "ping all" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /ping/all2
 	if _, body := testRequest(t, ts, "GET", "/ping/all2", nil); body != "ping all2" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /ping/123
 	if _, body := testRequest(t, ts, "GET", "/ping/123", nil); body != "ping one id: 123" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /ping/allan
 	if _, body := testRequest(t, ts, "GET", "/ping/allan", nil); body != "ping one id: allan" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// GET /ping/1/woop
 	if _, body := testRequest(t, ts, "GET", "/ping/1/woop", nil); body != "woop.1" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// HEAD /ping
@@ -178,7 +185,7 @@ func TestMuxBasic(t *testing.T) {
 
 	// GET /admin/catch-this
 	if _, body := testRequest(t, ts, "GET", "/admin/catch-thazzzzz", nil); body != "catchall" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// POST /admin/catch-this
@@ -202,8 +209,8 @@ func TestMuxBasic(t *testing.T) {
 	}
 
 	// Custom http method DIE /ping/1/woop
-	if resp, body := testRequest(t, ts, "DIE", "/ping/1/woop", nil); body != "" || resp.StatusCode != 405 {
-		t.Fatalf(fmt.Sprintf("expecting 405 status and empty body, got %d '%s'", resp.StatusCode, body))
+	if resp, body := testRequest(t, ts, "DIE", "/ping/1/woop", nil); body != "Method Not Allowed\n" || resp.StatusCode != 405 {
+		t.Fatalf("expecting 405 status and method not allowed body, got %d '%s'", resp.StatusCode, body)
 	}
 }
 
@@ -234,13 +241,13 @@ func TestMuxMounts(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/sharing/aBc", nil); body != "/aBc" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/sharing/aBc/share", nil); body != "/aBc/share" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/sharing/aBc/share/twitter", nil); body != "/aBc/share/twitter" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -258,10 +265,58 @@ func TestMuxPlain(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/hi", nil); body != "bye" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/nothing-here", nil); body != "nothing here" {
-		t.Fatalf(body)
+		t.Fatal(body)
+	}
+}
+
+func TestMuxZeroValue(t *testing.T) {
+	// The first use of a zero-value Mux may be concurrent
+	z := &Mux{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			z.Routes()
+		}()
+	}
+	wg.Wait()
+
+	r := &Mux{}
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Mw", "1")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hi"))
+	})
+	r.Route("/users", func(r Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("user " + URLParam(r, "id")))
+		})
+	})
+
+	for path, want := range map[string]string{"/hi": "hi", "/users/1": "user 1", "/nope": "404 page not found\n"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != want || w.Header().Get("X-Mw") != "1" {
+			t.Fatalf("%s: expecting '%s', got '%s'", path, want, w.Body.String())
+		}
+	}
+
+	if pattern, ok := (&Mux{}).LookupRoute(httptest.NewRequest("GET", "/hi", nil)); ok {
+		t.Fatalf("expecting no route on a zero-value mux, got '%s'", pattern)
+	}
+	if _, _, ok := (&Mux{}).ResolveChain("GET", "/hi"); ok {
+		t.Fatal("expecting no chain on a zero-value mux")
+	}
+	if c := r.Clone(); len(c.Routes()) != len(r.Routes()) {
+		t.Fatalf("expecting the routes of the clone, got %v", c.Routes())
 	}
 }
 
@@ -274,7 +329,7 @@ func TestMuxEmptyRoutes(t *testing.T) {
 	mux.Handle("/api*", apiRouter)
 
 	if _, body := testHandler(t, mux, "GET", "/", nil); body != "404 page not found\n" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	func() {
@@ -327,13 +382,219 @@ func TestMuxTrailingSlash(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/accounts/admin", nil); body != "admin" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/accounts/admin/", nil); body != "admin" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/nothing-here", nil); body != "nothing here" {
-		t.Fatalf(body)
+		t.Fatal(body)
+	}
+}
+
+func TestMuxMountParamPrefix(t *testing.T) {
+	tenantRouter := NewRouter()
+	tenantRouter.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tenant", URLParam(r, "tenant"))
+			next.ServeHTTP(w, r)
+		})
+	})
+	tenantRouter.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tenant=" + URLParam(r, "tenant")))
+	})
+	tenantRouter.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tenant=" + URLParam(r, "tenant") + " users"))
+	})
+	tenantRouter.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("tenant=%s user=%s %s", URLParam(r, "tenant"), URLParam(r, "id"), RouteContext(r.Context()).RoutePattern())))
+	})
+
+	r := NewRouter()
+	r.Mount("/tenants/{tenant}", tenantRouter)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/tenants/acme/users", "tenant=acme users"},
+		{"/tenants/acme", "tenant=acme"},
+		{"/tenants/acme/", "tenant=acme"},
+		{"/tenants/acme/users/7", "tenant=acme user=7 /tenants/{tenant}/users/{id}"},
+	}
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if body != tt.body {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.path, tt.body, body)
+		}
+		if tenant := resp.Header.Get("X-Tenant"); tenant != "acme" {
+			t.Fatalf("%s: expecting the tenant in the sub-router middleware, got '%s'", tt.path, tenant)
+		}
+	}
+	if resp, _ := testRequest(t, ts, "GET", "/tenants", nil); resp.StatusCode != 404 {
+		t.Fatalf("expecting 404 without a tenant, got %d", resp.StatusCode)
+	}
+
+	if err := NewRouter().TryMount("/tenants/{tenant", tenantRouter); err == nil {
+		t.Fatalf("expecting an error for a malformed param prefix")
+	}
+}
+
+func TestMuxMountWithOpts(t *testing.T) {
+	sub := NewRouter()
+	sub.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sub index"))
+	})
+	sub.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sub users"))
+	})
+	sibling := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sibling"))
+	}
+
+	// The stubs of Mount shadow a sibling route of the bare pattern
+	r := NewRouter()
+	r.Get("/api", sibling)
+	r.Mount("/api", sub)
+
+	nostub := NewRouter()
+	nostub.Get("/api", sibling)
+	nostub.MountWithOpts("/api", sub, MountOpts{NoStub: true})
+
+	// Without a sibling route, the bare pattern isn't routed at all
+	bare := NewRouter()
+	bare.MountWithOpts("/api", sub, MountOpts{NoStub: true})
+
+	tests := []struct {
+		router Router
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{r, "GET", "/api", 200, "sub index"},
+		{r, "GET", "/api/", 200, "sub index"},
+		{r, "GET", "/api/users", 200, "sub users"},
+		{nostub, "GET", "/api", 200, "sibling"},
+		{nostub, "POST", "/api", 405, "Method Not Allowed\n"},
+		{nostub, "GET", "/api/", 200, "sub index"},
+		{nostub, "GET", "/api/users", 200, "sub users"},
+		{bare, "GET", "/api", 404, "404 page not found\n"},
+		{bare, "GET", "/api/", 200, "sub index"},
+		{bare, "GET", "/api/users", 200, "sub users"},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Fatalf("test %d %s %s: expecting %d '%s', got %d '%s'", i, tt.method, tt.path, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	// The mount must still be unique
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting a panic for a duplicate mount")
+		}
+	}()
+	bare.MountWithOpts("/api", sub, MountOpts{NoStub: true})
+}
+
+func TestMuxPrefix(t *testing.T) {
+	proxy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxy " + r.Method + " " + r.URL.Path + " rest=" + URLParam(r, "*")))
+	})
+
+	r := NewRouter()
+	r.Prefix("/api", proxy)
+	r.Get("/api/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("users"))
+	})
+	r.Get("/apix", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("apix"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/api", 200, "proxy GET /api rest="},
+		{"GET", "/api/", 200, "proxy GET /api/ rest="},
+		{"POST", "/api/x/y", 200, "proxy POST /api/x/y rest=x/y"},
+		{"DELETE", "/api/users/1", 200, "proxy DELETE /api/users/1 rest=users/1"},
+
+		// The more specific routes under the prefix take precedence for
+		// their methods only
+		{"GET", "/api/users", 200, "users"},
+		{"POST", "/api/users", 200, "proxy POST /api/users rest=users"},
+		{"GET", "/apix", 200, "apix"},
+		{"GET", "/apiz", 404, "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The prefix is a single route, without the stubs of Mount
+	var patterns []string
+	for _, route := range r.Routes() {
+		patterns = append(patterns, route.Pattern)
+	}
+	sort.Strings(patterns)
+	if got := strings.Join(patterns, ", "); got != "/api/*, /api/users, /apix" {
+		t.Fatalf("expecting the routes '/api/*, /api/users, /apix', got '%s'", got)
+	}
+}
+
+func TestMuxMountPrefix(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RouteContext(r.Context()).MountPrefix()))
+	}
+
+	v1 := NewRouter()
+	v1.Get("/", h)
+	v1.Get("/users/{id}", h)
+
+	api := NewRouter()
+	api.Get("/", h)
+	api.Mount("/v1", v1)
+	api.Route("/{tenant}", func(r Router) {
+		r.Mount("/v2", v1)
+	})
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Mount("/api/", api)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/", ""},
+		{"/api/", "/api"},
+		{"/api/v1", "/api/v1"},
+		{"/api/v1/", "/api/v1"},
+		{"/api/v1/users/7", "/api/v1"},
+		{"/api/acme/v2/users/7", "/api/acme/v2"},
+	}
+	for _, tt := range tests {
+		if _, body := testRequest(t, ts, "GET", tt.path, nil); body != tt.body {
+			t.Fatalf("%s: expecting the mount prefix '%s', got '%s'", tt.path, tt.body, body)
+		}
 	}
 }
 
@@ -394,24 +655,85 @@ func TestMuxNestedNotFound(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/hi", nil); body != "bye" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/nothing-here", nil); body != "root 404 mw with" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/admin1/sub", nil); body != "sub" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/admin1/nope", nil); body != "sub 404 mw2" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/admin2/sub", nil); body != "sub2" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 
 	// Not found pages should bubble up to the root.
 	if _, body := testRequest(t, ts, "GET", "/admin2/nope", nil); body != "root 404 mw with" {
-		t.Fatalf(body)
+		t.Fatal(body)
+	}
+}
+
+func TestMuxNotFoundAfterMount(t *testing.T) {
+	sr1 := NewRouter()
+	sr1.Get("/sub", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sub1"))
+	})
+	sr2 := NewRouter()
+	sr2.Get("/sub", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sub2"))
+	})
+	sr2.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("sub2 404"))
+	})
+
+	r := NewRouter()
+	r.Mount("/admin1", sr1)
+	r.Mount("/admin2", sr2)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/admin1/nothing", nil); body != "404 page not found\n" {
+		t.Fatal(body)
+	}
+
+	for _, msg := range []string{"root 404", "updated root 404"} {
+		msg := msg
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte(msg))
+		})
+		if _, body := testRequest(t, ts, "GET", "/nothing", nil); body != msg {
+			t.Fatal(body)
+		}
+		if _, body := testRequest(t, ts, "GET", "/admin1/nothing", nil); body != msg {
+			t.Fatal(body)
+		}
+		if _, body := testRequest(t, ts, "GET", "/admin2/nothing", nil); body != "sub2 404" {
+			t.Fatal(body)
+		}
+	}
+}
+
+func TestMuxDefaultMethodNotAllowed(t *testing.T) {
+	r := NewRouter()
+	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hi"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, "POST", "/hi", nil)
+	if resp.StatusCode != 405 || body != "Method Not Allowed\n" {
+		t.Fatalf("expecting 405 'Method Not Allowed', got %d '%s'", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("expecting a plain text content type, got '%s'", ct)
 	}
 }
 
@@ -446,22 +768,22 @@ func TestMuxNestedMethodNotAllowed(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/root", nil); body != "root" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "PUT", "/root", nil); body != "root 405" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/prefix1/sub1", nil); body != "sub1" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "PUT", "/prefix1/sub1", nil); body != "sub1 405" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/prefix2/sub2", nil); body != "sub2" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "PUT", "/prefix2/sub2", nil); body != "root 405" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -494,33 +816,33 @@ func TestMuxComplicatedNotFound(t *testing.T) {
 
 	// check that we didn't break correct routes
 	if _, body := testRequest(t, ts, "GET", "/auth", nil); body != "auth get" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/public", nil); body != "public get" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/public/", nil); body != "public get" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/private/resource", nil); body != "private get" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	// check custom not-found on all levels
 	if _, body := testRequest(t, ts, "GET", "/nope", nil); body != "custom not-found" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/public/nope", nil); body != "custom not-found" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/private/nope", nil); body != "custom not-found" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/private/resource/nope", nil); body != "custom not-found" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	// check custom not-found on trailing slash routes
 	if _, body := testRequest(t, ts, "GET", "/auth/", nil); body != "custom not-found" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -558,10 +880,10 @@ func TestMuxWith(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/hi", nil); body != "bye" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/inline", nil); body != "inline yes yes" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if cmwInit1 != 1 {
 		t.Fatalf("expecting cmwInit1 to be 1, got %d", cmwInit1)
@@ -1351,7 +1673,7 @@ func TestMountingSimilarPattern(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/hi", nil); body != "bye" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -1368,10 +1690,52 @@ func TestMuxEmptyParams(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/users/a/b/c", nil); body != "a-b-c" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/users///c", nil); body != "--c" {
-		t.Fatalf(body)
+		t.Fatal(body)
+	}
+}
+
+func TestMuxEmptySegments(t *testing.T) {
+	h := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rctx := RouteContext(r.Context())
+			w.Write([]byte(fmt.Sprintf("%s %s x=%s", name, rctx.RoutePattern(), URLParam(r, "x"))))
+		}
+	}
+
+	r := NewRouter()
+	r.Get("/a//b", h("empty"))
+	r.Get("/a/{x}/b", h("param"))
+	r.Route("/sub", func(r Router) {
+		r.Get("/", h("sub-root"))
+		r.Get("//b", h("sub-empty"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a//b", 200, "empty /a//b x="},
+		{"/a/1/b", 200, "param /a/{x}/b x=1"},
+		{"/a///b", 404, "404 page not found\n"},
+		{"//a//b", 404, "404 page not found\n"},
+		{"/sub", 200, "sub-root /sub/ x="},
+		{"/sub/", 200, "sub-root /sub/ x="},
+		{"/sub//b", 200, "sub-empty /sub//b x="},
+		{"/sub/b", 404, "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
 	}
 }
 
@@ -1390,10 +1754,10 @@ func TestMuxMissingParams(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/user/123", nil); body != "userId = '123'" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 	if _, body := testRequest(t, ts, "GET", "/user/", nil); body != "nothing here" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -1416,7 +1780,8 @@ func TestMuxContextIsThreadSafe(t *testing.T) {
 				w := httptest.NewRecorder()
 				r, err := http.NewRequest("GET", "/ok", nil)
 				if err != nil {
-					t.Fatal(err)
+					t.Error(err)
+					return
 				}
 
 				ctx, cancel := context.WithCancel(r.Context())
@@ -1468,7 +1833,7 @@ func TestEscapedURLParams(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/api/http:%2f%2fexample.com%2fimage.png/full/max/0/color.png", nil); body != "success" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
@@ -1529,136 +1894,4385 @@ func TestServerBaseContext(t *testing.T) {
 	defer ts.Close()
 
 	if _, body := testRequest(t, ts, "GET", "/", nil); body != "yes" {
-		t.Fatalf(body)
+		t.Fatal(body)
 	}
 }
 
-func testRequest(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (*http.Response, string) {
-	req, err := http.NewRequest(method, ts.URL+path, body)
-	if err != nil {
-		t.Fatal(err)
-		return nil, ""
+func TestMuxRouteMiddlewareIsolation(t *testing.T) {
+	adminMw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Admin", "yes")
+			next.ServeHTTP(w, r)
+		})
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		t.Fatal(err)
-		return nil, ""
-	}
+	r := NewRouter()
+	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bye"))
+	})
+	r.Route("/admin", func(r Router) {
+		r.Use(adminMw)
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})
+		r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin users"))
+		})
+	})
+	r.Route("/public", func(r Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("public"))
+		})
+	})
+	r.Route("/locked", func(r Router) {
+		r.Use(adminMw)
+	})
 
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		t.Fatal(err)
-		return nil, ""
-	}
-	defer resp.Body.Close()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
 
-	return resp, string(respBody)
+	tests := []struct {
+		path   string
+		status int
+		body   string
+		admin  string
+	}{
+		{"/hi", 200, "bye", ""},
+		{"/public", 200, "public", ""},
+		{"/public/nope", 404, "404 page not found\n", ""},
+		{"/nope", 404, "404 page not found\n", ""},
+		{"/admin", 200, "admin", "yes"},
+		{"/admin/", 200, "admin", "yes"},
+		{"/admin/users", 200, "admin users", "yes"},
+		{"/admin/nope", 404, "404 page not found\n", "yes"},
+		{"/locked", 404, "404 page not found\n", "yes"},
+		{"/locked/nope", 404, "404 page not found\n", "yes"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s: expecting status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+		if body != tt.body {
+			t.Fatalf("%s: expecting body '%s', got '%s'", tt.path, tt.body, body)
+		}
+		if resp.Header.Get("X-Admin") != tt.admin {
+			t.Fatalf("%s: expecting X-Admin header '%s', got '%s'", tt.path, tt.admin, resp.Header.Get("X-Admin"))
+		}
+	}
 }
 
-func testHandler(t *testing.T, h http.Handler, method, path string, body io.Reader) (*http.Response, string) {
-	r, _ := http.NewRequest(method, path, body)
-	w := httptest.NewRecorder()
-	h.ServeHTTP(w, r)
-	return w.Result(), string(w.Body.Bytes())
-}
+func TestMuxPrefixMatch(t *testing.T) {
+	r := NewRouter()
+	r.PrefixMatch(true)
 
-type testFileSystem struct {
-	open func(name string) (http.File, error)
-}
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	r.Get("/api/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("api " + RouteContext(r.Context()).RoutePattern()))
+	})
+	r.Get("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1"))
+	})
+	r.Get("/api/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+	r.Get("/files/*", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("files " + URLParam(r, "*")))
+	})
+	r.Post("/forms/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("forms"))
+	})
+	r.Route("/admin", func(r Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})
+	})
 
-func (fs *testFileSystem) Open(name string) (http.File, error) {
-	return fs.open(name)
-}
+	ts := httptest.NewServer(r)
+	defer ts.Close()
 
-type testFile struct {
-	name     string
-	contents []byte
-}
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		// exact, param and wildcard routes take precedence
+		{"GET", "/api/", 200, "api /api/"},
+		{"GET", "/api/v1", 200, "v1"},
+		{"GET", "/api/users/5", 200, "user 5"},
+		{"GET", "/files/a/b.txt", 200, "files a/b.txt"},
 
-func (tf *testFile) Close() error {
-	return nil
-}
+		// fallback to the longest ancestor route
+		{"GET", "/api/anything", 200, "api /api/"},
+		{"GET", "/api/v1/users", 200, "v1"},
+		{"GET", "/api/users/5/posts", 200, "user 5"},
+		{"GET", "/api/v2/users", 200, "api /api/"},
+		{"GET", "/other", 200, "root"},
 
-func (tf *testFile) Read(p []byte) (n int, err error) {
-	copy(p, tf.contents)
-	return len(p), nil
-}
+		// routed path without the method is still a 405
+		{"POST", "/api/", 405, "Method Not Allowed\n"},
 
-func (tf *testFile) Seek(offset int64, whence int) (int64, error) {
-	return 0, nil
-}
+		// ancestor route without the method doesn't match
+		{"GET", "/forms/new", 200, "root"},
 
-func (tf *testFile) Readdir(count int) ([]os.FileInfo, error) {
-	stat, _ := tf.Stat()
-	return []os.FileInfo{stat}, nil
-}
+		// sub-routers keep their own matching
+		{"GET", "/admin/nope", 404, "404 page not found\n"},
+	}
 
-func (tf *testFile) Stat() (os.FileInfo, error) {
-	return &testFileInfo{tf.name, int64(len(tf.contents))}, nil
-}
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s %s: expecting status %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
+		if body != tt.body {
+			t.Fatalf("%s %s: expecting body '%s', got '%s'", tt.method, tt.path, tt.body, body)
+		}
+	}
 
-type testFileInfo struct {
-	name string
-	size int64
-}
+	if !r.Match(NewRouteContext(), "GET", "/api/anything") {
+		t.Fatalf("expecting prefix route to match")
+	}
 
-func (tfi *testFileInfo) Name() string       { return tfi.name }
-func (tfi *testFileInfo) Size() int64        { return tfi.size }
-func (tfi *testFileInfo) Mode() os.FileMode  { return 0755 }
-func (tfi *testFileInfo) ModTime() time.Time { return time.Now() }
-func (tfi *testFileInfo) IsDir() bool        { return false }
-func (tfi *testFileInfo) Sys() interface{}   { return nil }
+	r.PrefixMatch(false)
+	if resp, _ := testRequest(t, ts, "GET", "/api/anything", nil); resp.StatusCode != 404 {
+		t.Fatalf("expecting 404 with prefix matching disabled, got %d", resp.StatusCode)
+	}
 
-type ctxKey struct {
-	name string
+	// An inline mux of an inline mux enables it on the root mux
+	r.Group(func(r Router) {
+		r.With().(*Mux).PrefixMatch(true)
+	})
+	if resp, body := testRequest(t, ts, "GET", "/api/anything", nil); resp.StatusCode != 200 || body != "api /api/" {
+		t.Fatalf("expecting 200 'api /api/' from a nested inline mux, got %d '%s'", resp.StatusCode, body)
+	}
 }
 
-func (k ctxKey) String() string {
-	return "context value " + k.name
+func TestMuxRouted(t *testing.T) {
+	var routed bool
+
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			routed = RouteContext(r.Context()).Routed()
+		})
+	})
+	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bye"))
+	})
+	r.Route("/sub", func(r Router) {
+		r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("sub bye"))
+		})
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		routed bool
+	}{
+		{"GET", "/hi", true},
+		{"GET", "/sub/hi", true},
+		{"GET", "/nope", false},
+		{"GET", "/sub/nope", false},
+		{"POST", "/hi", false},
+	}
+
+	for _, tt := range tests {
+		testHandler(t, r, tt.method, tt.path, nil)
+		if routed != tt.routed {
+			t.Fatalf("%s %s: expecting routed %v, got %v", tt.method, tt.path, tt.routed, routed)
+		}
+	}
+
+	rctx := NewRouteContext()
+	rctx.routed = true
+	rctx.Reset()
+	if rctx.Routed() {
+		t.Fatalf("expecting Reset() to clear the routed flag")
+	}
 }
 
-func BenchmarkMux(b *testing.B) {
-	h1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	h2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	h3 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	h4 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	h5 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
-	h6 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+func TestMuxDefaultHeaders(t *testing.T) {
+	r := NewRouter()
+	r.SetDefaultHeader("X-Content-Type-Options", "nosniff")
+	r.SetDefaultHeader("Server", "chi")
+	r.SetDefaultHeader("Server", "chi/3")
 
-	mx := NewRouter()
-	mx.Get("/", h1)
-	mx.Get("/hi", h2)
-	mx.Get("/sup/{id}/and/{this}", h3)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	r.Get("/override", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "custom")
+		w.Write([]byte("override"))
+	})
+	r.Route("/sub", func(r Router) {
+		r.(*Mux).SetDefaultHeader("X-Sub", "yes")
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("sub"))
+		})
+	})
+	r.Group(func(r Router) {
+		r.With().(*Mux).SetDefaultHeader("X-Inline", "yes")
+	})
 
-	mx.Route("/sharing/{hash}", func(mx Router) {
-		mx.Get("/", h4)          // subrouter-1
-		mx.Get("/{network}", h5) // subrouter-1
-		mx.Get("/twitter", h5)
-		mx.Route("/direct", func(mx Router) {
-			mx.Get("/", h6) // subrouter-2
+	tests := []struct {
+		path    string
+		server  string
+		nosniff string
+		sub     string
+	}{
+		{"/", "chi/3", "nosniff", ""},
+		{"/override", "custom", "nosniff", ""},
+		{"/nope", "chi/3", "nosniff", ""},
+		{"/sub/", "chi/3", "nosniff", "yes"},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testHandler(t, r, "GET", tt.path, nil)
+		if resp.Header.Get("Server") != tt.server {
+			t.Fatalf("%s: expecting Server header '%s', got '%s'", tt.path, tt.server, resp.Header.Get("Server"))
+		}
+		if resp.Header.Get("X-Content-Type-Options") != tt.nosniff {
+			t.Fatalf("%s: expecting X-Content-Type-Options header '%s', got '%s'", tt.path, tt.nosniff, resp.Header.Get("X-Content-Type-Options"))
+		}
+		if resp.Header.Get("X-Sub") != tt.sub {
+			t.Fatalf("%s: expecting X-Sub header '%s', got '%s'", tt.path, tt.sub, resp.Header.Get("X-Sub"))
+		}
+		if resp.Header.Get("X-Inline") != "yes" {
+			t.Fatalf("%s: expecting the X-Inline header of a nested inline mux, got '%s'", tt.path, resp.Header.Get("X-Inline"))
+		}
+	}
+}
+
+func TestMuxMethodNotAllowedVsNotFound(t *testing.T) {
+	r := NewRouter()
+	r.Post("/only-post", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post"))
+	})
+	r.Put("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("put"))
+	})
+	r.Post("/sub/post", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post"))
+	})
+	r.Route("/sub", func(r Router) {
+		r.Get("/get", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("get"))
 		})
 	})
 
-	routes := []string{
-		"/",
-		"/sup/123/and/this",
-		"/sharing/aBc",         // subrouter-1
-		"/sharing/aBc/twitter", // subrouter-1
-		"/sharing/aBc/direct",  // subrouter-2
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"POST", "/only-post", 200},
+		{"GET", "/only-post", 405},
+		{"GET", "/items/1", 405},
+		{"GET", "/items/1/nope", 404},
+		{"GET", "/nope", 404},
+		{"GET", "/sub/get", 200},
+		{"POST", "/sub/get", 405},
+		{"POST", "/sub/post", 200},
+
+		// routed to the sub-router, which has no such route
+		{"GET", "/sub/post", 404},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testHandler(t, r, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s %s: expecting status %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
 	}
+}
 
-	for _, path := range routes {
-		b.Run("route:"+path, func(b *testing.B) {
-			w := httptest.NewRecorder()
-			r, _ := http.NewRequest("GET", path, nil)
+type methodHandler struct {
+	name string
+}
 
-			b.ReportAllocs()
-			b.ResetTimer()
+func (h *methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(h.name + " " + r.Method))
+}
 
-			for i := 0; i < b.N; i++ {
-				mx.ServeHTTP(w, r)
+func TestMuxMethodHandlers(t *testing.T) {
+	r := NewRouter()
+	r.ConnectHandler("/", &methodHandler{"connect"})
+	r.DeleteHandler("/", &methodHandler{"delete"})
+	r.GetHandler("/", &methodHandler{"get"})
+	r.HeadHandler("/", &methodHandler{"head"})
+	r.OptionsHandler("/", &methodHandler{"options"})
+	r.PatchHandler("/", &methodHandler{"patch"})
+	r.PostHandler("/", &methodHandler{"post"})
+	r.PutHandler("/", &methodHandler{"put"})
+	r.TraceHandler("/", &methodHandler{"trace"})
+	r.Route("/sub", func(r Router) {
+		r.(*Mux).GetHandler("/", &methodHandler{"sub"})
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"CONNECT", "/", "connect CONNECT"},
+		{"DELETE", "/", "delete DELETE"},
+		{"GET", "/", "get GET"},
+		{"HEAD", "/", "head HEAD"},
+		{"OPTIONS", "/", "options OPTIONS"},
+		{"PATCH", "/", "patch PATCH"},
+		{"POST", "/", "post POST"},
+		{"PUT", "/", "put PUT"},
+		{"TRACE", "/", "trace TRACE"},
+		{"GET", "/sub", "sub GET"},
+	}
+
+	for _, tt := range tests {
+		if _, body := testHandler(t, r, tt.method, tt.path, nil); body != tt.body {
+			t.Fatalf("%s %s: expecting '%s', got '%s'", tt.method, tt.path, tt.body, body)
+		}
+	}
+	if resp, _ := testHandler(t, r, "POST", "/sub", nil); resp.StatusCode != 405 {
+		t.Fatalf("expecting 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestMuxLookupRoute(t *testing.T) {
+	public := map[string]bool{"/": true, "/articles/{id}": true}
+
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rctx := RouteContext(r.Context())
+			pattern, found := rctx.Routes.(*Mux).LookupRoute(r)
+
+			// the request routing context is left untouched
+			if rctx.RoutePattern() != "" || len(rctx.URLParams.Keys) != 0 {
+				t.Fatalf("expecting an untouched routing context, got pattern '%s' and params %v", rctx.RoutePattern(), rctx.URLParams.Keys)
+			}
+
+			w.Header().Set("X-Pattern", pattern)
+			if found && !public[pattern] {
+				w.WriteHeader(401)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	r.Route("/articles", func(r Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("article " + URLParam(r, "id")))
+		})
+		r.Get("/{id}/edit", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("edit"))
+		})
+	})
+	r.Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("admin"))
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		status  int
+		body    string
+		pattern string
+	}{
+		{"GET", "/", 200, "root", "/"},
+		{"GET", "/articles/1", 200, "article 1", "/articles/{id}"},
+		{"GET", "/articles/1/edit", 401, "", "/articles/{id}/edit"},
+		{"GET", "/admin", 401, "", "/admin"},
+		{"GET", "/nope", 404, "404 page not found\n", ""},
+	}
+
+	for _, tt := range tests {
+		resp, body := testHandler(t, r, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+		if resp.Header.Get("X-Pattern") != tt.pattern {
+			t.Fatalf("%s %s: expecting pattern '%s', got '%s'", tt.method, tt.path, tt.pattern, resp.Header.Get("X-Pattern"))
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/articles/5", nil)
+	if pattern, found := r.LookupRoute(req); !found || pattern != "/articles/{id}" {
+		t.Fatalf("expecting '/articles/{id}', got '%s' %v", pattern, found)
+	}
+}
+
+func TestMuxRouteConfig(t *testing.T) {
+	var config map[string]interface{}
+
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			config = RouteContext(r.Context()).RouteConfig()
+		})
+	})
+
+	requireAuth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RouteContext(r.Context()).RouteConfig()["auth"] == "required" {
+				w.WriteHeader(401)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	r.Get("/", handler)
+	r.WithConfig(map[string]interface{}{"cache": "60s"}).Get("/articles", handler)
+	r.Group(func(r Router) {
+		g := r.(*Mux).WithConfig(map[string]interface{}{"auth": "required", "cache": "none"})
+		g.With(requireAuth).Get("/admin", handler)
+		g.WithConfig(map[string]interface{}{"auth": "optional"}).With(requireAuth).Get("/profile", handler)
+	})
+	r.WithConfig(map[string]interface{}{"api": 1, "cache": "10s"}).Route("/api", func(r Router) {
+		r.Get("/", handler)
+		r.(*Mux).WithConfig(map[string]interface{}{"cache": "0s"}).Get("/users", handler)
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		config map[string]interface{}
+	}{
+		{"/", 200, nil},
+		{"/articles", 200, map[string]interface{}{"cache": "60s"}},
+		{"/admin", 401, map[string]interface{}{"auth": "required", "cache": "none"}},
+		{"/profile", 200, map[string]interface{}{"auth": "optional", "cache": "none"}},
+		{"/api/", 200, map[string]interface{}{"api": 1, "cache": "10s"}},
+		{"/api/users", 200, map[string]interface{}{"api": 1, "cache": "0s"}},
+		{"/nope", 404, nil},
+	}
+
+	for _, tt := range tests {
+		config = nil
+		resp, _ := testHandler(t, r, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s: expecting status %d, got %d", tt.path, tt.status, resp.StatusCode)
+		}
+		if !reflect.DeepEqual(config, tt.config) {
+			t.Fatalf("%s: expecting config %v, got %v", tt.path, tt.config, config)
+		}
+	}
+}
+
+func TestMuxRoutesConcurrentRegistration(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/", handler)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			r.Get(fmt.Sprintf("/route/%d", i), handler)
+			r.With().Post(fmt.Sprintf("/route/%d", i), handler)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, rt := range r.Routes() {
+				if rt.Pattern == "" || len(rt.Handlers) == 0 {
+					t.Errorf("expecting a complete route, got %v", rt)
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if n := len(r.Routes()); n != 101 {
+		t.Fatalf("expecting 101 routes, got %d", n)
+	}
+}
+
+func TestMuxErrorHandlersConcurrentRegistration(t *testing.T) {
+	text := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s))
+		}
+	}
+
+	r := NewRouter()
+	r.Get("/", text("index"))
+
+	// Run with -race, the not found, method not allowed and bad request
+	// handlers are set under the lock of the mux, while the mounts inherit them
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			r.NotFound(text("not found"))
+			r.NotFoundFor("POST", text("post not found"))
+			r.With().(*Mux).NotFoundPrefix("/api", text("api not found"))
+			r.MethodNotAllowed(text("not allowed"))
+			r.BadRequest(text("bad request"))
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			sub := NewRouter()
+			sub.Get("/", text("sub"))
+			r.Mount(fmt.Sprintf("/sub/%d", i), sub)
+			r.With().(*Mux).BadRequest(text("bad request"))
+		}
+	}()
+
+	wg.Wait()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/sub/0/missing", "not found"},
+		{"POST", "/sub/49/missing", "post not found"},
+		{"GET", "/api/missing", "api not found"},
+		{"DELETE", "/sub/0/", "not allowed"},
+	}
+	for _, tt := range tests {
+		if _, body := testHandler(t, r, tt.method, tt.path, nil); body != tt.body {
+			t.Fatalf("%s %s: expecting '%s', got '%s'", tt.method, tt.path, tt.body, body)
+		}
+	}
+}
+
+func TestMuxAny(t *testing.T) {
+	RegisterMethod("PURGE")
+
+	r := NewRouter()
+	r.Any("/any", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("any " + r.Method))
+	})
+	r.AnyAll("/all", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("all " + r.Method))
+	})
+	r.Route("/sub", func(r Router) {
+		r.(*Mux).Any("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("sub " + r.Method))
+		})
+	})
+
+	for _, m := range []string{"DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT"} {
+		if resp, body := testHandler(t, r, m, "/any", nil); resp.StatusCode != 200 || body != "any "+m {
+			t.Fatalf("%s /any: expecting 200 'any %s', got %d '%s'", m, m, resp.StatusCode, body)
+		}
+		if resp, body := testHandler(t, r, m, "/sub", nil); resp.StatusCode != 200 || body != "sub "+m {
+			t.Fatalf("%s /sub: expecting 200 'sub %s', got %d '%s'", m, m, resp.StatusCode, body)
+		}
+	}
+	for _, m := range []string{"CONNECT", "TRACE", "PURGE"} {
+		if resp, _ := testHandler(t, r, m, "/any", nil); resp.StatusCode != 405 {
+			t.Fatalf("%s /any: expecting 405, got %d", m, resp.StatusCode)
+		}
+	}
+	for _, m := range []string{"CONNECT", "DELETE", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE", "PURGE"} {
+		if resp, body := testHandler(t, r, m, "/all", nil); resp.StatusCode != 200 || body != "all "+m {
+			t.Fatalf("%s /all: expecting 200 'all %s', got %d '%s'", m, m, resp.StatusCode, body)
+		}
+	}
+
+	for _, rt := range r.Routes() {
+		switch rt.Pattern {
+		case "/any":
+			if len(rt.Handlers) != 7 || rt.Handlers["*"] != nil || rt.Handlers["TRACE"] != nil {
+				t.Fatalf("expecting the 7 common methods for /any, got %v", rt.Handlers)
 			}
+		case "/all":
+			if rt.Handlers["*"] == nil || rt.Handlers["TRACE"] == nil {
+				t.Fatalf("expecting all methods for /all, got %v", rt.Handlers)
+			}
+		}
+	}
+}
+
+func TestMuxAnyExcept(t *testing.T) {
+	RegisterMethod("PURGE")
+
+	r := NewRouter()
+	r.AnyExcept([]string{"DELETE", "trace"}, "/x", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("x " + r.Method))
+	})
+
+	for _, m := range []string{"CONNECT", "GET", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "PURGE"} {
+		if resp, body := testHandler(t, r, m, "/x", nil); resp.StatusCode != 200 || body != "x "+m {
+			t.Fatalf("%s /x: expecting 200 'x %s', got %d '%s'", m, m, resp.StatusCode, body)
+		}
+	}
+	for _, m := range []string{"DELETE", "TRACE"} {
+		if resp, _ := testHandler(t, r, m, "/x", nil); resp.StatusCode != 405 {
+			t.Fatalf("%s /x: expecting 405, got %d", m, resp.StatusCode)
+		}
+	}
+
+	rt := r.Routes()[0]
+	if rt.Handlers["*"] != nil || rt.Handlers["DELETE"] != nil || rt.Handlers["GET"] == nil {
+		t.Fatalf("expecting the handlers of all methods but DELETE and TRACE, got %v", rt.Handlers)
+	}
+
+	for _, except := range [][]string{{"FOO"}, methodNames()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expecting a panic for excluding %v", except)
+				}
+			}()
+			r.AnyExcept(except, "/y", func(w http.ResponseWriter, r *http.Request) {})
+		}()
+	}
+}
+
+func methodNames() []string {
+	var names []string
+	for name := range methodMap {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestMuxHandler(t *testing.T) {
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Mw", "root")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.UseOnMatch(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Match", "yes")
+			next.ServeHTTP(w, r)
 		})
+	})
+	r.SetDefaultHeader("X-Default", "yes")
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("root"))
+	})
+	r.With(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Inline", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}).Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id") + " " + RouteContext(r.Context()).RoutePattern()))
+	})
+	r.Route("/articles/{slug}", func(r Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Sub", "yes")
+				next.ServeHTTP(w, r)
+			})
+		})
+		r.Get("/comments/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("comment " + URLParam(r, "slug") + " " + URLParam(r, "id") + " " + RouteContext(r.Context()).RoutePattern()))
+		})
+	})
+
+	paths := []string{"/", "/users/1", "/articles/hello/comments/2"}
+
+	for _, path := range paths {
+		h, ok := r.Handler("GET", path)
+		if !ok {
+			t.Fatalf("%s: expecting a handler", path)
+		}
+
+		resp1, body1 := testHandler(t, h, "GET", path, nil)
+		resp2, body2 := testHandler(t, r, "GET", path, nil)
+		if body1 != body2 || resp1.StatusCode != resp2.StatusCode {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", path, resp2.StatusCode, body2, resp1.StatusCode, body1)
+		}
+		if !reflect.DeepEqual(resp1.Header, resp2.Header) {
+			t.Fatalf("%s: expecting headers %v, got %v", path, resp2.Header, resp1.Header)
+		}
+		if resp1.Header.Get("X-Match") != "yes" || resp1.Header.Get("X-Default") != "yes" {
+			t.Fatalf("%s: expecting the match middlewares and default headers, got %v", path, resp1.Header)
+		}
+	}
+
+	for _, tt := range []struct{ method, path string }{
+		{"GET", "/nope"},
+		{"POST", "/"},
+		{"GET", "/articles/hello/nope"},
+		{"NOPE", "/"},
+	} {
+		if _, ok := r.Handler(tt.method, tt.path); ok {
+			t.Fatalf("%s %s: expecting no handler", tt.method, tt.path)
+		}
+	}
+}
+
+func TestMuxWildcardSegments(t *testing.T) {
+	r := NewRouter()
+	r.Route("/repos", func(r Router) {
+		r.Get("/*/meta", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf("%s %s", URLParam(r, "*"), RouteContext(r.Context()).RoutePattern())))
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/repos/a/b/meta", nil); body != "a/b /repos/*/meta" {
+		t.Fatal(body)
+	}
+	if resp, _ := testRequest(t, ts, "GET", "/repos/a/b", nil); resp.StatusCode != 404 {
+		t.Fatalf("expecting 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestMuxWithRecover(t *testing.T) {
+	var recovered interface{}
+
+	r := NewMux(WithRecover(func(w http.ResponseWriter, r *http.Request, rvr interface{}) {
+		recovered = rvr
+		w.WriteHeader(500)
+		w.Write([]byte(fmt.Sprintf("recovered %v %s", rvr, URLParam(r, "id"))))
+	}))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("mw") != "" {
+				panic("middleware")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("handler")
+	})
+	r.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if resp, body := testRequest(t, ts, "GET", "/1", nil); resp.StatusCode != 500 || body != "recovered handler 1" {
+		t.Fatalf("expecting 500 'recovered handler 1', got %d '%s'", resp.StatusCode, body)
+	}
+	if resp, body := testRequest(t, ts, "GET", "/1?mw=1", nil); resp.StatusCode != 500 || body != "recovered middleware " {
+		t.Fatalf("expecting 500 'recovered middleware ', got %d '%s'", resp.StatusCode, body)
+	}
+
+	recovered = nil
+	func() {
+		defer func() {
+			if rvr := recover(); rvr != http.ErrAbortHandler {
+				t.Fatalf("expecting http.ErrAbortHandler to be re-panicked, got %v", rvr)
+			}
+		}()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	}()
+	if recovered != nil {
+		t.Fatalf("expecting no recovery, got %v", recovered)
+	}
+}
+
+func TestMuxContextRequest(t *testing.T) {
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Rewrite the request path ahead of routing
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/v1")
+			next.ServeHTTP(w, r2)
+		})
+	})
+	r.Get("/hi", func(w http.ResponseWriter, r *http.Request) {
+		orig := RouteContext(r.Context()).Request()
+		w.Write([]byte(fmt.Sprintf("%s %s", r.URL.Path, orig.URL.Path)))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/v1/hi", nil); body != "/hi /v1/hi" {
+		t.Fatal(body)
+	}
+
+	rctx := NewRouteContext()
+	rctx.request = httptest.NewRequest("GET", "/", nil)
+	rctx.Reset()
+	if rctx.Request() != nil {
+		t.Fatalf("expecting the request to be cleared on reset")
+	}
+}
+
+func TestMuxAccept(t *testing.T) {
+	r := NewRouter()
+	r.Accept("application/json").Get("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("json"))
+	})
+	r.Accept("text/csv").Get("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("csv"))
+	})
+	r.Accept("application/json").Get("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("json"))
+	})
+	r.Get("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("html"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		accept string
+		status int
+		body   string
+	}{
+		{"/data", "application/json", 200, "json"},
+		{"/data", "text/csv", 200, "csv"},
+		{"/data", "text/csv;q=0.5, application/json;q=0.9", 200, "json"},
+		{"/data", "text/*", 200, "csv"},
+		{"/data", "*/*", 200, "json"},
+		{"/data", "", 200, "json"},
+		{"/data", "*/*, application/json;q=0", 200, "csv"},
+		{"/data", "text/html", 406, "Not Acceptable\n"},
+		{"/items", "application/json", 200, "json"},
+		{"/items", "text/html", 200, "html"},
+		{"/items", "", 200, "html"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", ts.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status || string(body) != tt.body {
+			t.Fatalf("%s '%s': expecting %d '%s', got %d '%s'", tt.path, tt.accept, tt.status, tt.body, resp.StatusCode, body)
+		}
+		if resp.Header.Get("Vary") != "Accept" {
+			t.Fatalf("%s '%s': expecting Vary header", tt.path, tt.accept)
+		}
+	}
+
+	if resp, _ := testRequest(t, ts, "POST", "/data", nil); resp.StatusCode != 405 {
+		t.Fatalf("expecting 405, got %d", resp.StatusCode)
+	}
+}
+
+type testEndpoint struct{}
+
+func (h *testEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func TestChainEmpty(t *testing.T) {
+	h := &testEndpoint{}
+	if hh := Chain().Handler(h); hh != h {
+		t.Fatalf("expecting the endpoint handler, got %T", hh)
+	}
+	if hh := chain(nil, h); hh != h {
+		t.Fatalf("expecting the endpoint handler, got %T", hh)
+	}
+	if _, ok := Chain().HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}).(http.HandlerFunc); !ok {
+		t.Fatalf("expecting the endpoint handler func")
+	}
+	if _, ok := Chain(func(next http.Handler) http.Handler { return next }).Handler(h).(*ChainHandler); !ok {
+		t.Fatalf("expecting a chain handler")
+	}
+}
+
+func TestMuxMountMiddlewareOrder(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	c := NewRouter()
+	c.Use(mw("c1"), mw("c2"))
+	c.With(mw("c-inline")).Get("/leaf", handler("c-handler"))
+
+	b := NewRouter()
+	b.Use(mw("b1"))
+	b.Use(mw("b2"))
+	b.Get("/", handler("b-handler"))
+	b.With(mw("b-mount")).Mount("/c", c)
+
+	a := NewRouter()
+	a.Use(mw("a1"), mw("a2"))
+	a.Mount("/b", b)
+	a.Group(func(r Router) {
+		r.Use(mw("a-group"))
+		r.Get("/", handler("a-handler"))
+	})
+
+	ts := httptest.NewServer(a)
+	defer ts.Close()
+
+	tests := []struct {
+		path  string
+		order []string
+	}{
+		{"/", []string{"a1", "a2", "a-group", "a-handler"}},
+		{"/b", []string{"a1", "a2", "b1", "b2", "b-handler"}},
+		{"/b/c/leaf", []string{"a1", "a2", "b1", "b2", "b-mount", "c1", "c2", "c-inline", "c-handler"}},
+		{"/b/c/nope", []string{"a1", "a2", "b1", "b2", "b-mount", "c1", "c2"}},
+	}
+
+	for _, tt := range tests {
+		mu.Lock()
+		order = nil
+		mu.Unlock()
+
+		testRequest(t, ts, "GET", tt.path, nil)
+
+		mu.Lock()
+		got := order
+		mu.Unlock()
+		if !reflect.DeepEqual(got, tt.order) {
+			t.Fatalf("%s: expecting order %v, got %v", tt.path, tt.order, got)
+		}
+	}
+}
+
+func TestMuxInFlight(t *testing.T) {
+	const n = 20
+
+	var started sync.WaitGroup
+	release := make(chan struct{})
+
+	r := NewMux(WithInFlightTracking())
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		started.Done()
+		<-release
+		w.Write([]byte("ok"))
+	})
+
+	var done sync.WaitGroup
+	started.Add(n)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer done.Done()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+
+	started.Wait()
+	if count := r.InFlight(); count != n {
+		t.Fatalf("expecting %d requests in flight, got %d", n, count)
+	}
+
+	close(release)
+	done.Wait()
+	if count := r.InFlight(); count != 0 {
+		t.Fatalf("expecting no requests in flight, got %d", count)
+	}
+
+	// Tracking is disabled by default
+	r2 := NewRouter()
+	r2.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if count := r2.InFlight(); count != 0 {
+			t.Errorf("expecting no tracking, got %d", count)
+		}
+	})
+	r2.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestMuxReplaceMiddlewares(t *testing.T) {
+	maintenance := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+			w.Write([]byte("maintenance"))
+		})
+	}
+
+	r := NewRouter()
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/", nil); body != "ok" {
+		t.Fatal(body)
+	}
+	r.ReplaceMiddlewares(maintenance)
+	if resp, body := testRequest(t, ts, "GET", "/", nil); resp.StatusCode != 503 || body != "maintenance" {
+		t.Fatalf("%d %s", resp.StatusCode, body)
+	}
+	if len(r.Middlewares()) != 1 {
+		t.Fatalf("expecting 1 middleware, got %d", len(r.Middlewares()))
+	}
+	r.ReplaceMiddlewares()
+	if _, body := testRequest(t, ts, "GET", "/", nil); body != "ok" {
+		t.Fatal(body)
+	}
+
+	// Replace the middlewares while serving requests, for the race detector
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+				if body := w.Body.String(); body != "ok" && body != "maintenance" {
+					t.Errorf("unexpected body %q", body)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 50; j++ {
+		if j%2 == 0 {
+			r.ReplaceMiddlewares(maintenance)
+		} else {
+			r.ReplaceMiddlewares()
+		}
+	}
+	wg.Wait()
+
+	// Serving a request doesn't take the lock of the mux
+	r.mu.Lock()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	r.mu.Unlock()
+	if body := w.Body.String(); body != "ok" && body != "maintenance" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting a panic on an inline mux")
+		}
+	}()
+	r.With(maintenance).(*Mux).ReplaceMiddlewares()
+}
+
+func TestMuxReorderMiddlewares(t *testing.T) {
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Order", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	r := NewRouter()
+	r.Use(mw("logger"), mw("metrics"), mw("auth"))
+	auth := r.MiddlewareAt(2)
+	r.ReorderMiddlewares([]int{2, 0, 1})
+	if reflect.ValueOf(r.MiddlewareAt(0)).Pointer() != reflect.ValueOf(auth).Pointer() {
+		t.Fatalf("expecting the auth middleware first")
+	}
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if order := strings.Join(w.Header()["X-Order"], ", "); order != "auth, logger, metrics" {
+		t.Fatalf("expecting the order 'auth, logger, metrics', got '%s'", order)
+	}
+
+	invalid := [][]int{
+		{0, 1},
+		{0, 1, 1},
+		{0, 1, 3},
+		{-1, 0, 1},
+	}
+	for _, indices := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expecting a panic on the indices %v", indices)
+				}
+			}()
+			r2 := NewRouter()
+			r2.Use(mw("a"), mw("b"), mw("c"))
+			r2.ReorderMiddlewares(indices)
+		}()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting a panic after the routes are defined")
+		}
+	}()
+	r.ReorderMiddlewares([]int{0, 1, 2})
+}
+
+func TestMuxNotFoundFor(t *testing.T) {
+	r := NewRouter()
+	r.Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("articles"))
+	})
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("not found"))
+	})
+	r.NotFoundFor("post", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("no such collection"))
+	})
+	r.Route("/admin", func(r Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})
+		r.(*Mux).NotFoundFor("DELETE", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte("nothing to delete"))
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/articles", 200, "articles"},
+		{"GET", "/nope", 404, "not found"},
+		{"POST", "/nope", 404, "no such collection"},
+		{"POST", "/articles", 405, "Method Not Allowed\n"},
+		{"POST", "/admin/nope", 404, "no such collection"},
+		{"DELETE", "/admin/nope", 404, "nothing to delete"},
+		{"DELETE", "/nope", 404, "not found"},
+		{"GET", "/admin/nope", 404, "not found"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMuxServeBytes(t *testing.T) {
+	data := []byte("User-agent: *\nDisallow: /admin\n")
+
+	r := NewRouter()
+	r.ServeBytes("GET", "/robots.txt", "text/plain; charset=utf-8", data)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, "GET", "/robots.txt", nil)
+	if resp.StatusCode != 200 || body != string(data) {
+		t.Fatalf("expecting 200 '%s', got %d '%s'", data, resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Fatalf("expecting content type, got '%s'", ct)
+	}
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("expecting ETag and Last-Modified headers, got '%s' '%s'", etag, lastModified)
+	}
+
+	conditional := func(header, value string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", ts.URL+"/robots.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(header, value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	if resp, body := conditional("If-None-Match", etag); resp.StatusCode != 304 || body != "" {
+		t.Fatalf("expecting 304 for a matching ETag, got %d '%s'", resp.StatusCode, body)
+	}
+	if resp, body := conditional("If-None-Match", `"stale"`); resp.StatusCode != 200 || body != string(data) {
+		t.Fatalf("expecting 200 for a stale ETag, got %d '%s'", resp.StatusCode, body)
+	}
+	if resp, _ := conditional("If-Modified-Since", lastModified); resp.StatusCode != 304 {
+		t.Fatalf("expecting 304 for an unmodified time, got %d", resp.StatusCode)
+	}
+	if resp, _ := testRequest(t, ts, "POST", "/robots.txt", nil); resp.StatusCode != 405 {
+		t.Fatalf("expecting 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestMuxTryHandle(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	r := NewRouter()
+	if err := r.TryHandle("GET", "/ok", http.HandlerFunc(h)); err != nil {
+		t.Fatalf("expecting no error, got %v", err)
+	}
+	if err := r.TryMount("/sub", NewRouter()); err != nil {
+		t.Fatalf("expecting no error, got %v", err)
+	}
+
+	var countNodes func(n *node) int
+	countNodes = func(n *node) int {
+		count := 1
+		for _, nds := range n.children {
+			for _, cn := range nds {
+				count += countNodes(cn)
+			}
+		}
+		return count
+	}
+	nodes := countNodes(r.tree)
+
+	tests := []struct {
+		name string
+		err  error
+		msg  string
+	}{
+		{"empty pattern", r.TryHandle("GET", "", http.HandlerFunc(h)),
+			"chi: routing pattern must not be empty"},
+		{"missing leading slash", r.TryHandle("GET", "ok", http.HandlerFunc(h)),
+			"chi: routing pattern must begin with '/' in 'ok'"},
+		{"unsupported method", r.TryHandle("NOPE", "/ok", http.HandlerFunc(h)),
+			"chi: 'NOPE' http method is not supported."},
+		{"invalid param", r.TryHandle("GET", "/articles/{id", http.HandlerFunc(h)),
+			"chi: route param closing delimiter '}' is missing in '/articles/{id' at position 10"},
+		{"duplicate param", r.TryHandle("GET", "/articles/{id}/{id}", http.HandlerFunc(h)),
+			"chi: routing pattern '/articles/{id}/{id}' contains duplicate param key, 'id'"},
+		{"invalid regexp", r.TryHandle("GET", "/articles/{id:a(}", http.HandlerFunc(h)),
+			"chi: invalid regexp pattern '^a($' in route param"},
+		{"misplaced wildcard", r.TryHandle("GET", "/articles/*/{id}", http.HandlerFunc(h)),
+			"chi: wildcard '*' must be the last pattern in a route, or only be followed by static segments, otherwise use a '{param}'"},
+		{"empty mount pattern", r.TryMount("", NewRouter()),
+			"chi: routing pattern must not be empty"},
+		{"duplicate mount", r.TryMount("/sub", NewRouter()),
+			"chi: attempting to Mount() a handler on an existing path, '/sub'"},
+	}
+
+	for _, tt := range tests {
+		if tt.err == nil || tt.err.Error() != tt.msg {
+			t.Fatalf("%s: expecting error '%s', got '%v'", tt.name, tt.msg, tt.err)
+		}
+	}
+
+	// The invalid patterns leave no trace in the routing tree
+	if n := countNodes(r.tree); n != nodes {
+		t.Fatalf("expecting %d nodes in the routing tree, got %d", nodes, n)
+	}
+	if n := r.Len(); n != 2 {
+		t.Fatalf("expecting 2 routes, got %d", n)
+	}
+	for _, route := range r.Routes() {
+		if strings.HasPrefix(route.Pattern, "/articles") {
+			t.Fatalf("expecting no route along '/articles', got '%s'", route.Pattern)
+		}
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/ok", nil); body != "ok" {
+		t.Fatal(body)
+	}
+	if resp, _ := testRequest(t, ts, "GET", "/articles/1", nil); resp.StatusCode != 404 {
+		t.Fatalf("expecting 404 for an invalid route, got %d", resp.StatusCode)
+	}
+
+	func() {
+		defer func() {
+			if rvr := recover(); rvr != "chi: routing pattern must begin with '/' in 'ok'" {
+				t.Fatalf("expecting a panic, got %v", rvr)
+			}
+		}()
+		r.Get("ok", h)
+	}()
+}
+
+func TestMuxRouteParamPrefix(t *testing.T) {
+	r := NewRouter()
+	r.Route("/users/{userID}", func(r Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf("user:%s", URLParam(r, "userID"))))
+		})
+		r.Get("/posts", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(fmt.Sprintf("posts:%s", URLParam(r, "userID"))))
+		})
+		r.Route("/posts/{postID}", func(r Router) {
+			r.Get("/comments", func(w http.ResponseWriter, r *http.Request) {
+				rctx := RouteContext(r.Context())
+				w.Write([]byte(fmt.Sprintf("comments:%s:%s %s", URLParam(r, "userID"), URLParam(r, "postID"), rctx.RoutePattern())))
+			})
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/users/42", "user:42"},
+		{"/users/42/", "user:42"},
+		{"/users/42/posts", "posts:42"},
+		{"/users/42/posts/7/comments", "comments:42:7 /users/{userID}/posts/{postID}/comments"},
+	}
+
+	for _, tt := range tests {
+		if _, body := testRequest(t, ts, "GET", tt.path, nil); body != tt.body {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.path, tt.body, body)
+		}
+	}
+}
+
+func TestMuxSubrouter(t *testing.T) {
+	v1 := NewRouter()
+	v1.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1 users"))
+	})
+
+	api := NewRouter()
+	api.Mount("/v1", v1)
+	api.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+
+	r := NewRouter()
+	r.Mount("/api", api)
+	r.Mount("/static", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r.Route("/admin", func(r Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})
+	})
+
+	tests := []struct {
+		pattern string
+		path    string
+		body    string
+	}{
+		{"/api", "/ping", "pong"},
+		{"/api/", "/v1/users", "v1 users"},
+		{"/api/v1", "/users", "v1 users"},
+		{"/api/v1/*", "/users", "v1 users"},
+		{"/admin", "/", "admin"},
+	}
+
+	for _, tt := range tests {
+		h, ok := r.Subrouter(tt.pattern)
+		if !ok {
+			t.Fatalf("%s: expecting a subrouter", tt.pattern)
+		}
+
+		ts := httptest.NewServer(h)
+		_, body := testRequest(t, ts, "GET", tt.path, nil)
+		ts.Close()
+		if body != tt.body {
+			t.Fatalf("%s: expecting '%s', got '%s'", tt.pattern, tt.body, body)
+		}
+	}
+
+	for _, pattern := range []string{"/nope", "/static", "/api/ping", "/api/v2"} {
+		if h, ok := r.Subrouter(pattern); ok || h != nil {
+			t.Fatalf("%s: expecting no subrouter", pattern)
+		}
+	}
+}
+
+func TestMuxParamDefaults(t *testing.T) {
+	r := NewRouter()
+	r.Get("/page/{n=1}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("page %s %s", URLParam(r, "n"), RouteContext(r.Context()).RoutePattern())))
+	})
+	r.Get("/users/{id}/posts/{sort=recent}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("user %s posts %s", URLParam(r, "id"), URLParam(r, "sort"))))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/page/3", 200, "page 3 /page/{n=1}"},
+		{"/page", 200, "page 1 /page/{n=1}"},
+		{"/page/", 404, "404 page not found\n"},
+		{"/users/5/posts/top", 200, "user 5 posts top"},
+		{"/users/5/posts", 200, "user 5 posts recent"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	if routes := r.Routes(); len(routes) != 2 {
+		t.Fatalf("expecting 2 routes, got %d", len(routes))
+	}
+
+	if err := r.TryHandle("GET", "/page/{n=1}/edit", http.NotFoundHandler()); err == nil {
+		t.Fatalf("expecting an error for a param default ahead of the last segment")
+	}
+
+	// An explicit route along the path without the param default takes
+	// precedence, regardless of the order of registration
+	text := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s + " " + URLParam(r, "n")))
+		}
+	}
+	for _, explicitFirst := range []bool{true, false} {
+		r := NewRouter()
+		if explicitFirst {
+			r.Get("/page", text("explicit"))
+			r.Handle("/page/{n=1}", text("default"))
+		} else {
+			r.Handle("/page/{n=1}", text("default"))
+			r.Get("/page", text("explicit"))
+		}
+		for _, tt := range []struct{ method, path, body string }{
+			{"GET", "/page", "explicit "},
+			{"GET", "/page/2", "default 2"},
+			{"POST", "/page", "default 1"},
+		} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Body.String() != tt.body {
+				t.Fatalf("explicit first %v, %s %s: expecting '%s', got '%s'", explicitFirst, tt.method, tt.path, tt.body, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestMuxConcurrentUse(t *testing.T) {
+	mw := func(next http.Handler) http.Handler { return next }
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	// Use panics once a route is registered, regardless of the goroutine
+	r := NewRouter()
+	r.Get("/", h)
+
+	var wg sync.WaitGroup
+	var panics int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if recover() != nil {
+					atomic.AddInt32(&panics, 1)
+				}
+			}()
+			r.Use(mw)
+		}()
+	}
+	wg.Wait()
+	if panics != 10 {
+		t.Fatalf("expecting every Use to panic, got %d panics", panics)
+	}
+
+	// Concurrent middlewares and routes never race, and a middleware is
+	// only rejected once a route is registered
+	for i := 0; i < 20; i++ {
+		r := NewRouter()
+		var used, routed int32
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if recover() != nil && atomic.LoadInt32(&routed) == 0 {
+					t.Errorf("unexpected panic of Use ahead of the routes")
+				}
+			}()
+			r.Use(mw)
+			atomic.StoreInt32(&used, 1)
+		}()
+		go func() {
+			defer wg.Done()
+			atomic.StoreInt32(&routed, 1)
+			r.Get(fmt.Sprintf("/%d", i), h)
+			r.With(mw).Get("/with", h)
+		}()
+		wg.Wait()
+
+		if atomic.LoadInt32(&used) == 1 && len(r.Middlewares()) != 1 {
+			t.Fatalf("expecting the middleware to be used")
+		}
+	}
+}
+
+func TestPatternToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		expr    string
+		names   []string
+		paths   []string
+	}{
+		{"/", `^/$`, nil, []string{"/", "/a", ""}},
+		{"/articles/{id}", `^/articles/([^/]+)$`, []string{"id"},
+			[]string{"/articles/1", "/articles/", "/articles/1/", "/articles", "/articles/a.b"}},
+		{"/users/{id}/posts/{postID}", `^/users/([^/]*)/posts/([^/]+)$`, []string{"id", "postID"},
+			[]string{"/users/1/posts/2", "/users//posts/2", "/users/1/posts/", "/users/1/2/posts/3"}},
+		{"/slug/{month}-{day}", `^/slug/([^/]*?)-([^/]+)$`, []string{"month", "day"},
+			[]string{"/slug/sept-4", "/slug/sept-", "/slug/a-b-c", "/slug/sept"}},
+		{"/images/{name}.{ext}/{size}.jpg", `^/images/([^/]*?)\.([^/]*)/([^/]*?)\.jpg$`, []string{"name", "ext", "size"},
+			[]string{"/images/a.b.c/1.jpg", "/images/a.b.c/1.2.jpg", "/images/a/1.jpg", "/images/a.b/.jpg"}},
+		{`/date/{yyyy:\d{4}}/{mm:(0[1-9]|1[0-2])}`, `^/date/((?:[0-9]{4}))/((?:0[1-9]|1[0-2]))$`, []string{"yyyy", "mm"},
+			[]string{"/date/2017/04", "/date/17/04", "/date/2017/13", "/date/2017/4"}},
+		{"/files/*", `^/files/(.*)$`, []string{"*"},
+			[]string{"/files/", "/files/a/b.txt", "/files", "/filesx"}},
+		{"/files/*/meta", `^/files/(.+)/meta$`, []string{"*"},
+			[]string{"/files/a/meta", "/files/a/b/meta", "/files//meta", "/files/a/meta/b/meta", "/files/meta"}},
+		{"/page/{n=1}", `^/page(?:/([^/]+))?$`, []string{"n"},
+			[]string{"/page", "/page/3", "/page/", "/page/3/4"}},
+		{"/{lang=en}", `^/(?:([^/]+))?$`, []string{"lang"},
+			[]string{"/", "/fr", "", "/fr/"}},
+	}
+
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	for _, tt := range tests {
+		re, names := PatternToRegexp(tt.pattern)
+		if re.String() != tt.expr {
+			t.Fatalf("%s: expecting regexp '%s', got '%s'", tt.pattern, tt.expr, re.String())
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Fatalf("%s: expecting names %v, got %v", tt.pattern, tt.names, names)
+		}
+
+		r := NewRouter()
+		r.Get(tt.pattern, h)
+
+		for _, path := range tt.paths {
+			rctx := NewRouteContext()
+			matched := r.Match(rctx, "GET", path)
+			sub := re.FindStringSubmatch(path)
+			if matched != (sub != nil) {
+				t.Fatalf("%s: '%s' expecting match %v, got %v", tt.pattern, path, matched, sub != nil)
+			}
+			if !matched {
+				continue
+			}
+			for i, v := range sub[1:] {
+				if v == "" && strings.Contains(tt.pattern, "=") {
+					continue // param default
+				}
+				if rctx.routeParams.Values[i] != v {
+					t.Fatalf("%s: '%s' expecting param %s '%s', got '%s'", tt.pattern, path, names[i], rctx.routeParams.Values[i], v)
+				}
+			}
+		}
+	}
+}
+
+func TestMuxRoutePatterns(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Post("/articles/{id}", h)
+	r.Get("/articles/{id}", h)
+	r.Route("/admin", func(r Router) {
+		r.Get("/", h)
+		r.Get("/users/{id}", h)
+		r.Route("/settings", func(r Router) {
+			r.Get("/*", h)
+		})
+	})
+
+	expected := []string{"/", "/admin/", "/admin/settings/*", "/admin/users/{id}", "/articles/{id}"}
+	if patterns := r.RoutePatterns(); !reflect.DeepEqual(patterns, expected) {
+		t.Fatalf("expecting %v, got %v", expected, patterns)
+	}
+}
+
+func TestMuxPatternsForMethod(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Get("/articles/{id}", h)
+	r.Post("/articles", h)
+	r.Put("/articles/{id}", h)
+	r.HandleFunc("/ping", h)
+	r.Route("/admin", func(r Router) {
+		r.Get("/users", h)
+		r.Delete("/users/{id}", h)
+	})
+
+	tests := []struct {
+		method   string
+		expected []string
+	}{
+		{"GET", []string{"/", "/admin/users", "/articles/{id}", "/ping"}},
+		{"post", []string{"/articles", "/ping"}},
+		{"PUT", []string{"/articles/{id}", "/ping"}},
+		{"DELETE", []string{"/admin/users/{id}", "/ping"}},
+		{"PATCH", []string{"/ping"}},
+	}
+
+	for _, tt := range tests {
+		if patterns := r.PatternsForMethod(tt.method); !reflect.DeepEqual(patterns, tt.expected) {
+			t.Fatalf("%s: expecting %v, got %v", tt.method, tt.expected, patterns)
+		}
+	}
+}
+
+func testRequest(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (*http.Response, string) {
+	req, err := http.NewRequest(method, ts.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+		return nil, ""
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+		return nil, ""
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+		return nil, ""
+	}
+	defer resp.Body.Close()
+
+	return resp, string(respBody)
+}
+
+func testHandler(t *testing.T, h http.Handler, method, path string, body io.Reader) (*http.Response, string) {
+	r, _ := http.NewRequest(method, path, body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result(), string(w.Body.Bytes())
+}
+
+type testFileSystem struct {
+	open func(name string) (http.File, error)
+}
+
+func (fs *testFileSystem) Open(name string) (http.File, error) {
+	return fs.open(name)
+}
+
+type testFile struct {
+	name     string
+	contents []byte
+}
+
+func (tf *testFile) Close() error {
+	return nil
+}
+
+func (tf *testFile) Read(p []byte) (n int, err error) {
+	copy(p, tf.contents)
+	return len(p), nil
+}
+
+func (tf *testFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (tf *testFile) Readdir(count int) ([]os.FileInfo, error) {
+	stat, _ := tf.Stat()
+	return []os.FileInfo{stat}, nil
+}
+
+func (tf *testFile) Stat() (os.FileInfo, error) {
+	return &testFileInfo{tf.name, int64(len(tf.contents))}, nil
+}
+
+type testFileInfo struct {
+	name string
+	size int64
+}
+
+func (tfi *testFileInfo) Name() string       { return tfi.name }
+func (tfi *testFileInfo) Size() int64        { return tfi.size }
+func (tfi *testFileInfo) Mode() os.FileMode  { return 0755 }
+func (tfi *testFileInfo) ModTime() time.Time { return time.Now() }
+func (tfi *testFileInfo) IsDir() bool        { return false }
+func (tfi *testFileInfo) Sys() interface{}   { return nil }
+
+type ctxKey struct {
+	name string
+}
+
+func (k ctxKey) String() string {
+	return "context value " + k.name
+}
+
+func BenchmarkMux(b *testing.B) {
+	h1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h3 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h4 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h5 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h6 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	mx := NewRouter()
+	mx.Get("/", h1)
+	mx.Get("/hi", h2)
+	mx.Get("/sup/{id}/and/{this}", h3)
+
+	mx.Route("/sharing/{hash}", func(mx Router) {
+		mx.Get("/", h4)          // subrouter-1
+		mx.Get("/{network}", h5) // subrouter-1
+		mx.Get("/twitter", h5)
+		mx.Route("/direct", func(mx Router) {
+			mx.Get("/", h6) // subrouter-2
+		})
+	})
+
+	routes := []string{
+		"/",
+		"/sup/123/and/this",
+		"/sharing/aBc",         // subrouter-1
+		"/sharing/aBc/twitter", // subrouter-1
+		"/sharing/aBc/direct",  // subrouter-2
+	}
+
+	for _, path := range routes {
+		b.Run("route:"+path, func(b *testing.B) {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", path, nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				mx.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func BenchmarkChain(b *testing.B) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	chains := []struct {
+		name string
+		h    http.Handler
+	}{
+		{"endpoint", h},
+		{"empty", Chain().Handler(h)},
+		{"middleware", Chain(mw).Handler(h)},
+	}
+
+	for _, c := range chains {
+		b.Run(c.name, func(b *testing.B) {
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "/", nil)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				c.h.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func BenchmarkMuxStatic(b *testing.B) {
+	mx := NewRouter()
+	mx.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/search", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/articles/search", nil)
+
+	b.Run("ServeHTTP", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mx.ServeHTTP(w, r)
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		mx := NewMux(WithPooledRequests())
+		mx.Get("/articles/search", func(w http.ResponseWriter, r *http.Request) {})
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mx.ServeHTTP(w, r)
+		}
+	})
+
+	// A request which already has a routing context, like one routed to a
+	// mounted router
+	b.Run("routing", func(b *testing.B) {
+		rctx := NewRouteContext()
+		r := r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx))
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			rctx.Reset()
+			mx.ServeHTTP(w, r)
+		}
+	})
+}
+
+func BenchmarkMuxFindRouteInto(b *testing.B) {
+	users := NewRouter()
+	users.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	mx := NewRouter()
+	mx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Mount("/users", users)
+
+	paths := []string{"/articles/1", "/users/2", "/articles/search/3"}
+
+	// A new routing context for each match
+	b.Run("Match", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mx.Match(NewRouteContext(), "GET", paths[i%len(paths)])
+		}
+	})
+
+	b.Run("FindRouteInto", func(b *testing.B) {
+		rctx := NewRouteContext()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mx.FindRouteInto(rctx, "GET", paths[i%len(paths)])
+		}
+	})
+}
+
+func BenchmarkMuxResolveChain(b *testing.B) {
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+		})
+	}
+	mx := NewRouter()
+	mx.Use(mw, mw)
+	mx.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/search", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/{id}/comments/{commentID}", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/users/{id}/settings/*", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/articles/1/comments/2", nil)
+
+	b.Run("ServeHTTP", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mx.ServeHTTP(w, r)
+		}
+	})
+
+	b.Run("ResolveChain", func(b *testing.B) {
+		h, _, _ := mx.ResolveChain("GET", "/articles/1/comments/2")
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func TestMuxResolveChain(t *testing.T) {
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Order", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	users := NewRouter()
+	users.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id") + " " + RouteContext(r.Context()).RoutePattern()))
+	})
+
+	r := NewRouter()
+	r.Use(mw("mux"))
+	r.UseOnMatch(mw("match"))
+	r.UseFor("/articles/*", mw("articles"))
+	r.With(mw("inline")).Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("article " + URLParam(r, "id") + " " + RouteContext(r.Context()).RoutePattern()))
+	})
+	r.Mount("/users", users)
+
+	h, pattern, ok := r.ResolveChain("GET", "/articles/1")
+	if !ok || pattern != "/articles/{id}" {
+		t.Fatalf("expecting the route '/articles/{id}', got %v '%s'", ok, pattern)
+	}
+
+	// The handler is invoked concurrently, without searching the routing tree
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", "/articles/1", nil))
+				if body := w.Body.String(); body != "article 1 /articles/{id}" {
+					t.Errorf("expecting 'article 1 /articles/{id}', got '%s'", body)
+				}
+				if order := strings.Join(w.Header()["X-Order"], ", "); order != "mux, match, articles, inline" {
+					t.Errorf("expecting the order 'mux, match, articles, inline', got '%s'", order)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The URL params are the ones of each request, so the handler may be
+	// cached by pattern, while the other paths are routed as usual
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/articles/2", 200, "article 2 /articles/{id}"},
+		{"GET", "/users/3", 200, "user 3 /users/{id}"},
+		{"POST", "/articles/2", 405, "Method Not Allowed\n"},
+		{"GET", "/nope", 404, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		resp, body := testHandler(t, h, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The routes of a mounted router are searched once the mount is reached
+	h, pattern, ok = r.ResolveChain("GET", "/users/2")
+	if !ok || pattern != "/users/*" {
+		t.Fatalf("expecting the route '/users/*', got %v '%s'", ok, pattern)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/users/2", nil))
+	if body := w.Body.String(); body != "user 2 /users/{id}" {
+		t.Fatalf("expecting 'user 2 /users/{id}', got '%s'", body)
+	}
+
+	for _, tt := range [][2]string{{"GET", "/nope"}, {"POST", "/articles/1"}, {"FOO", "/articles/1"}} {
+		if h, _, ok := r.ResolveChain(tt[0], tt[1]); ok || h != nil {
+			t.Fatalf("%s %s: expecting no route", tt[0], tt[1])
+		}
+	}
+}
+
+func TestMuxFindRouteInto(t *testing.T) {
+	users := NewRouter()
+	users.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	})
+	files := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("files"))
+	})
+
+	mx := NewRouter()
+	mx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("article"))
+	})
+	mx.Mount("/users", users)
+	mx.Mount("/files", files)
+
+	rctx := NewRouteContext()
+	tests := []struct {
+		method  string
+		path    string
+		body    string
+		pattern string
+		id      string
+	}{
+		{"GET", "/articles/1", "article", "/articles/{id}", "1"},
+		{"GET", "/users/2", "user", "/users/*/{id}", "2"},
+		{"GET", "/files/a.txt", "files", "/files/*", ""},
+		{"POST", "/articles/1", "", "", ""},
+		{"GET", "/missing", "", "", ""},
+		{"FOO", "/articles/1", "", "", ""},
+	}
+	for _, tt := range tests {
+		h, ok := mx.FindRouteInto(rctx, tt.method, tt.path)
+		if ok != (tt.body != "") {
+			t.Fatalf("%s %s: expecting found=%v, got %v", tt.method, tt.path, tt.body != "", ok)
+		}
+		if !ok {
+			continue
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx)))
+		if w.Body.String() != tt.body {
+			t.Fatalf("%s %s: expecting the handler of '%s', got '%s'", tt.method, tt.path, tt.body, w.Body.String())
+		}
+		if p := strings.Join(rctx.RoutePatterns, ""); p != tt.pattern || rctx.URLParam("id") != tt.id {
+			t.Fatalf("%s %s: expecting '%s' id=%s, got '%s' id=%s", tt.method, tt.path, tt.pattern, tt.id, p, rctx.URLParam("id"))
+		}
+	}
+
+	// The reused context doesn't allocate once its buffers have grown, while
+	// the routing path of a mounted router is built for it
+	if allocs := testing.AllocsPerRun(100, func() {
+		mx.FindRouteInto(rctx, "GET", "/articles/1")
+	}); allocs != 0 {
+		t.Fatalf("expecting no allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkURLParamInt(b *testing.B) {
+	mx := NewRouter()
+	mx.Get("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {})
+
+	rctx := NewRouteContext()
+	if !mx.Match(rctx, "GET", "/users/1234567") {
+		b.Fatalf("expecting the route to match")
+	}
+	r, _ := http.NewRequest("GET", "/users/1234567", nil)
+	r = r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx))
+
+	// Parsing the param in the handler
+	b.Run("Atoi", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := strconv.Atoi(URLParam(r, "id")); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	// The value parsed once by the matcher
+	b.Run("URLParamInt", func(b *testing.B) {
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			if _, ok := URLParamInt(r, "id"); !ok {
+				b.Fatal("expecting an int param")
+			}
+		}
+	})
+}
+
+func TestMuxStaticRouteAllocs(t *testing.T) {
+	mx := NewRouter()
+	mx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mx.Get("/articles/search", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/articles/search", nil)
+
+	// Routing a static route doesn't allocate, besides setting the routing
+	// context on the request, ie. the request copy and its context value
+	rctx := NewRouteContext()
+	rr := r.WithContext(context.WithValue(r.Context(), RouteCtxKey, rctx))
+	if allocs := testing.AllocsPerRun(100, func() {
+		rctx.Reset()
+		mx.ServeHTTP(w, rr)
+	}); allocs != 0 {
+		t.Fatalf("expecting 0 allocs to route a static route, got %v", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { mx.ServeHTTP(w, r) }); allocs > 2 {
+		t.Fatalf("expecting at most 2 allocs to serve a static route, got %v", allocs)
+	}
+
+	// Reusing the request copy and its context doesn't allocate at all
+	pmx := NewMux(WithPooledRequests())
+	pmx.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	pmx.Get("/articles/search", func(w http.ResponseWriter, r *http.Request) {
+		if RouteContext(r.Context()).RoutePattern() != "/articles/search" {
+			t.Fatalf("expecting the routing context on the pooled request")
+		}
+	})
+	if allocs := testing.AllocsPerRun(100, func() { pmx.ServeHTTP(w, r) }); allocs != 0 {
+		t.Fatalf("expecting 0 allocs to serve a static route, got %v", allocs)
+	}
+	if r.Context().Value(RouteCtxKey) != nil {
+		t.Fatalf("expecting the original request to be left untouched")
+	}
+}
+
+func TestMuxRoutePatternFileServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/app.js", []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var pattern string
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			pattern = RouteContext(r.Context()).RoutePattern()
+		})
+	})
+	r.Get("/static/*", http.StripPrefix("/static", http.FileServer(http.Dir(dir))).ServeHTTP)
+
+	assets := NewRouter()
+	assets.Get("/*", http.StripPrefix("/assets", http.FileServer(http.Dir(dir))).ServeHTTP)
+	r.Mount("/assets", assets)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path    string
+		pattern string
+	}{
+		{"/static/app.js", "/static/*"},
+		{"/assets/app.js", "/assets/*"},
+	}
+
+	for _, tt := range tests {
+		pattern = ""
+		if _, body := testRequest(t, ts, "GET", tt.path, nil); body != "app" {
+			t.Fatalf("%s: expecting 'app', got '%s'", tt.path, body)
+		}
+		if pattern != tt.pattern {
+			t.Fatalf("%s: expecting pattern '%s', got '%s'", tt.path, tt.pattern, pattern)
+		}
+	}
+}
+
+func TestMuxEmptyWildcard(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("[%s] %s", URLParam(r, "*"), RouteContext(r.Context()).RoutePattern())))
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a", 404, "404 page not found\n"},
+		{"/a/", 200, "[] /a/*"},
+		{"/a/b", 200, "[b] /a/*"},
+	}
+
+	r := NewRouter()
+	r.Get("/a/*", h)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The base path is routed along the wildcard with the option
+	tests[0].status, tests[0].body = 200, "[] /a/*"
+
+	r2 := NewMux(WithEmptyWildcard())
+	r2.With(func(next http.Handler) http.Handler { return next }).Get("/a/*", h)
+
+	ts2 := httptest.NewServer(r2)
+	defer ts2.Close()
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts2, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	if routes := r2.Routes(); len(routes) != 1 {
+		t.Fatalf("expecting 1 route, got %d", len(routes))
+	}
+}
+
+func TestMuxWithBeforeWrite(t *testing.T) {
+	var calls int32
+	r := NewMux(WithBeforeWrite(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Route", RouteContext(r.Context()).RoutePattern())
+	}))
+	r.Get("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte("article "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(URLParam(r, "id")))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+		route  string
+	}{
+		{"/articles/5", 200, "article 5", "/articles/{id}"},
+		{"/missing", 404, "404 page not found\n", ""},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreInt32(&calls, 0)
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+		if route := resp.Header.Get("X-Route"); route != tt.route {
+			t.Fatalf("%s: expecting X-Route '%s', got '%s'", tt.path, tt.route, route)
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Fatalf("%s: expecting the hook to be called once, got %d", tt.path, n)
+		}
+	}
+}
+
+// hijackWriter is a http.ResponseWriter that satisfies http.Hijacker, but not
+// http.Flusher nor http.CloseNotifier.
+type hijackWriter struct {
+	http.ResponseWriter
+	hijacked bool
+}
+
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestMuxWithBeforeWriteInterfaces(t *testing.T) {
+	r := NewMux(WithBeforeWrite(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Before", "1")
+	}))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		_, fl := w.(http.Flusher)
+		_, hj := w.(http.Hijacker)
+		_, cn := w.(http.CloseNotifier)
+		_, rf := w.(io.ReaderFrom)
+		w.Write([]byte(fmt.Sprintf("%v %v %v %v", fl, hj, cn, rf)))
+	})
+	r.Get("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		if hj, ok := w.(http.Hijacker); ok {
+			hj.Hijack()
+		}
+	})
+
+	// The writer of the http server has every optional interface
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	if resp, body := testRequest(t, ts, "GET", "/", nil); body != "true true true true" || resp.Header.Get("X-Before") != "1" {
+		t.Fatalf("expecting every optional interface, got '%s'", body)
+	}
+
+	// The http.Hijacker is kept without the other optional interfaces
+	w := &hijackWriter{ResponseWriter: httptest.NewRecorder()}
+	req, _ := http.NewRequest("GET", "/hijack", nil)
+	r.ServeHTTP(w, req)
+	if !w.hijacked {
+		t.Fatalf("expecting the connection to be hijacked")
+	}
+
+	rec := httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	r.ServeHTTP(rec, req)
+	if body := rec.Body.String(); body != "true false false false" {
+		t.Fatalf("expecting only http.Flusher, got '%s'", body)
+	}
+}
+
+func TestMuxNotFoundPrefix(t *testing.T) {
+	notFound := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(404)
+			w.Write([]byte(body))
+		}
+	}
+
+	r := NewRouter()
+	r.NotFound(notFound("<h1>not found</h1>"))
+	r.NotFoundPrefix("/api", notFound(`{"error":"not found"}`))
+	r.NotFoundPrefix("/api/v2/", notFound(`{"error":"not found","version":2}`))
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("home"))
+	})
+
+	api := NewRouter()
+	api.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	})
+	r.Mount("/api", api)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", 200, "home"},
+		{"/api/ping", 200, "pong"},
+		{"/missing", 404, "<h1>not found</h1>"},
+		{"/apis", 404, "<h1>not found</h1>"},
+		{"/api/missing", 404, `{"error":"not found"}`},
+		{"/api/v2", 404, `{"error":"not found","version":2}`},
+		{"/api/v2/missing", 404, `{"error":"not found","version":2}`},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMuxFallback(t *testing.T) {
+	legacy := http.NewServeMux()
+	legacy.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("legacy " + r.Method + " " + r.URL.Path))
+	})
+
+	r := NewRouter()
+	r.Route("/api", func(r Router) {
+		r.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("users"))
+		})
+	})
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+	r.Fallback(legacy)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/api/users", 200, "users"},
+		{"GET", "/", 200, "legacy GET /"},
+		{"GET", "/about/team", 200, "legacy GET /about/team"},
+		{"POST", "/about", 200, "legacy POST /about"},
+		{"POST", "/health", 200, "legacy POST /health"},
+
+		// Mounted routers don't inherit the fallback
+		{"GET", "/api/nope", 404, "404 page not found\n"},
+		{"POST", "/api/users", 405, "Method Not Allowed\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// A chi router as the fallback routes the same path, and the fallback of
+	// an inline group runs through the middlewares of the group
+	old := NewRouter()
+	old.Get("/posts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("post " + URLParam(r, "id") + " " + RouteContext(r.Context()).RoutePattern() + " " + w.Header().Get("X-Fallback")))
+	})
+	r2 := NewRouter()
+	r2.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r2.With(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Fallback", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}).(*Mux).Fallback(old)
+
+	w := httptest.NewRecorder()
+	r2.ServeHTTP(w, httptest.NewRequest("GET", "/posts/1", nil))
+	if body := w.Body.String(); body != "post 1 /posts/{id} yes" {
+		t.Fatalf("expecting 'post 1 /posts/{id} yes', got '%s'", body)
+	}
+
+	// The fallback of nested groups runs through the middlewares of each group
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Group", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	r3 := NewRouter()
+	r3.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r3.Group(func(r Router) {
+		r.Use(mw("outer"))
+		r.Group(func(r Router) {
+			r.Use(mw("inner"))
+			r.(*Mux).Fallback(old)
+		})
+	})
+
+	w = httptest.NewRecorder()
+	r3.ServeHTTP(w, httptest.NewRequest("GET", "/posts/2", nil))
+	if body := w.Body.String(); !strings.HasPrefix(body, "post 2 ") {
+		t.Fatalf("expecting the fallback, got '%s'", body)
+	}
+	if groups := strings.Join(w.Header()["X-Group"], ", "); groups != "outer, inner" {
+		t.Fatalf("expecting the middlewares 'outer, inner', got '%s'", groups)
+	}
+}
+
+func TestMuxBadRequest(t *testing.T) {
+	r := NewRouter()
+	r.Get("/files/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("file " + URLParam(r, "name")))
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/files/caf%C3%A9", 200, "file café"},
+		{"/files/100%25", 200, "file 100%"},
+		{"/files/%ff", 400, "Bad Request\n"},
+		{"/files/caf%C3", 400, "Bad Request\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The error handler of the 400 status answers the malformed paths, unless
+	// a BadRequest handler is set
+	r.SetErrorHandler(400, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte("bad request"))
+	})
+	if resp, body := testRequest(t, ts, "GET", "/files/%ff", nil); resp.StatusCode != 400 || body != "bad request" {
+		t.Fatalf("expecting 400 'bad request', got %d '%s'", resp.StatusCode, body)
+	}
+
+	r.BadRequest(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte("malformed path"))
+	})
+	if hFn := r.ErrorHandler(400); hFn == nil {
+		t.Fatal("expecting the error handler of the 400 status")
+	} else {
+		w := httptest.NewRecorder()
+		hFn(w, httptest.NewRequest("GET", "/", nil))
+		if w.Body.String() != "bad request" {
+			t.Fatalf("expecting the 400 error handler to be kept, got '%s'", w.Body.String())
+		}
+	}
+
+	// A malformed escape in the raw path, ie. set by a middleware
+	req := httptest.NewRequest("GET", "/", nil)
+	req.URL.Path, req.URL.RawPath = "/files/%zz", "/files/%zz"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != 400 || w.Body.String() != "malformed path" {
+		t.Fatalf("expecting 400 'malformed path', got %d '%s'", w.Code, w.Body.String())
+	}
+
+	resp, body := testRequest(t, ts, "GET", "/files/%ff", nil)
+	if resp.StatusCode != 400 || body != "malformed path" {
+		t.Fatalf("expecting 400 'malformed path', got %d '%s'", resp.StatusCode, body)
+	}
+}
+
+func TestMuxWithMaxURLLength(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}
+
+	r := NewMux(WithMaxURLLength(16))
+	r.Get("/*", h)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/0123456789abcde", 200, "ok"},
+		{"/0123456789abcdef", 414, "Request URI Too Long\n"},
+		{"/" + strings.Repeat("a", 1024), 414, "Request URI Too Long\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// Unlimited by default
+	r2 := NewRouter()
+	r2.Get("/*", h)
+
+	ts2 := httptest.NewServer(r2)
+	defer ts2.Close()
+
+	if resp, body := testRequest(t, ts2, "GET", "/"+strings.Repeat("a", 1024), nil); resp.StatusCode != 200 || body != "ok" {
+		t.Fatalf("expecting 200 'ok', got %d '%s'", resp.StatusCode, body)
+	}
+}
+
+func TestMuxMatchRoute(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/articles/{id}", h)
+	r.Route("/admin", func(r Router) {
+		r.Post("/users", h)
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		err    error
+	}{
+		{"GET", "/articles/1", nil},
+		{"GET", "/nope", ErrRouteNotFound},
+		{"DELETE", "/articles/1", ErrMethodNotAllowed},
+		{"FOO", "/articles/1", ErrMethodNotAllowed},
+		{"POST", "/admin/users", nil},
+		{"POST", "/admin/nope", ErrRouteNotFound},
+		{"GET", "/admin/users", ErrMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		if err := r.MatchRoute(NewRouteContext(), tt.method, tt.path); err != tt.err {
+			t.Fatalf("%s %s: expecting error %v, got %v", tt.method, tt.path, tt.err, err)
+		}
+		if ok := r.Match(NewRouteContext(), tt.method, tt.path); ok != (tt.err == nil) {
+			t.Fatalf("%s %s: expecting match %v, got %v", tt.method, tt.path, tt.err == nil, ok)
+		}
+	}
+}
+
+func TestMuxMountShared(t *testing.T) {
+	shared := NewRouter()
+	shared.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("%s %s", RouteContext(r.Context()).RoutePattern(), URLParam(r, "id"))))
+	})
+
+	r := NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("not found"))
+	})
+	r.Mount("/a", shared)
+	r.Route("/{tenant}", func(r Router) {
+		r.Mount("/b", shared)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a/1", 200, "/a/{id} 1"},
+		{"/acme/b/2", 200, "/{tenant}/b/{id} 2"},
+		{"/a/1/nope", 404, "not found"},
+		{"/acme/b/2/nope", 404, "not found"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMuxMountBalanced(t *testing.T) {
+	backend := func(name string) http.Handler {
+		r := NewRouter()
+		r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + URLParam(r, "id")))
+		})
+		return r
+	}
+
+	r := NewRouter()
+	r.MountBalanced("/api", []http.Handler{backend("a"), backend("b")}, []int{3, 1})
+	r.MountBalanced("/even", []http.Handler{backend("c"), backend("d")}, nil)
+
+	counts := map[string]int{}
+	var order []string
+	for i := 0; i < 400; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/api/items/7", nil))
+		body := w.Body.String()
+		counts[body]++
+		if i < 4 {
+			order = append(order, body)
+		}
+	}
+	if counts["a 7"] != 300 || counts["b 7"] != 100 || len(counts) != 2 {
+		t.Fatalf("expecting a 300 and b 100 requests, got %v", counts)
+	}
+	if !reflect.DeepEqual(order, []string{"a 7", "a 7", "b 7", "a 7"}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	counts = map[string]int{}
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/even/items/1", nil))
+		counts[w.Body.String()]++
+	}
+	if counts["c 1"] != 5 || counts["d 1"] != 5 {
+		t.Fatalf("expecting an even distribution, got %v", counts)
+	}
+
+	// The schedule is as long as the weights divided by their common divisor
+	r.MountBalanced("/large", []http.Handler{backend("g"), backend("h")}, []int{3000000, 1000000})
+	if n := len(newBalancedHandler([]http.Handler{backend("g"), backend("h")}, []int{3000000, 1000000}).schedule); n != 4 {
+		t.Fatalf("expecting a schedule of 4 backends, got %d", n)
+	}
+	order = nil
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/large/items/2", nil))
+		order = append(order, w.Body.String())
+	}
+	if !reflect.DeepEqual(order, []string{"g 2", "g 2", "h 2", "g 2"}) {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	for _, weights := range [][]int{{1}, {0, 0}, {-1, 2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expecting a panic for the weights %v", weights)
+				}
+			}()
+			r.MountBalanced("/invalid", []http.Handler{backend("e"), backend("f")}, weights)
+		}()
+	}
+}
+
+func TestMuxWithHandlerWrapper(t *testing.T) {
+	var wrapped []string
+	var r *Mux
+	r = NewMux(WithHandlerWrapper(func(pattern string, h http.Handler) http.Handler {
+		// The wrapper may inspect the mux, which isn't locked meanwhile
+		r.Routes()
+		r.Len()
+		wrapped = append(wrapped, pattern)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Span", pattern)
+			h.ServeHTTP(w, r)
+		})
+	}))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Span", "middleware")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(w.Header().Get("X-Span")))
+	}
+	r.Get("/", h)
+	r.With(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Span", "inline")
+			next.ServeHTTP(w, r)
+		})
+	}).Get("/inline", h)
+	r.Route("/articles", func(r Router) {
+		r.Get("/{id}", h)
+		r.Route("/{id}/comments", func(r Router) {
+			r.Post("/", h)
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		span   string
+	}{
+		{"GET", "/", "/"},
+		{"GET", "/inline", "/inline"},
+		{"GET", "/articles/1", "/articles/{id}"},
+		{"POST", "/articles/1/comments/", "/articles/{id}/comments/"},
+	}
+
+	for _, tt := range tests {
+		if _, body := testRequest(t, ts, tt.method, tt.path, nil); body != tt.span {
+			t.Fatalf("%s %s: expecting span '%s', got '%s'", tt.method, tt.path, tt.span, body)
+		}
+	}
+
+	expected := []string{"/", "/inline", "/articles/{id}", "/articles/{id}/comments/"}
+	if !reflect.DeepEqual(wrapped, expected) {
+		t.Fatalf("expecting wrapped patterns %v, got %v", expected, wrapped)
+	}
+}
+
+func TestMuxFlushStreaming(t *testing.T) {
+	// An SSE handler that waits for the client to receive each event before
+	// sending the next one, which only works if the events are flushed.
+	received := make(chan struct{})
+	sse := func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", 500)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "data: event %d\n\n", i)
+			flusher.Flush()
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	routers := map[string]*Mux{
+		"plain":        NewRouter(),
+		"before write": NewMux(WithBeforeWrite(func(w http.ResponseWriter, r *http.Request) {})),
+	}
+
+	for name, r := range routers {
+		r.Use(mw)
+		r.With(mw).Get("/events", sse)
+		r.Route("/sub", func(r Router) {
+			r.Use(mw)
+			r.Handle("/events", Chain(mw, mw).HandlerFunc(sse))
+		})
+
+		ts := httptest.NewServer(r)
+
+		for _, path := range []string{"/events", "/sub/events"} {
+			resp, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != 200 {
+				t.Fatalf("%s %s: expecting 200, got %d", name, path, resp.StatusCode)
+			}
+
+			br := bufio.NewReader(resp.Body)
+			for i := 1; i <= 3; i++ {
+				line, err := br.ReadString('\n')
+				if err != nil {
+					t.Fatalf("%s %s: %v", name, path, err)
+				}
+				if expected := fmt.Sprintf("data: event %d\n", i); line != expected {
+					t.Fatalf("%s %s: expecting '%s', got '%s'", name, path, expected, line)
+				}
+				br.ReadString('\n')
+				select {
+				case received <- struct{}{}:
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%s %s: expecting event %d to be flushed", name, path, i)
+				}
+			}
+			resp.Body.Close()
+		}
+
+		ts.Close()
+	}
+}
+
+func TestMuxURLParamsLen(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fmt.Sprintf("%d", RouteContext(r.Context()).URLParams.Len())))
+	}
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Get("/users/{id}/posts/{postID}", h)
+	r.Route("/{tenant}", func(r Router) {
+		r.Get("/ping", h)
+		r.Get("/items/{id}", h)
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path string
+		len  string
+	}{
+		{"/", "0"},
+		{"/users/1/posts/2", "2"},
+		{"/acme/ping", "2"},    // {tenant} and the mount's *
+		{"/acme/items/3", "3"}, // {tenant}, the mount's * and {id}
+	}
+
+	for _, tt := range tests {
+		if _, body := testRequest(t, ts, "GET", tt.path, nil); body != tt.len {
+			t.Fatalf("%s: expecting %s params, got %s", tt.path, tt.len, body)
+		}
+	}
+}
+
+func TestMuxURLParamsRange(t *testing.T) {
+	r := NewRouter()
+	r.Route("/{tenant}", func(r Router) {
+		r.Get("/users/{userID}/posts/{postID}", func(w http.ResponseWriter, r *http.Request) {
+			var params []string
+			RouteContext(r.Context()).URLParams.Range(func(key, value string) bool {
+				params = append(params, key+"="+value)
+				return key != "userID"
+			})
+			w.Write([]byte(strings.Join(params, " ")))
+		})
+	})
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/acme/users/1/posts/2", nil); body != "tenant=acme *=users/1/posts/2 userID=1" {
+		t.Fatal(body)
+	}
+}
+
+func TestContextDetach(t *testing.T) {
+	type result struct {
+		id, pattern string
+	}
+	results := make(chan result, 1)
+	served := make(chan struct{})
+
+	r := NewRouter()
+	r.Route("/users", func(r Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if URLParam(r, "id") != "1" {
+				return
+			}
+			rctx := RouteContext(r.Context()).Detach()
+			go func() {
+				// Read the params once the request is served, and the routing
+				// context is recycled by other requests
+				<-served
+				results <- result{rctx.URLParam("id"), rctx.RoutePattern()}
+			}()
+		})
+	})
+	r.Get("/articles/{slug}", func(w http.ResponseWriter, r *http.Request) {})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/1", nil))
+	for i := 0; i < 10; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/users/%d", i+2), nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/articles/chi", nil))
+	}
+	close(served)
+
+	if res := <-results; res.id != "1" || res.pattern != "/users/{id}" {
+		t.Fatalf("expecting the detached params of the request, got %+v", res)
+	}
+
+	// The detached context works as a request context
+	rctx := NewRouteContext()
+	rctx.URLParams.Add("id", "7")
+	ctx := context.WithValue(context.Background(), RouteCtxKey, rctx.Detach())
+	rctx.Reset()
+	if id := URLParamFromCtx(ctx, "id"); id != "7" {
+		t.Fatalf("expecting the detached param, got '%s'", id)
+	}
+}
+
+func TestMuxPriority(t *testing.T) {
+	route := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			rctx := RouteContext(r.Context())
+			w.Write([]byte(fmt.Sprintf("%s %s %v", name, rctx.RoutePattern(), rctx.URLParams.Values)))
+		}
+	}
+
+	r := NewRouter()
+	r.Get("/users/me", route("me"))
+	r.Priority(1).Get("/users/{id}", route("user"))
+	r.Get("/posts/latest", route("latest"))
+	r.Get("/posts/{id}", route("post"))
+	r.Get("/files/{name}", route("file"))
+	r.Priority(2).Get("/files/*", route("files"))
+	r.Priority(-1).Get("/docs/index", route("index"))
+	r.Get("/docs/{page}", route("page"))
+	r.Post("/forms/contact", route("contact"))
+	r.Priority(1).Get("/forms/{name}", route("form"))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/users/me", 200, "user /users/{id} [me]"},
+		{"GET", "/users/5", 200, "user /users/{id} [5]"},
+		{"GET", "/posts/latest", 200, "latest /posts/latest []"},
+		{"GET", "/posts/5", 200, "post /posts/{id} [5]"},
+		{"GET", "/files/a.txt", 200, "files /files/* [a.txt]"},
+		{"GET", "/docs/index", 200, "page /docs/{page} [index]"},
+		{"POST", "/forms/contact", 200, "contact /forms/contact []"},
+		{"GET", "/forms/contact", 200, "form /forms/{name} [contact]"},
+		{"PUT", "/forms/contact", 405, "Method Not Allowed\n"},
+		{"GET", "/nope", 404, "404 page not found\n"},
+	}
+
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, tt.method, tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestMuxTimeout(t *testing.T) {
+	deadline := func(w http.ResponseWriter, r *http.Request) {
+		d, ok := r.Context().Deadline()
+		if !ok {
+			w.Write([]byte("none"))
+			return
+		}
+		w.Write([]byte(strconv.Itoa(int((d.Sub(time.Now())+time.Second/2)/time.Second)) + "s"))
+	}
+
+	r := NewRouter()
+	r.Timeout(30 * time.Second).Get("/report", deadline)
+	r.Timeout(2 * time.Second).Get("/health", deadline)
+	r.Get("/users", deadline)
+	r.Group(func(r Router) {
+		g := r.(*Mux).Timeout(5 * time.Second)
+		g.Get("/search", deadline)
+		g.Timeout(10 * time.Second).Get("/export", deadline)
+	})
+	r.Timeout(time.Minute).Route("/admin", func(r Router) {
+		r.Get("/", deadline)
+		r.(*Mux).Timeout(3 * time.Second).Get("/stats", deadline)
+	})
+
+	tests := []struct {
+		path     string
+		deadline string
+	}{
+		{"/report", "30s"},
+		{"/health", "2s"},
+		{"/users", "none"},
+		{"/search", "5s"},
+		{"/export", "10s"},
+		{"/admin/", "60s"},
+		{"/admin/stats", "3s"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if body := w.Body.String(); body != tt.deadline {
+			t.Fatalf("%s: expecting the deadline in '%s', got '%s'", tt.path, tt.deadline, body)
+		}
+	}
+
+	// The handler sees the context canceled once the timeout elapses
+	r.Timeout(10 * time.Millisecond).Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			w.Write([]byte(r.Context().Err().Error()))
+		case <-time.After(time.Second):
+			w.Write([]byte("done"))
+		}
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	if body := w.Body.String(); body != context.DeadlineExceeded.Error() {
+		t.Fatalf("expecting '%s', got '%s'", context.DeadlineExceeded, body)
+	}
+}
+
+func TestMuxRouteFromCtx(t *testing.T) {
+	var route *Route
+	h := func(w http.ResponseWriter, r *http.Request) {
+		route = RouteFromCtx(r.Context())
+	}
+	postArticle := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RouteFromCtx(r.Context()) != nil {
+				t.Fatalf("expecting no route ahead of routing")
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/", h)
+	r.Route("/articles", func(r Router) {
+		r.Get("/{id}", h)
+		r.Post("/{id}", postArticle)
+		r.Get("/{id}/comments/{page=1}", h)
+	})
+	r.NotFound(h)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path    string
+		pattern string
+		methods []string
+	}{
+		{"/", "/", []string{"GET"}},
+		{"/articles/1", "/articles/{id}", []string{"GET", "POST"}},
+		{"/articles/1/comments", "/articles/{id}/comments/{page=1}", []string{"GET"}},
+		{"/nope", "", nil},
+	}
+
+	for _, tt := range tests {
+		route = nil
+		testRequest(t, ts, "GET", tt.path, nil)
+		if tt.methods == nil {
+			if route != nil {
+				t.Fatalf("%s: expecting no route, got %v", tt.path, route.Pattern)
+			}
+			continue
+		}
+		if route == nil || route.Pattern != tt.pattern {
+			t.Fatalf("%s: expecting route '%s', got %v", tt.path, tt.pattern, route)
+		}
+		var methods []string
+		for m := range route.Handlers {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		if !reflect.DeepEqual(methods, tt.methods) {
+			t.Fatalf("%s: expecting methods %v, got %v", tt.path, tt.methods, methods)
+		}
+	}
+}
+
+func TestMuxUseOnMatchAndMiss(t *testing.T) {
+	gate := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Gate", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	r := NewRouter()
+	r.Use(gate("all"))
+	r.UseOnMatch(gate("match"))
+	r.UseOnMiss(gate("miss"))
+	r.With(gate("inline")).Get("/articles", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("articles"))
+	})
+
+	if func() (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		r.UseOnMatch(gate("late"))
+		return
+	}() == false {
+		t.Fatalf("expecting UseOnMatch to panic after routes are defined")
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		gates  string
+	}{
+		{"GET", "/articles", 200, "all, match, inline"},
+		{"GET", "/nope", 404, "all, miss"},
+		{"POST", "/articles", 405, "all, miss"},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t, ts, tt.method, tt.path, nil)
+		if gates := strings.Join(resp.Header["X-Gate"], ", "); resp.StatusCode != tt.status || gates != tt.gates {
+			t.Fatalf("%s %s: expecting %d with gates '%s', got %d with '%s'", tt.method, tt.path, tt.status, tt.gates, resp.StatusCode, gates)
+		}
+	}
+}
+
+func TestMuxDisableTrace(t *testing.T) {
+	sub := NewRouter()
+	sub.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("sub"))
+	}))
+
+	r := NewRouter()
+	r.Handle("/any", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("any"))
+	}))
+	r.Method("TRACE", "/trace", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("trace"))
+	}))
+	r.Mount("/sub", sub)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "TRACE", "/any", nil); body != "any" {
+		t.Fatalf("expecting TRACE to be routed before DisableTrace, got '%s'", body)
+	}
+
+	for _, status := range []int{0, 403} {
+		r.DisableTrace(status)
+		want := status
+		if want == 0 {
+			want = 405
+		}
+		for _, path := range []string{"/any", "/trace", "/sub/x", "/nothing"} {
+			if resp, _ := testRequest(t, ts, "TRACE", path, nil); resp.StatusCode != want {
+				t.Fatalf("TRACE %s: expecting %d, got %d", path, want, resp.StatusCode)
+			}
+		}
+		if _, body := testRequest(t, ts, "GET", "/any", nil); body != "any" {
+			t.Fatalf("expecting GET to be routed, got '%s'", body)
+		}
+	}
+}
+
+func exportAuth(next http.Handler) http.Handler   { return next }
+func exportLogger(next http.Handler) http.Handler { return next }
+
+func TestMuxExportJSON(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	admin := NewRouter()
+	admin.Use(exportLogger)
+	admin.Get("/users/{id}", h)
+	admin.WithConfig(map[string]interface{}{"scope": "users:delete"}).Delete("/users/{id}", h)
+
+	r := NewRouter()
+	r.Use(exportLogger)
+	r.Get("/", h)
+	r.Handle("/ping", http.HandlerFunc(h))
+	r.WithConfig(map[string]interface{}{"cache": 60}).Get("/articles/{id}", h)
+	r.With(exportAuth).(*Mux).WithConfig(map[string]interface{}{"internal": true}).Mount("/admin", admin)
+
+	want, err := ioutil.ReadFile("testdata/routes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		if err := r.ExportJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(want) {
+			t.Fatalf("expecting the routes:\n%s\ngot:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMuxClone(t *testing.T) {
+	text := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(s))
+		}
+	}
+	header := func(v string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Mw", v)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	base := NewRouter()
+	base.Use(header("base"))
+	base.Get("/articles", text("articles"))
+	base.Accept("application/json").Get("/data", text("json"))
+
+	c := base.Clone()
+	c.Use(header("clone"))
+	c.Get("/users", text("users"))
+	c.Post("/articles", text("post articles"))
+	c.Accept("text/csv").Get("/data", text("csv"))
+	c.NotFound(text("clone 404"))
+
+	get := func(r http.Handler, method, path, accept string) (string, string) {
+		req := httptest.NewRequest(method, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String(), strings.Join(w.Header()["X-Mw"], ",")
+	}
+
+	tests := []struct {
+		r      http.Handler
+		method string
+		path   string
+		accept string
+		body   string
+		mws    string
+	}{
+		{base, "GET", "/articles", "", "articles", "base"},
+		{base, "GET", "/users", "", "404 page not found\n", "base"},
+		{base, "POST", "/articles", "", "Method Not Allowed\n", "base"},
+		{base, "GET", "/data", "text/csv", "Not Acceptable\n", "base"},
+		{c, "GET", "/articles", "", "articles", "base,clone"},
+		{c, "GET", "/users", "", "users", "base,clone"},
+		{c, "POST", "/articles", "", "post articles", "base,clone"},
+		{c, "GET", "/data", "text/csv", "csv", "base,clone"},
+		{c, "GET", "/data", "application/json", "json", "base,clone"},
+		{c, "GET", "/nothing", "", "clone 404", "base,clone"},
+	}
+	for i, tt := range tests {
+		if body, mws := get(tt.r, tt.method, tt.path, tt.accept); body != tt.body || mws != tt.mws {
+			t.Fatalf("[%d] %s %s: expecting '%s' with '%s', got '%s' with '%s'", i, tt.method, tt.path, tt.body, tt.mws, body, mws)
+		}
+	}
+	if len(base.Routes()) != 2 || len(c.Routes()) != 3 {
+		t.Fatalf("expecting 2 and 3 routes, got %d and %d", len(base.Routes()), len(c.Routes()))
+	}
+
+	// A clone of a mux with routes serves them without further changes
+	c2 := base.Clone()
+	if body, _ := get(c2, "GET", "/articles", ""); body != "articles" {
+		t.Fatal(body)
+	}
+
+	// The routes of PostJSON decode with the settings of the clone
+	decodeError := func(text string) func(w http.ResponseWriter, r *http.Request, err error) {
+		return func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(400)
+			w.Write([]byte(text))
+		}
+	}
+	j := NewMux(WithDecodeErrorHandler(decodeError("base error")))
+	j.PostJSON("/users", &struct{}{}, func(w http.ResponseWriter, r *http.Request, v interface{}) {})
+	jc := j.Clone()
+	jc.decodeErrorHandler = decodeError("clone error")
+	for _, tt := range []struct {
+		r    http.Handler
+		body string
+	}{
+		{j, "base error"},
+		{jc, "clone error"},
+	} {
+		if body, _ := get(tt.r, "POST", "/users", ""); body != tt.body {
+			t.Fatalf("expecting '%s', got '%s'", tt.body, body)
+		}
+	}
+}
+
+func TestMuxCloneWhileServing(t *testing.T) {
+	r := NewMux(WithInFlightTracking())
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	// Run with -race, the clone mustn't copy the state of the mux that is
+	// accessed atomically while serving
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/", nil)
+				r.ServeHTTP(w, req)
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		c := r.Clone()
+		if n := c.InFlight(); n != 0 {
+			t.Fatalf("expecting no request in flight on the clone, got %d", n)
+		}
+	}
+	wg.Wait()
+
+	c := r.Clone()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	c.ServeHTTP(w, req)
+	if body := w.Body.String(); body != "ok" {
+		t.Fatalf("expecting 'ok' from the clone, got '%s'", body)
+	}
+}
+
+func TestMuxPostJSON(t *testing.T) {
+	type createUser struct {
+		Name string `json:"name"`
+	}
+	handler := func(w http.ResponseWriter, r *http.Request, v interface{}) {
+		w.Write([]byte("created " + v.(*createUser).Name))
+	}
+
+	r := NewRouter()
+	r.PostJSON("/users", &createUser{}, handler)
+	r.With(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Inline", "yes")
+			next.ServeHTTP(w, r)
+		})
+	}).(*Mux).PostJSON("/admins", &createUser{}, handler)
+
+	tests := []struct {
+		path   string
+		ctype  string
+		body   string
+		status int
+		resp   string
+	}{
+		{"/users", "application/json", `{"name":"jane"}`, 200, "created jane"},
+		{"/users", "application/json; charset=utf-8", `{"name":"joe"}`, 200, "created joe"},
+		{"/users", "application/vnd.api+json", `{"name":"ann"}`, 200, "created ann"},
+		{"/users", "application/json", `{"name":`, 400, "Bad Request\n"},
+		{"/users", "application/json", ``, 400, "Bad Request\n"},
+		{"/users", "text/plain", `{"name":"jane"}`, 415, "Unsupported Media Type\n"},
+		{"/users", "", `{"name":"jane"}`, 415, "Unsupported Media Type\n"},
+		{"/admins", "application/json", `{"name":"root"}`, 200, "created root"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status || w.Body.String() != tt.resp {
+			t.Fatalf("%s %s: expecting %d '%s', got %d '%s'", tt.ctype, tt.body, tt.status, tt.resp, w.Code, w.Body.String())
+		}
+	}
+
+	// A custom decoder and error handler, inherited by the sub-routers
+	r = NewMux(
+		WithBodyDecoder(func(r *http.Request, v interface{}) error {
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) == "" {
+				return fmt.Errorf("empty body")
+			}
+			v.(*createUser).Name = strings.ToUpper(string(body))
+			return nil
+		}),
+		WithDecodeErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(422)
+			w.Write([]byte(err.Error()))
+		}),
+	)
+	r.Route("/v2", func(r Router) {
+		r.(*Mux).PostJSON("/users", &createUser{}, handler)
+	})
+	for body, want := range map[string]string{"jane": "created JANE", "": "empty body"} {
+		req := httptest.NewRequest("POST", "/v2/users", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Body.String() != want {
+			t.Fatalf("expecting '%s', got '%s'", want, w.Body.String())
+		}
+	}
+}
+
+func TestMuxSetErrorHandler(t *testing.T) {
+	r := NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > 4 {
+				Error(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.SetErrorHandler(404, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte("custom 404"))
+	})
+	r.Group(func(r Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Group", "yes")
+				next.ServeHTTP(w, r)
+			})
+		})
+		r.(*Mux).SetErrorHandler(413, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(413)
+			w.Write([]byte("custom 413"))
+		})
+	})
+	r.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("uploaded"))
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		resp   string
+		group  string
+	}{
+		{"POST", "/upload", "data", 200, "uploaded", ""},
+		{"POST", "/upload", "too much data", 413, "custom 413", "yes"},
+		{"POST", "/missing", "", 404, "custom 404", ""},
+		{"GET", "/upload", "", 405, "Method Not Allowed\n", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.status || w.Body.String() != tt.resp || w.Header().Get("X-Group") != tt.group {
+			t.Errorf("%s %s: expecting %d %q, got %d %q", tt.method, tt.path, tt.status, tt.resp, w.Code, w.Body.String())
+		}
+	}
+
+	if hFn := r.ErrorHandler(404); hFn == nil {
+		t.Fatalf("expecting the 404 handler")
+	}
+	w := httptest.NewRecorder()
+	r.ErrorHandler(500)(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 || w.Body.String() != "Internal Server Error\n" {
+		t.Fatalf("expecting the default 500 response, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestMuxSetErrorHandlerMounted(t *testing.T) {
+	errorText := func(text string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(418)
+			w.Write([]byte(text))
+		}
+	}
+	reject := func(w http.ResponseWriter, r *http.Request) {
+		Error(w, r, http.StatusForbidden)
+	}
+
+	// A mounted router responds with its own error handlers, or else the ones
+	// of its parent, including to the errors of the router itself
+	api := NewMux(WithMaxURLLength(16))
+	api.SetErrorHandler(403, errorText("api 403"))
+	api.Get("/private", reject)
+	api.PostJSON("/users", &struct{}{}, func(w http.ResponseWriter, r *http.Request, v interface{}) {})
+
+	r := NewRouter()
+	r.SetErrorHandler(403, errorText("root 403"))
+	r.Get("/private", reject)
+	r.Mount("/api", api)
+	r.SetErrorHandler(414, errorText("root 414"))
+	r.SetErrorHandler(415, errorText("root 415"))
+
+	tests := []struct {
+		method string
+		path   string
+		resp   string
+	}{
+		{"GET", "/private", "root 403"},
+		{"GET", "/api/private", "api 403"},
+		{"GET", "/api/private/too/long", "root 414"},
+		{"POST", "/api/users", "root 415"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != 418 || w.Body.String() != tt.resp {
+			t.Errorf("%s %s: expecting %q, got %d %q", tt.method, tt.path, tt.resp, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMuxUseFor(t *testing.T) {
+	gate := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Gate", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	reports := NewRouter()
+	reports.UseFor("/daily", gate("daily"))
+	reports.Get("/daily", h)
+
+	r := NewRouter()
+	r.UseOnMatch(gate("match"))
+	r.UseFor("/admin/*", gate("auth"))
+	r.UseFor("/*/x", gate("x"))
+	r.UseFor("/reports/*", gate("reports"))
+	r.Get("/admin/x", h)
+	r.Get("/admin/users/{id}", h)
+	r.Get("/public/x", h)
+	r.Get("/public/y", h)
+	r.Mount("/reports", reports)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path  string
+		gates string
+	}{
+		{"/admin/x", "match, auth, x"},
+		{"/admin/users/1", "match, auth"},
+		{"/public/x", "match, x"},
+		{"/public/y", "match"},
+		{"/reports/daily", "match, reports, daily"},
+		{"/nope", ""},
+	}
+
+	for _, tt := range tests {
+		resp, _ := testRequest(t, ts, "GET", tt.path, nil)
+		if gates := strings.Join(resp.Header["X-Gate"], ", "); gates != tt.gates {
+			t.Fatalf("%s: expecting gates '%s', got '%s'", tt.path, tt.gates, gates)
+		}
+	}
+
+	// The routes of a clone are chained with its own middlewares
+	c := r.Clone()
+	c.UseFor("/public/*", gate("public"))
+	w := httptest.NewRecorder()
+	c.ServeHTTP(w, httptest.NewRequest("GET", "/public/y", nil))
+	if gates := strings.Join(w.Header()["X-Gate"], ", "); gates != "match, public" {
+		t.Fatalf("clone: expecting gates 'match, public', got '%s'", gates)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/public/y", nil))
+	if gates := strings.Join(w.Header()["X-Gate"], ", "); gates != "match" {
+		t.Fatalf("expecting gates 'match', got '%s'", gates)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting UseFor to panic on an invalid glob")
+		}
+	}()
+	NewRouter().UseFor("/[a", gate("invalid"))
+}
+
+func TestMuxUseRouteRewriter(t *testing.T) {
+	variant := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}
+	}
+	b := variant("article b")
+
+	r := NewRouter()
+	r.UseRouteRewriter(func(rctx *Context, current http.Handler) http.Handler {
+		if rctx.RoutePattern() != "/articles/{id}" {
+			return current
+		}
+		if c, err := rctx.Request().Cookie("variant"); err == nil && c.Value == "b" {
+			return b
+		}
+		return current
+	})
+	r.Get("/articles/{id}", variant("article a"))
+	r.Get("/users/{id}", variant("user"))
+
+	tests := []struct {
+		path   string
+		cookie string
+		body   string
+	}{
+		{"/articles/1", "", "article a"},
+		{"/articles/1", "a", "article a"},
+		{"/articles/1", "b", "article b"},
+		{"/users/1", "b", "user"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "variant", Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if body := w.Body.String(); body != tt.body {
+			t.Fatalf("%s with variant '%s': expecting '%s', got '%s'", tt.path, tt.cookie, tt.body, body)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expecting UseRouteRewriter to panic after routes")
+		}
+	}()
+	r.UseRouteRewriter(func(rctx *Context, current http.Handler) http.Handler { return current })
+}
+
+func TestMuxInlineChainPerRoute(t *testing.T) {
+	var built int
+	mw := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			built++
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(name + " "))
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}
+	}
+
+	r := NewRouter()
+	g := r.With(mw("a"), mw("b"))
+	g.Get("/one", h("one"))
+	g.Get("/two", h("two"))
+	g.With(mw("c")).Get("/three", h("three"))
+	g.Post("/one", h("post one"))
+	r.Get("/plain", h("plain"))
+
+	// Each route wraps its own handler with the inline middlewares
+	if built != 9 {
+		t.Fatalf("expecting 9 middleware handlers to be built, got %d", built)
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/one", "a b one"},
+		{"GET", "/two", "a b two"},
+		{"GET", "/three", "a b c three"},
+		{"POST", "/one", "a b post one"},
+		{"GET", "/plain", "plain"},
+	}
+
+	for _, tt := range tests {
+		if _, body := testRequest(t, ts, tt.method, tt.path, nil); body != tt.body {
+			t.Fatalf("%s %s: expecting '%s', got '%s'", tt.method, tt.path, tt.body, body)
+		}
+	}
+}
+
+func TestMuxDebugMatch(t *testing.T) {
+	var matchErr *MatchError
+	record := func(w http.ResponseWriter, r *http.Request) {
+		matchErr = RouteContext(r.Context()).MatchError()
+		w.WriteHeader(404)
+	}
+	h := func(w http.ResponseWriter, r *http.Request) {
+		matchErr = RouteContext(r.Context()).MatchError()
+	}
+
+	r := NewMux(WithDebugMatch())
+	r.NotFound(record)
+	r.MethodNotAllowed(record)
+	r.Get("/articles/{id}", h)
+	r.Post("/articles", h)
+	r.RequireHeader("X-Beta", "1").Get("/preview", h)
+	r.Route("/admin", func(r Router) {
+		r.Get("/users", h)
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		reason  MatchReason
+		rpath   string
+		prefix  string
+		pattern string
+	}{
+		{"GET", "/nope", MatchNoRoute, "/nope", "", ""},
+		{"GET", "/articles/1/comments", MatchPartialPath, "/articles/1/comments", "/articles/1", "/articles/{id}"},
+		{"DELETE", "/articles", MatchWrongMethod, "/articles", "", "/articles"},
+		{"FOO", "/articles", MatchWrongMethod, "/articles", "", ""},
+		{"GET", "/preview", MatchWrongHeaders, "/preview", "", "/preview"},
+		{"GET", "/admin/users/1", MatchPartialPath, "/users/1", "/users", "/users"},
+	}
+
+	for _, tt := range tests {
+		matchErr = nil
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+		if matchErr == nil {
+			t.Fatalf("%s %s: expecting a match error", tt.method, tt.path)
+		}
+		want := MatchError{tt.reason, tt.method, tt.rpath, tt.prefix, tt.pattern}
+		if *matchErr != want {
+			t.Fatalf("%s %s: expecting %+v, got %+v", tt.method, tt.path, want, *matchErr)
+		}
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/articles/1/comments", nil))
+	want := "chi: the route '/articles/{id}' matches '/articles/1' but not the rest of GET /articles/1/comments"
+	if matchErr.Error() != want {
+		t.Fatalf("expecting the error '%s', got '%s'", want, matchErr.Error())
+	}
+
+	// A routed request, or a router without the option, has no match error
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/articles/1", nil))
+	if matchErr != nil {
+		t.Fatalf("expecting no match error for a routed request, got %v", matchErr)
+	}
+	r2 := NewRouter()
+	r2.NotFound(record)
+	r2.Get("/", h)
+	matchErr = &MatchError{}
+	r2.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+	if matchErr != nil {
+		t.Fatalf("expecting no match error without WithDebugMatch, got %v", matchErr)
+	}
+}
+
+func timingSleep(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(d)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestMuxMiddlewareTiming(t *testing.T) {
+	var timings []MiddlewareTiming
+	report := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			timings = append([]MiddlewareTiming(nil), RouteContext(r.Context()).MiddlewareTimings()...)
+		})
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}
+
+	r := NewMux(WithMiddlewareTiming())
+	r.Use(report)
+	r.Use(timingSleep(10 * time.Millisecond))
+	r.With(timingSleep(20 * time.Millisecond)).Get("/", handler)
+	r.Route("/sub", func(r Router) {
+		r.Use(timingSleep(20 * time.Millisecond))
+		r.Get("/", handler)
+	})
+
+	for _, path := range []string{"/", "/sub/"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Body.String() != "ok" {
+			t.Fatalf("%s: expecting 'ok', got '%s'", path, w.Body.String())
+		}
+
+		if len(timings) != 3 {
+			t.Fatalf("%s: expecting 3 middleware timings, got %v", path, timings)
+		}
+		if !strings.HasPrefix(timings[0].Name, "github.com/go-chi/chi.TestMuxMiddlewareTiming.") {
+			t.Fatalf("%s: expecting the name of the report middleware, got '%s'", path, timings[0].Name)
+		}
+		for i, d := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+			timing := timings[i+1]
+			if !strings.HasPrefix(timing.Name, "github.com/go-chi/chi.timingSleep.") {
+				t.Fatalf("%s: expecting the name of the sleep middleware, got '%s'", path, timing.Name)
+			}
+			// The duration excludes the time spent in the next handlers
+			if timing.Duration < d || timing.Duration >= d+40*time.Millisecond {
+				t.Fatalf("%s: expecting a duration of %v in the middleware %d, got %v", path, d, i+1, timing.Duration)
+			}
+		}
+	}
+
+	// The timed chains of the matched and unmatched requests are built once
+	var chained int
+	count := func(next http.Handler) http.Handler {
+		chained++
+		return next
+	}
+	r = NewMux(WithMiddlewareTiming())
+	r.Use(report)
+	r.UseOnMatch(count)
+	r.UseOnMiss(count)
+	r.UseFor("/*", count)
+	r.Get("/", handler)
+	r.Get("/{id}", handler)
+	built := chained
+	for path, n := range map[string]int{"/": 3, "/1": 3, "/2": 3, "/a/b": 2, "/a/c": 2} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if len(timings) != n {
+			t.Fatalf("%s: expecting %d middleware timings, got %v", path, n, timings)
+		}
+	}
+	if chained != built {
+		t.Fatalf("expecting the chains to be built once, got %d builds for %d", chained, built)
+	}
+
+	// The timings aren't recorded by default
+	r = NewRouter()
+	r.Use(report)
+	r.Get("/", handler)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(timings) != 0 {
+		t.Fatalf("expecting no middleware timings, got %v", timings)
+	}
+}
+
+func TestMuxRequireHeader(t *testing.T) {
+	handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}
+	}
+
+	r := NewRouter()
+	r.RequireHeader("X-Beta", "1").Get("/search", handler("beta search"))
+	r.RequireHeader("X-Beta", "1").RequireHeader("x-region", "eu").Get("/search", handler("beta eu search"))
+	r.Get("/search", handler("search"))
+
+	r.Group(func(r Router) {
+		r = r.(*Mux).RequireHeader("X-Beta", "1")
+		r.Get("/preview", handler("preview"))
+		r.Get("/users/new", handler("new user"))
+	})
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user " + URLParam(r, "id")))
+	})
+
+	tests := []struct {
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"GET", "/search", nil, 200, "search"},
+		{"GET", "/search", map[string]string{"X-Beta": "1"}, 200, "beta search"},
+		{"GET", "/search", map[string]string{"X-Beta": "0"}, 200, "search"},
+		{"GET", "/preview", map[string]string{"X-Beta": "1"}, 200, "preview"},
+		{"GET", "/preview", nil, 404, "404 page not found\n"},
+		{"GET", "/users/new", map[string]string{"X-Beta": "1"}, 200, "new user"},
+		{"GET", "/users/new", nil, 200, "user new"},
+		{"POST", "/preview", nil, 405, "Method Not Allowed\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Fatalf("%s %s %v: expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.headers, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	// The routes requiring headers are found without a request, while the
+	// request headers are matched once there's a request
+	if !r.Match(NewRouteContext(), "GET", "/preview") {
+		t.Fatalf("expecting a match for the route requiring headers")
+	}
+	h, ok := r.Handler("GET", "/preview")
+	if !ok {
+		t.Fatalf("expecting a handler for the route requiring headers")
+	}
+	req := httptest.NewRequest("GET", "/users/new", nil)
+	if pattern, _ := r.LookupRoute(req); pattern != "/users/{id}" {
+		t.Fatalf("expecting the route '/users/{id}', got '%s'", pattern)
+	}
+	req.Header.Set("X-Beta", "1")
+	if pattern, _ := r.LookupRoute(req); pattern != "/users/new" {
+		t.Fatalf("expecting the route '/users/new', got '%s'", pattern)
+	}
+	for _, tt := range []struct {
+		beta   string
+		status int
+	}{
+		{"1", 200},
+		{"", 404},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/preview", nil)
+		if tt.beta != "" {
+			req.Header.Set("X-Beta", tt.beta)
+		}
+		h.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Fatalf("X-Beta '%s': expecting %d, got %d", tt.beta, tt.status, w.Code)
+		}
+	}
+
+	// The first handler registered with matching headers is selected
+	r = NewRouter()
+	r.RequireHeader("X-Beta", "1").RequireHeader("X-Region", "eu").Get("/search", handler("beta eu search"))
+	r.RequireHeader("X-Beta", "1").Get("/search", handler("beta search"))
+
+	for _, tt := range []struct {
+		region string
+		body   string
+	}{
+		{"eu", "beta eu search"},
+		{"us", "beta search"},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/search", nil)
+		req.Header.Set("X-Beta", "1")
+		req.Header.Set("X-Region", tt.region)
+		r.ServeHTTP(w, req)
+		if w.Body.String() != tt.body {
+			t.Fatalf("region %s: expecting '%s', got '%s'", tt.region, tt.body, w.Body.String())
+		}
+	}
+}
+
+func TestMuxLen(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	if r.Len() != 0 {
+		t.Fatalf("expecting no routes, got %d", r.Len())
+	}
+
+	const n = 50
+	for i := 0; i < n; i++ {
+		r.Get(fmt.Sprintf("/items/%d", i), h)
+	}
+	if r.Len() != n {
+		t.Fatalf("expecting %d routes, got %d", n, r.Len())
+	}
+
+	// A route or a mount of any method counts once
+	r.Post("/items/0", h)
+	r.HandleFunc("/ping", h)
+	r.Get("/users/{id}", h)
+	r.Group(func(r Router) {
+		r.Get("/admin", h)
+		if l := r.(*Mux).Len(); l != n+4 {
+			t.Fatalf("expecting %d routes from an inline mux, got %d", n+4, l)
+		}
+	})
+	r.Route("/articles", func(r Router) {
+		r.Get("/", h)
+		r.Get("/{id}", h)
+	})
+	r.Mount("/static", http.HandlerFunc(h))
+	if r.Len() != n+6 {
+		t.Fatalf("expecting %d routes, got %d", n+6, r.Len())
+	}
+
+	// Registering a route again replaces it
+	r.Get("/items/0", h)
+	r.HandleFunc("/ping", h)
+	r.Get("/users/{userID}", h)
+	if r.Len() != n+6 {
+		t.Fatalf("expecting %d routes after replacing routes, got %d", n+6, r.Len())
+	}
+
+	// Each call counts once, regardless of the order of registration
+	r1, r2 := NewRouter(), NewRouter()
+	r1.HandleFunc("/x", h)
+	r1.Get("/x", h)
+	r1.Mount("/api", http.HandlerFunc(h))
+	r2.Mount("/api", http.HandlerFunc(h))
+	r2.Get("/x", h)
+	r2.HandleFunc("/x", h)
+	if r1.Len() != 3 || r2.Len() != 3 {
+		t.Fatalf("expecting 3 routes either way, got %d and %d", r1.Len(), r2.Len())
+	}
+	r3 := NewRouter()
+	r3.AnyExcept([]string{"GET"}, "/x", h)
+	r3.Get("/x", h)
+	if r3.Len() != 2 {
+		t.Fatalf("expecting 2 routes, got %d", r3.Len())
+	}
+
+	// The clone counts its own routes
+	c := r1.Clone()
+	c.Get("/x", h)
+	c.Get("/y", h)
+	if c.Len() != 4 || r1.Len() != 3 {
+		t.Fatalf("expecting 4 routes on the clone and 3 on the mux, got %d and %d", c.Len(), r1.Len())
+	}
+}
+
+func TestMuxValidate(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/users/{id}", h)
+	r.Get("/users/new", h)
+	r.Post("/users/admin", h)
+	r.Get("/items/{id:[0-9]+}", h)
+	r.Get("/items/{code:[0-9]{3}}", h)
+	r.Get("/items/{slug:[a-z-]+}", h)
+	r.Get("/files/*", h)
+	r.Get("/files/{name}.{ext}", h)
+	r.Route("/api", func(r Router) {
+		r.Handle("/{version}/status", http.HandlerFunc(h))
+	})
+	r.Put("/api/v1/status", h)
+
+	tests := []struct {
+		patterns   [2]string
+		methods    string
+		path       string
+		precedence bool
+	}{
+		{[2]string{"/api/v1/status", "/api/{version}/status"}, "PUT", "/api/v1/status", true},
+		{[2]string{"/files/*", "/files/{name}.{ext}"}, "GET", "/files/1.1", true},
+		{[2]string{"/items/{code:[0-9]{3}}", "/items/{id:[0-9]+}"}, "GET", "/items/000", false},
+		{[2]string{"/users/new", "/users/{id}"}, "GET", "/users/new", true},
+	}
+
+	conflicts := r.Validate()
+	if len(conflicts) != len(tests) {
+		t.Fatalf("expecting %d conflicts, got %v", len(tests), conflicts)
+	}
+	for i, tt := range tests {
+		c := conflicts[i]
+		if c.Patterns != tt.patterns || strings.Join(c.Methods, ",") != tt.methods || c.Path != tt.path {
+			t.Fatalf("expecting the conflict of %v for %s %s, got %v", tt.patterns, tt.methods, tt.path, c)
+		}
+		if c.Precedence != tt.precedence {
+			t.Fatalf("%v: expecting precedence %v, got %v", tt.patterns, tt.precedence, c.Precedence)
+		}
+	}
+
+	want := "'/users/new' and '/users/{id}' both match GET /users/new"
+	if s := conflicts[3].String(); s != want {
+		t.Fatalf("expecting '%s', got '%s'", want, s)
+	}
+
+	r2 := NewRouter()
+	r2.Get("/users/{id}", h)
+	r2.Post("/users/new", h)
+	r2.Get("/users/{id}/posts", h)
+	if conflicts := r2.Validate(); len(conflicts) != 0 {
+		t.Fatalf("expecting no conflicts, got %v", conflicts)
+	}
+}
+
+func BenchmarkMuxValidate(b *testing.B) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+	r := NewRouter()
+	for i := 0; i < 100; i++ {
+		r.Get(fmt.Sprintf("/resource%d", i), h)
+		r.Get(fmt.Sprintf("/resource%d/{id}", i), h)
+		r.Put(fmt.Sprintf("/resource%d/{id}", i), h)
+		r.Get(fmt.Sprintf("/resource%d/{id}/items/{item:[0-9]+}", i), h)
+		r.Get(fmt.Sprintf("/resource%d/new", i), h)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Validate()
+	}
+}
+
+func TestMuxIndex(t *testing.T) {
+	handler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}
+	}
+
+	r := NewRouter()
+	r.Get("/static/*", handler("static"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 404 {
+		t.Fatalf("expecting a 404 without an index, got %d", w.Code)
+	}
+
+	r.Index(handler("index"))
+
+	// A router mounted along "/" doesn't shadow the index
+	sub := NewRouter()
+	sub.Get("/", handler("sub index"))
+	sub.Get("/about", handler("about"))
+	sub.Post("/", handler("sub post"))
+	r.Mount("/", sub)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{"GET", "/", 200, "index"},
+		{"HEAD", "/", 200, ""},
+		{"GET", "", 200, "index"},
+		{"GET", "/static/", 200, "static"},
+		{"GET", "/static/app.js", 200, "static"},
+		{"GET", "/about", 200, "about"},
+		{"POST", "/", 200, "sub post"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.URL.Path = tt.path
+		r.ServeHTTP(w, req)
+		body := w.Body.String()
+		if tt.method == "HEAD" {
+			body = ""
+		}
+		if w.Code != tt.status || body != tt.body {
+			t.Fatalf("%s '%s': expecting %d '%s', got %d '%s'", tt.method, tt.path, tt.status, tt.body, w.Code, body)
+		}
+	}
+
+	// The index and a wildcard of the root path
+	r = NewRouter()
+	r.Handle("/*", handler("catch-all"))
+	r.Index(handler("index"))
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	if _, body := testRequest(t, ts, "GET", "/", nil); body != "index" {
+		t.Fatalf("expecting the index, got '%s'", body)
+	}
+	if _, body := testRequest(t, ts, "HEAD", "/", nil); body != "" {
+		t.Fatalf("expecting no body for HEAD, got '%s'", body)
+	}
+	if _, body := testRequest(t, ts, "GET", "/anything", nil); body != "catch-all" {
+		t.Fatalf("expecting the catch-all, got '%s'", body)
+	}
+	if _, body := testRequest(t, ts, "DELETE", "/", nil); body != "catch-all" {
+		t.Fatalf("expecting the catch-all for another method, got '%s'", body)
+	}
+}
+
+func TestMuxRedirects(t *testing.T) {
+	r := NewRouter()
+	r.Redirects(map[string]string{
+		"/blog":             "/articles",
+		"/old/{id}":         "/new/{id}",
+		"/users/{id}/posts": "/posts?author={id}",
+		"/docs/v1/*":        "https://docs.example.com/*",
+		"/legacy/*":         "/*",
+		"/cdn/*":            "//cdn.example.com/*",
+	}, http.StatusMovedPermanently)
+	r.Get("/new/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/blog", 301, "/articles"},
+		{"/old/42", 301, "/new/42"},
+		{"/old/a%3Fb", 301, "/new/a%3Fb"},
+		{"/old/a%23b", 301, "/new/a%23b"},
+		{"/old/a%2Fb", 301, "/new/a%2Fb"},
+		{"/old/caf%C3%A9", 301, "/new/caf%C3%A9"},
+		{"/users/7/posts", 301, "/posts?author=7"},
+		{"/users/7%3Fx/posts", 301, "/posts?author=7%3Fx"},
+		{"/docs/v1/guide/intro", 301, "https://docs.example.com/guide/intro"},
+		{"/legacy/about", 301, "/about"},
+		{"/legacy//evil.com", 301, "/evil.com"},
+		{"/legacy/%5Cevil.com", 301, "/evil.com"},
+		{"/legacy/%5C%5Cevil.com/x", 301, "/evil.com/x"},
+		{"/cdn/app.js", 301, "//cdn.example.com/app.js"},
+		{"/new/42", 200, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if loc := w.Header().Get("Location"); w.Code != tt.status || loc != tt.location {
+			t.Fatalf("%s: expecting %d to '%s', got %d to '%s'", tt.path, tt.status, tt.location, w.Code, loc)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/blog", nil))
+	if w.Code != 405 {
+		t.Fatalf("expecting a 405 for a POST, got %d", w.Code)
+	}
+
+	invalid := []struct {
+		redirects map[string]string
+		status    int
+	}{
+		{map[string]string{"/a": "/b"}, 200},
+		{map[string]string{"/a/{id}": "/b/{name}"}, 301},
+		{map[string]string{"/a/{id}": "/b/{id"}, 301},
+	}
+	for _, tt := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expecting a panic on %v with status %d", tt.redirects, tt.status)
+				}
+			}()
+			NewRouter().Redirects(tt.redirects, tt.status)
+		}()
+	}
+}
+
+func TestMuxURLParamInt(t *testing.T) {
+	report := func(w http.ResponseWriter, r *http.Request) {
+		var out []string
+		for _, key := range []string{"org", "repo", "id", "page"} {
+			if v, ok := URLParamInt(r, key); ok {
+				out = append(out, fmt.Sprintf("%s=%d", key, v))
+			} else if URLParam(r, key) != "" {
+				out = append(out, key+"=invalid")
+			}
+		}
+		out = append(out, fmt.Sprintf("parsed=%d", len(RouteContext(r.Context()).URLParams.ints)))
+		w.Write([]byte(strings.Join(out, " ")))
+	}
+
+	repos := NewRouter()
+	repos.Get("/repos/{repo:int}", report)
+
+	r := NewRouter()
+	r.Get("/users/{id:int}", report)
+	r.Get("/users/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("me"))
+	})
+	r.Get("/pages/{page}", report)
+	r.Mount("/orgs/{org:int}", repos)
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/users/42", 200, "id=42 parsed=1"},
+		{"/users/me", 200, "me"},
+		{"/users/-1", 404, "404 page not found\n"},
+		{"/users/99999999999999999999999", 200, "id=invalid parsed=0"},
+		{"/pages/7", 200, "page=7 parsed=0"},
+		{"/pages/last", 200, "page=invalid parsed=0"},
+		{"/orgs/3/repos/14", 200, "org=3 repo=14 parsed=2"},
+		{"/orgs/acme/repos/14", 404, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		resp, body := testRequest(t, ts, "GET", tt.path, nil)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Fatalf("%s: expecting %d '%s', got %d '%s'", tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+	}
+
+	// The parsed values follow the params of the handler of a route
+	rctx := NewRouteContext()
+	h, ok := r.Handler("GET", "/users/5")
+	if !ok {
+		t.Fatalf("expecting a handler")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/users/5", nil))
+	if w.Body.String() != "id=5 parsed=1" {
+		t.Fatalf("expecting the parsed id, got '%s'", w.Body.String())
+	}
+	if _, ok := rctx.URLParamInt("id"); ok {
+		t.Fatalf("expecting no param in an empty context")
 	}
 }
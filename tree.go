@@ -6,12 +6,13 @@ package chi
 
 import (
 	"fmt"
-	"math"
 	"net/http"
 	"regexp"
+	"regexp/syntax"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type methodTyp int
@@ -32,6 +33,10 @@ const (
 var mALL = mCONNECT | mDELETE | mGET | mHEAD |
 	mOPTIONS | mPATCH | mPOST | mPUT | mTRACE
 
+// mANY is the set of common http methods matched by Router#Any, which
+// excludes CONNECT, TRACE and custom methods.
+var mANY = mDELETE | mGET | mHEAD | mOPTIONS | mPATCH | mPOST | mPUT
+
 var methodMap = map[string]methodTyp{
 	http.MethodConnect: mCONNECT,
 	http.MethodDelete:  mDELETE,
@@ -45,24 +50,47 @@ var methodMap = map[string]methodTyp{
 }
 
 // RegisterMethod adds support for custom HTTP method handlers, available
-// via Router#Method and Router#MethodFunc
+// via Router#Method and Router#MethodFunc. It panics if the `method` isn't a
+// valid token, see ValidMethod.
 func RegisterMethod(method string) {
 	if method == "" {
 		return
 	}
+	if !ValidMethod(method) {
+		panic(fmt.Sprintf("chi: invalid http method %q", method))
+	}
 	method = strings.ToUpper(method)
 	if _, ok := methodMap[method]; ok {
 		return
 	}
 	n := len(methodMap)
-	if n > strconv.IntSize {
+	if n > strconv.IntSize-2 {
 		panic(fmt.Sprintf("chi: max number of methods reached (%d)", strconv.IntSize))
 	}
-	mt := methodTyp(math.Exp2(float64(n)))
+	mt := methodTyp(2 << uint(n))
 	methodMap[method] = mt
 	mALL |= mt
 }
 
+// ValidMethod reports whether the `method` is a valid http method, that is a
+// token of RFC 7230, made of letters, digits and the characters of
+// "!#$%&'*+-.^_`|~" only.
+func ValidMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		c := method[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 type nodeTyp uint8
 
 const (
@@ -94,9 +122,16 @@ type node struct {
 	// subroutes on the leaf node
 	subroutes Routes
 
+	// http methods of each route registered along the leaf node, see Mux#Len
+	registered []methodTyp
+
 	// child nodes should be stored in-order for iteration,
 	// in groups of the node type.
 	children [ntCatchAll + 1]nodes
+
+	// prioritized is set on the root node once an endpoint has a priority,
+	// so that FindRoute selects the matching route of highest priority
+	prioritized bool
 }
 
 // endpoints is a mapping of http method constants to handlers
@@ -107,11 +142,270 @@ type endpoint struct {
 	// endpoint handler
 	handler http.Handler
 
+	// handler served through the UseOnMatch and UseFor middlewares of the
+	// mux, see Mux#chainEndpoints
+	chain http.Handler
+
 	// pattern is the routing pattern for handler nodes
 	pattern string
 
 	// parameter keys recorded on handler nodes
 	paramKeys []string
+
+	// indexes of the parameter keys with the int type hint, see patIntParams
+	paramInts []int
+
+	// parameter values of the trailing param defaults, for the endpoint
+	// routed without the param segment, see patDefaultParam, or of the
+	// empty wildcard, see Mux#WithEmptyWildcard
+	paramDefaults []string
+
+	// matching priority among the routes matching a path, see Mux#Priority
+	priority int
+
+	// request context timeout, see Mux#Timeout
+	timeout time.Duration
+
+	// route configuration key/values, see Mux#WithConfig
+	config map[string]interface{}
+
+	// handlers negotiated by the Accept request header, in order of
+	// registration, see Mux#Accept
+	accepts []*acceptHandler
+
+	// handler registered without media types along with negotiated handlers
+	fallback http.Handler
+
+	// handlers matching the request headers, in order of registration, see
+	// Mux#RequireHeader
+	headers []*headerHandler
+
+	// handler registered without headers along with header handlers
+	headerFallback http.Handler
+}
+
+// headerHandler is a handler of a route matching only the requests with the
+// header values, which is selected by the endpoint.
+type headerHandler struct {
+	headers []headerMatch
+	handler http.Handler
+}
+
+func (h *headerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// headerMatch is a request header value required by a route.
+type headerMatch struct {
+	key, value string
+}
+
+func (h *headerHandler) match(header http.Header) bool {
+	for _, hm := range h.headers {
+		if header.Get(hm.key) != hm.value {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptHandler is a handler serving the media types of a route, which is
+// negotiated by the endpoint with the Accept request header.
+type acceptHandler struct {
+	mediaTypes []string
+	handler    http.Handler
+}
+
+func (h *acceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// setHandler sets the endpoint handler. An acceptHandler is added to the
+// handlers negotiated by the endpoint, in which case any other handler is
+// served as a fallback when no media type is acceptable. Likewise, a
+// headerHandler is added to the handlers selected by the request headers, in
+// which case the other handlers are served when no header handler matches.
+func (e *endpoint) setHandler(handler http.Handler) {
+	if hh, ok := handler.(*headerHandler); ok {
+		if e.headers == nil {
+			e.headerFallback = e.handler
+			e.handler = http.HandlerFunc(e.serveHeaders)
+		}
+		e.headers = append(e.headers, hh)
+		return
+	}
+	if e.headers != nil {
+		// Set the handler served when no header handler matches
+		e.handler = e.headerFallback
+		defer func() {
+			e.headerFallback = e.handler
+			e.handler = http.HandlerFunc(e.serveHeaders)
+		}()
+	}
+
+	ah, ok := handler.(*acceptHandler)
+	if !ok {
+		if e.accepts != nil {
+			e.fallback = handler
+		} else {
+			e.handler = handler
+		}
+		return
+	}
+
+	if e.accepts == nil {
+		e.fallback = e.handler
+		e.handler = http.HandlerFunc(e.negotiate)
+	}
+	e.accepts = append(e.accepts, ah)
+}
+
+// matchHeaders reports whether the endpoint serves a request with the `header`,
+// having a handler registered without headers, or a header handler matching.
+// Without any `header`, ie. to match a path without a request, the required
+// headers are ignored.
+func (e *endpoint) matchHeaders(header http.Header) bool {
+	if e.headers == nil || e.headerFallback != nil || header == nil {
+		return true
+	}
+	for _, hh := range e.headers {
+		if hh.match(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveHeaders serves the first header handler matching the request headers,
+// or else the handler registered without headers.
+func (e *endpoint) serveHeaders(w http.ResponseWriter, r *http.Request) {
+	for _, hh := range e.headers {
+		if hh.match(r.Header) {
+			hh.ServeHTTP(w, r)
+			return
+		}
+	}
+	if e.headerFallback != nil {
+		e.headerFallback.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// negotiate serves the handler of the media type that is most acceptable as
+// per the Accept request header, with a preference for the handler registered
+// first in case of a tie. The fallback handler serves requests accepting none
+// of the media types, otherwise they're responded with a 406.
+func (e *endpoint) negotiate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		if e.fallback != nil {
+			e.fallback.ServeHTTP(w, r)
+		} else {
+			e.accepts[0].ServeHTTP(w, r)
+		}
+		return
+	}
+
+	ranges := parseAccept(accept)
+	var best http.Handler
+	var bestQ float64
+	for _, ah := range e.accepts {
+		for _, mt := range ah.mediaTypes {
+			if q := acceptQuality(ranges, mt); q > bestQ {
+				best, bestQ = ah, q
+			}
+		}
+	}
+
+	switch {
+	case best != nil:
+		best.ServeHTTP(w, r)
+	case e.fallback != nil:
+		e.fallback.ServeHTTP(w, r)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+	}
+}
+
+// acceptRange is a media range of the Accept request header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of an Accept request header, ignoring
+// any parameter other than the quality factor.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		ar := acceptRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if ar.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality factor of the media type `mt`, as per the
+// most specific of the media `ranges` matching it, or 0 if none matches.
+func acceptQuality(ranges []acceptRange, mt string) float64 {
+	mt = strings.ToLower(mt)
+	typ := mt
+	if i := strings.IndexByte(mt, '/'); i >= 0 {
+		typ = mt[:i]
+	}
+
+	var q float64
+	specificity := -1
+	for _, ar := range ranges {
+		var s int
+		switch {
+		case ar.mediaType == mt:
+			s = 2
+		case ar.mediaType == typ+"/*":
+			s = 1
+		case ar.mediaType == "*/*" || ar.mediaType == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = ar.q, s
+		}
+	}
+	return q
+}
+
+// tree returns a routing tree of the endpoint alone, as the handler of the
+// `method`, to match a path against its route without searching the routing
+// tree it belongs to, see Mux#ResolveChain.
+func (e *endpoint) tree(method methodTyp) *node {
+	path := e.pattern
+	if e.paramDefaults != nil {
+		// The endpoint of a param default or an empty wildcard is routed
+		// without the last segment of its pattern
+		if p, _, ok := patDefaultParam(e.pattern); ok {
+			path = p
+		} else {
+			path = strings.TrimSuffix(e.pattern, "/*")
+		}
+	}
+	t := &node{}
+	t.insertRoute(method, path, e.pattern, e.handler).endpoints[method] = e
+	return t
 }
 
 func (s endpoints) Value(method methodTyp) *endpoint {
@@ -124,8 +418,16 @@ func (s endpoints) Value(method methodTyp) *endpoint {
 }
 
 func (n *node) InsertRoute(method methodTyp, pattern string, handler http.Handler) *node {
+	patValidate(pattern)
+	return n.insertRoute(method, pattern, pattern, handler)
+}
+
+// insertRoute inserts the route of the `pattern` along the `path`, which
+// differs from the pattern for the path of a param default, see
+// patDefaultParam.
+func (n *node) insertRoute(method methodTyp, path, pattern string, handler http.Handler) *node {
 	var parent *node
-	search := pattern
+	search := path
 
 	for {
 		// Handle key exhaustion
@@ -252,14 +554,9 @@ func (n *node) addChild(child *node, prefix string) *node {
 			// Route starts with a param
 			child.typ = segTyp
 
-			if segTyp == ntCatchAll {
-				segStartIdx = -1
-			} else {
-				segStartIdx = segEndIdx
-			}
-			if segStartIdx < 0 {
-				segStartIdx = len(search)
-			}
+			// for a catch-all, the end index is the end of the pattern unless
+			// the wildcard is followed by more segments
+			segStartIdx = segEndIdx
 			child.tail = segTail // for params, we set the tail
 
 			if segStartIdx != len(search) {
@@ -298,8 +595,12 @@ func (n *node) addChild(child *node, prefix string) *node {
 		}
 	}
 
-	n.children[child.typ] = append(n.children[child.typ], child)
-	n.children[child.typ].Sort()
+	if child.typ == ntStatic {
+		n.children[ntStatic] = n.children[ntStatic].insertSorted(child)
+	} else {
+		n.children[child.typ] = append(n.children[child.typ], child)
+		n.children[child.typ].Sort()
+	}
 	return hn
 }
 
@@ -317,6 +618,13 @@ func (n *node) replaceChild(label, tail byte, child *node) {
 
 func (n *node) getEdge(ntyp nodeTyp, label, tail byte, prefix string) *node {
 	nds := n.children[ntyp]
+	if ntyp == ntStatic {
+		// Static edges have distinct labels, and are kept sorted
+		if len(nds) == 0 {
+			return nil
+		}
+		return nds.findEdge(label)
+	}
 	for i := 0; i < len(nds); i++ {
 		if nds[i].label == label && nds[i].tail == tail {
 			if ntyp == ntRegexp && nds[i].prefix != prefix {
@@ -335,38 +643,136 @@ func (n *node) setEndpoint(method methodTyp, handler http.Handler, pattern strin
 	}
 
 	paramKeys := patParamKeys(pattern)
+	paramInts := patIntParams(pattern)
 
 	if method&mSTUB == mSTUB {
 		n.endpoints.Value(mSTUB).handler = handler
 	}
 	if method&mALL == mALL {
 		h := n.endpoints.Value(mALL)
-		h.handler = handler
+		h.setHandler(handler)
 		h.pattern = pattern
 		h.paramKeys = paramKeys
+		h.paramInts = paramInts
+		h.paramDefaults = nil
 		for _, m := range methodMap {
 			h := n.endpoints.Value(m)
-			h.handler = handler
+			h.setHandler(handler)
 			h.pattern = pattern
 			h.paramKeys = paramKeys
+			h.paramInts = paramInts
+			h.paramDefaults = nil
 		}
 	} else {
-		h := n.endpoints.Value(method)
-		h.handler = handler
-		h.pattern = pattern
-		h.paramKeys = paramKeys
+		for _, m := range methodMap {
+			if method&m != m {
+				continue
+			}
+			h := n.endpoints.Value(m)
+			h.setHandler(handler)
+			h.pattern = pattern
+			h.paramKeys = paramKeys
+			h.paramInts = paramInts
+			h.paramDefaults = nil
+		}
+	}
+}
+
+// setEndpointDefaults sets the param default values for the method type on
+// the node, in the same manner as setEndpoint.
+func (n *node) setEndpointDefaults(method methodTyp, values []string) {
+	if method&mALL == mALL {
+		n.endpoints.Value(mALL).paramDefaults = values
+		for _, m := range methodMap {
+			n.endpoints.Value(m).paramDefaults = values
+		}
+	} else {
+		for _, m := range methodMap {
+			if method&m == m {
+				n.endpoints.Value(m).paramDefaults = values
+			}
+		}
+	}
+}
+
+// setEndpointPriority sets the matching priority for the method type on the
+// node, in the same manner as setEndpoint.
+func (n *node) setEndpointPriority(method methodTyp, priority int) {
+	if method&mALL == mALL {
+		n.endpoints.Value(mALL).priority = priority
+		for _, m := range methodMap {
+			n.endpoints.Value(m).priority = priority
+		}
+	} else {
+		for _, m := range methodMap {
+			if method&m == m {
+				n.endpoints.Value(m).priority = priority
+			}
+		}
+	}
+}
+
+// setEndpointTimeout sets the request context timeout for the method type on
+// the node, in the same manner as setEndpoint.
+func (n *node) setEndpointTimeout(method methodTyp, timeout time.Duration) {
+	if method&mALL == mALL {
+		n.endpoints.Value(mALL).timeout = timeout
+		for _, m := range methodMap {
+			n.endpoints.Value(m).timeout = timeout
+		}
+	} else {
+		for _, m := range methodMap {
+			if method&m == m {
+				n.endpoints.Value(m).timeout = timeout
+			}
+		}
 	}
 }
 
+// setEndpointConfig sets the route configuration for the method type on the
+// node, in the same manner as setEndpoint.
+func (n *node) setEndpointConfig(method methodTyp, config map[string]interface{}) {
+	if method&mALL == mALL {
+		n.endpoints.Value(mALL).config = config
+		for _, m := range methodMap {
+			n.endpoints.Value(m).config = config
+		}
+	} else {
+		for _, m := range methodMap {
+			if method&m == m {
+				n.endpoints.Value(m).config = config
+			}
+		}
+	}
+}
+
+// FindRoute searches the tree for the handler of the method/path. If the path
+// matches a route that has no handler for the method, the route's node and
+// endpoints are returned along with a nil handler, so callers can tell a 405
+// apart from a 404.
 func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, endpoints, http.Handler) {
-	// Reset the context routing pattern and params
+	// Reset the context routing pattern, params and method not allowed hint
 	rctx.routePattern = ""
 	rctx.routeParams.Keys = rctx.routeParams.Keys[:0]
 	rctx.routeParams.Values = rctx.routeParams.Values[:0]
+	rctx.methodNotAllowed = false
+	rctx.methodNotAllowedNode = nil
+	rctx.routeNode = nil
+	rctx.route = nil
+	rctx.matchNode = nil
+	rctx.matchRest = ""
 
 	// Find the routing handlers for the path
-	rn := n.findRoute(rctx, method, path)
+	var rn *node
+	if n.prioritized {
+		rn = n.findPriorityRoute(rctx, method, path)
+	} else {
+		rn = n.findRoute(rctx, method, path)
+	}
 	if rn == nil {
+		if mn := rctx.methodNotAllowedNode; mn != nil {
+			return mn, mn.endpoints, nil
+		}
 		return nil, nil, nil
 	}
 
@@ -374,15 +780,84 @@ func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, e
 	rctx.URLParams.Keys = append(rctx.URLParams.Keys, rctx.routeParams.Keys...)
 	rctx.URLParams.Values = append(rctx.URLParams.Values, rctx.routeParams.Values...)
 
+	// Record the values of the int params, parsed once
+	if ints := rn.endpoints[method].paramInts; ints != nil {
+		base := len(rctx.URLParams.Keys) - len(rctx.routeParams.Keys)
+		for _, i := range ints {
+			if v, err := strconv.Atoi(rctx.routeParams.Values[i]); err == nil {
+				rctx.URLParams.ints = append(rctx.URLParams.ints, intParam{base + i, v})
+			}
+		}
+	}
+
+	// Record the route configuration in the request lifecycle
+	if cfg := rn.endpoints[method].config; cfg != nil {
+		if rctx.routeConfig == nil {
+			rctx.routeConfig = cfg
+		} else {
+			rctx.routeConfig = mergeRouteConfig(rctx.routeConfig, cfg)
+		}
+	}
+
 	// Record the routing pattern in the request lifecycle
 	if rn.endpoints[method].pattern != "" {
 		rctx.routePattern = rn.endpoints[method].pattern
 		rctx.RoutePatterns = append(rctx.RoutePatterns, rctx.routePattern)
 	}
+	rctx.routeNode = rn
 
 	return rn, rn.endpoints, rn.endpoints[method].handler
 }
 
+// FindPrefixRoute searches the tree for the longest registered route that is an
+// ancestor of the `path`, trying each parent path segment with and without its
+// trailing slash, ie. for "/api/v1/users" it tries "/api/v1/", "/api/v1", "/api/",
+// "/api" and finally "/".
+func (n *node) FindPrefixRoute(rctx *Context, method methodTyp, path string) (*node, endpoints, http.Handler) {
+	for i := len(path) - 2; i >= 0; i-- {
+		if path[i] != '/' {
+			continue
+		}
+		if rn, eps, h := n.FindRoute(rctx, method, path[:i+1]); h != nil {
+			return rn, eps, h
+		}
+		if i == 0 {
+			break
+		}
+		if rn, eps, h := n.FindRoute(rctx, method, path[:i]); h != nil {
+			return rn, eps, h
+		}
+	}
+	return nil, nil, nil
+}
+
+// routeCandidate is the matching route of highest priority found so far by
+// findPriorityRoute, along with its params.
+type routeCandidate struct {
+	node     *node
+	priority int
+	keys     []string
+	values   []string
+}
+
+// findPriorityRoute searches the tree for all the routes matching the path,
+// and returns the node of the route with the highest priority, or the first
+// one found in case of a tie, as findRoute would.
+func (n *node) findPriorityRoute(rctx *Context, method methodTyp, path string) *node {
+	c := &routeCandidate{}
+	rctx.routeCandidate = c
+	n.findRoute(rctx, method, path)
+	rctx.routeCandidate = nil
+
+	if c.node != nil {
+		rctx.methodNotAllowed = false
+		rctx.methodNotAllowedNode = nil
+		rctx.routeParams.Keys = append(rctx.routeParams.Keys[:0], c.keys...)
+		rctx.routeParams.Values = append(rctx.routeParams.Values[:0], c.values...)
+	}
+	return c.node
+}
+
 // Recursive edge traversal by checking all nodeTyp groups along the way.
 // It's like searching through a multi-dimensional radix trie.
 func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
@@ -418,9 +893,20 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 			}
 
 			// serially loop through each node grouped by the tail delimiter
+			var found bool
 			for idx := 0; idx < len(nds); idx++ {
 				xn = nds[idx]
 
+				// a param followed by a delimiter other than '/', ie. "{name}.{ext}",
+				// captures up to the first delimiter, and backtracks to the next
+				// ones when the rest of the path doesn't match
+				if xn.tail != '/' {
+					if fin := xn.findTailRoute(rctx, method, ntyp, xsearch); fin != nil {
+						return fin
+					}
+					continue
+				}
+
 				// label for param nodes is the delimiter byte
 				p := strings.IndexByte(xsearch, xn.tail)
 
@@ -443,13 +929,33 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 
 				rctx.routeParams.Values = append(rctx.routeParams.Values, xsearch[:p])
 				xsearch = xsearch[p:]
+				found = true
 				break
 			}
+			if !found {
+				continue
+			}
 
 		default:
 			// catch-all nodes
-			rctx.routeParams.Values = append(rctx.routeParams.Values, search)
 			xn = nds[0]
+
+			// a wildcard followed by more segments captures the path up to the
+			// remaining segments, backtracking from the longest capture first
+			if !xn.isEmpty() {
+				for p := len(search) - 1; p > 0; p-- {
+					if xn.children[ntStatic].findEdge(search[p]) == nil {
+						continue
+					}
+					rctx.routeParams.Values = append(rctx.routeParams.Values, search[:p])
+					if fin := xn.findRoute(rctx, method, search[p:]); fin != nil {
+						return fin
+					}
+					rctx.routeParams.Values = rctx.routeParams.Values[:len(rctx.routeParams.Values)-1]
+				}
+			}
+
+			rctx.routeParams.Values = append(rctx.routeParams.Values, search)
 			xsearch = ""
 		}
 
@@ -461,17 +967,40 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 		if len(xsearch) == 0 {
 			if xn.isLeaf() {
 				h, _ := xn.endpoints[method]
-				if h != nil && h.handler != nil {
-					rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
-					return xn
-				}
+				// a route whose required headers don't match is skipped, to
+				// keep searching for another route matching the path
+				if h != nil && h.handler != nil && h.matchHeaders(rctx.requestHeader) {
+					c := rctx.routeCandidate
+					if c == nil {
+						rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
+						rctx.routeParams.Values = append(rctx.routeParams.Values, h.paramDefaults...)
+						return xn
+					}
 
-				// flag that the routing context found a route, but not a corresponding
-				// supported method
-				rctx.methodNotAllowed = true
+					// record the candidate, and keep searching for a route
+					// of higher priority
+					if c.node == nil || h.priority > c.priority {
+						c.node, c.priority = xn, h.priority
+						c.keys = append(append(c.keys[:0], rctx.routeParams.Keys...), h.paramKeys...)
+						c.values = append(append(c.values[:0], rctx.routeParams.Values...), h.paramDefaults...)
+					}
+				} else if h == nil || h.handler == nil {
+					// flag that the routing context found a route, but not a corresponding
+					// supported method
+					rctx.methodNotAllowed = true
+					if rctx.methodNotAllowedNode == nil {
+						rctx.methodNotAllowedNode = xn
+					}
+				}
 			}
 		}
 
+		// record the route matching the longest prefix of the path, to debug
+		// the paths matching no route
+		if rctx.debugMatch && xn.isLeaf() && (rctx.matchNode == nil || len(xsearch) < len(rctx.matchRest)) {
+			rctx.matchNode, rctx.matchRest = xn, xsearch
+		}
+
 		// recursively find the next node..
 		fin := xn.findRoute(rctx, method, xsearch)
 		if fin != nil {
@@ -490,6 +1019,36 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 	return nil
 }
 
+// findTailRoute finds the route of the param node `n` followed by a delimiter
+// other than '/', capturing the `search` path up to each of the delimiters in
+// turn, until the rest of the path matches a route.
+func (n *node) findTailRoute(rctx *Context, method methodTyp, ntyp nodeTyp, search string) *node {
+	limit := search
+	if ntyp == ntParam {
+		// avoid a match across path segments
+		if i := strings.IndexByte(limit, '/'); i >= 0 {
+			limit = limit[:i]
+		}
+	}
+
+	for p := strings.IndexByte(limit, n.tail); p >= 0; {
+		if ntyp != ntRegexp || n.rex == nil || n.rex.MatchString(search[:p]) {
+			rctx.routeParams.Values = append(rctx.routeParams.Values, search[:p])
+			if fin := n.findRoute(rctx, method, search[p:]); fin != nil {
+				return fin
+			}
+			rctx.routeParams.Values = rctx.routeParams.Values[:len(rctx.routeParams.Values)-1]
+		}
+
+		q := strings.IndexByte(limit[p+1:], n.tail)
+		if q < 0 {
+			break
+		}
+		p += q + 1
+	}
+	return nil
+}
+
 func (n *node) findEdge(ntyp nodeTyp, label byte) *node {
 	nds := n.children[ntyp]
 	num := len(nds)
@@ -532,6 +1091,45 @@ func (n *node) isLeaf() bool {
 }
 
 func (n *node) findPattern(pattern string) bool {
+	return n.findPatternNode(pattern) != nil
+}
+
+// register records the http methods `method` of a route registered along the
+// node, and reports whether it's the first route registered along the node for
+// the same http methods.
+func (n *node) register(method methodTyp) bool {
+	for _, m := range n.registered {
+		if m == method {
+			return false
+		}
+	}
+	n.registered = append(n.registered, method)
+	return true
+}
+
+// explicitMethods returns the http methods of `method` that have an explicit
+// route along the routing `pattern`, rather than the route of a param default
+// or of an empty wildcard.
+func (n *node) explicitMethods(method methodTyp, pattern string) methodTyp {
+	pn := n.findPatternNode(pattern)
+	if pn == nil {
+		return 0
+	}
+	var explicit methodTyp
+	for _, m := range methodMap {
+		if method&m != m {
+			continue
+		}
+		if ep := pn.endpoints[m]; ep != nil && ep.handler != nil && ep.paramDefaults == nil {
+			explicit |= m
+		}
+	}
+	return explicit
+}
+
+// findPatternNode returns the node of the routing `pattern`, or nil if the
+// pattern isn't in the tree.
+func (n *node) findPatternNode(pattern string) *node {
 	nn := n
 	for _, nds := range nn.children {
 		if len(nds) == 0 {
@@ -565,12 +1163,12 @@ func (n *node) findPattern(pattern string) bool {
 
 		xpattern = pattern[idx:]
 		if len(xpattern) == 0 {
-			return true
+			return n
 		}
 
-		return n.findPattern(xpattern)
+		return n.findPatternNode(xpattern)
 	}
-	return false
+	return nil
 }
 
 func (n *node) routes() []Route {
@@ -585,7 +1183,8 @@ func (n *node) routes() []Route {
 		pats := make(map[string]endpoints, 0)
 
 		for mt, h := range eps {
-			if h.pattern == "" {
+			// Skip the path of a param default, routed along its pattern
+			if h.pattern == "" || h.paramDefaults != nil {
 				continue
 			}
 			p, ok := pats[h.pattern]
@@ -623,6 +1222,75 @@ func (n *node) routes() []Route {
 	return rts
 }
 
+// anyPattern returns the pattern of the endpoint of the `method` on the node,
+// or else of the endpoint of the lowest method.
+func (n *node) anyPattern(method methodTyp) string {
+	if e := n.endpoints[method]; e != nil && e.pattern != "" {
+		return e.pattern
+	}
+	var pattern string
+	var mt methodTyp
+	for m, e := range n.endpoints {
+		if e.pattern != "" && (pattern == "" || m < mt) {
+			pattern, mt = e.pattern, m
+		}
+	}
+	return pattern
+}
+
+// route returns the Route of the endpoints registered along the `pattern` on
+// the node, with the `fullPattern` across sub-routers.
+func (n *node) route(pattern, fullPattern string) *Route {
+	hs := make(map[string]http.Handler, 0)
+	for mt, h := range n.endpoints {
+		if h.handler == nil || h.pattern != pattern {
+			continue
+		}
+		if mt == mALL {
+			hs["*"] = h.handler
+		} else if m := methodTypString(mt); m != "" {
+			hs[m] = h.handler
+		}
+	}
+	return &Route{fullPattern, hs, n.subroutes}
+}
+
+// clone returns a deep copy of the node and its children, with copies of their
+// endpoints. The handlers and subroutes are shared with the node.
+func (n *node) clone() *node {
+	cn := *n
+	cn.registered = append([]methodTyp(nil), n.registered...)
+	if n.endpoints != nil {
+		cn.endpoints = make(endpoints, len(n.endpoints))
+		for mt, ep := range n.endpoints {
+			cep := *ep
+			if ep.accepts != nil {
+				cep.accepts = append([]*acceptHandler(nil), ep.accepts...)
+				if ep.headers != nil {
+					cep.headerFallback = http.HandlerFunc(cep.negotiate)
+				} else {
+					cep.handler = http.HandlerFunc(cep.negotiate)
+				}
+			}
+			if ep.headers != nil {
+				cep.headers = append([]*headerHandler(nil), ep.headers...)
+				cep.handler = http.HandlerFunc(cep.serveHeaders)
+			}
+			cn.endpoints[mt] = &cep
+		}
+	}
+	for t, nds := range n.children {
+		if nds == nil {
+			continue
+		}
+		cn.children[t] = make(nodes, len(nds))
+		for i, child := range nds {
+			cn.children[t][i] = child.clone()
+		}
+	}
+	return &cn
+}
+
 func (n *node) walk(fn func(eps endpoints, subroutes Routes) bool) bool {
 	// Visit the leaf values if any
 	if (n.endpoints != nil || n.subroutes != nil) && fn(n.endpoints, n.subroutes) {
@@ -652,7 +1320,7 @@ func patNextSegment(pattern string) (nodeTyp, string, string, byte, int, int) {
 
 	// Sanity check
 	if ps >= 0 && ws >= 0 && ws < ps {
-		panic("chi: wildcard '*' must be the last pattern in a route, otherwise use a '{param}'")
+		panic("chi: wildcard '*' must be the last pattern in a route, or only be followed by static segments, otherwise use a '{param}'")
 	}
 
 	var tail byte = '/' // Default endpoint tail to / byte
@@ -686,11 +1354,22 @@ func patNextSegment(pattern string) (nodeTyp, string, string, byte, int, int) {
 			tail = pattern[pe]
 		}
 
+		// Trim the default value of a param, see patDefaultParam
+		if idx := strings.IndexAny(key, ":="); idx >= 0 && key[idx] == '=' {
+			if pe < len(pattern) {
+				panic(fmt.Sprintf("chi: route param '%s' with a default value must be the last segment of a route", key[:idx]))
+			}
+			key = key[:idx]
+		}
+
 		var rexpat string
 		if idx := strings.Index(key, ":"); idx >= 0 {
 			nt = ntRegexp
 			rexpat = key[idx+1:]
 			key = key[:idx]
+			if rexpat == "int" {
+				rexpat = intParamRegexp
+			}
 		}
 
 		if len(rexpat) > 0 {
@@ -705,11 +1384,370 @@ func patNextSegment(pattern string) (nodeTyp, string, string, byte, int, int) {
 		return nt, key, rexpat, tail, ps, pe
 	}
 
+	// Wildcard pattern followed by more static segments
+	if ws+1 < len(pattern) && pattern[ws+1] == '/' {
+		if strings.IndexByte(pattern[ws+1:], '*') >= 0 {
+			panic("chi: wildcard '*' can only be used once in a route")
+		}
+		return ntCatchAll, "*", "", 0, ws, ws + 1
+	}
+
 	// Wildcard pattern as finale
 	// TODO: should we panic if there is stuff after the * ???
 	return ntCatchAll, "*", "", 0, ws, len(pattern)
 }
 
+// patDefaultParam returns the path of a `pattern` ending with a param that has
+// a default value, such as "/page/{n=1}", without the param segment, along with
+// the default value. The param default is only supported on a plain param that
+// takes up the last segment of the pattern.
+func patDefaultParam(pattern string) (string, string, bool) {
+	if len(pattern) == 0 || pattern[len(pattern)-1] != '}' {
+		return "", "", false
+	}
+	i := strings.LastIndexByte(pattern, '/')
+	seg := pattern[i+1:]
+	if len(seg) < 2 || seg[0] != '{' {
+		return "", "", false
+	}
+	key := seg[1 : len(seg)-1]
+	idx := strings.IndexAny(key, ":={}")
+	if idx < 0 || key[idx] != '=' {
+		return "", "", false
+	}
+
+	path := pattern[:i]
+	if path == "" {
+		path = "/"
+	}
+	return path, key[idx+1:], true
+}
+
+// PatternToRegexp converts a routing `pattern` into a regular expression that
+// matches the same request paths as the router, along with the names of the
+// params captured by its groups in order, ie. to export the routes of a router
+// to an API gateway. The wildcard is named "*", and the groups of a regexp param
+// are made non-capturing, while they're expected not to match the delimiter
+// that follows the param in the pattern. The default value of a param isn't part of the
+// regular expression, whose group for the param captures an empty string when
+// the request path has no segment for it.
+//
+// For example, the "/users/{id}/*" pattern converts to "^/users/([^/]+)/(.*)$",
+// along with the "id" and "*" param names.
+func PatternToRegexp(pattern string) (*regexp.Regexp, []string) {
+	var expr string
+	var names []string
+
+	_, _, optional := patDefaultParam(pattern)
+
+	var tail string
+	search := pattern
+	for len(search) > 0 {
+		ptyp, key, rexpat, ptail, ps, pe := patNextSegment(search)
+		if ptyp == ntStatic {
+			expr += regexp.QuoteMeta(search)
+			break
+		}
+		expr += regexp.QuoteMeta(search[:ps])
+		if optional && pe == len(search) {
+			// The segment of a param default is optional, along with its
+			// leading slash unless it's the root path
+			tail = ")?"
+			if expr == "/" {
+				expr += "(?:"
+			} else {
+				expr = strings.TrimSuffix(expr, "/") + "(?:/"
+			}
+		}
+
+		switch ptyp {
+		case ntParam, ntRegexp:
+			// A param captures up to the next delimiter, and only matches an
+			// empty value when followed by more of the path
+			class := "[^/]"
+			quantifier := "+"
+			if pe < len(search) {
+				quantifier = "*"
+			}
+			if ptail != '/' {
+				// up to the first delimiter that lets the rest match
+				quantifier += "?"
+			}
+			if ptyp == ntRegexp {
+				expr += "(" + nonCapturing(rexpat) + ")"
+			} else {
+				expr += "(" + class + quantifier + ")"
+			}
+		case ntCatchAll:
+			if pe < len(search) {
+				expr += "(.+)"
+			} else {
+				expr += "(.*)"
+			}
+		}
+		names = append(names, key)
+		if ptyp == ntCatchAll && pe == len(search) {
+			// Any trailing characters of a terminal wildcard are ignored
+			break
+		}
+		search = search[pe:]
+	}
+
+	return regexp.MustCompile("^" + expr + tail + "$"), names
+}
+
+// nonCapturing returns the regexp param pattern `rexpat` without its anchors,
+// and with its capturing groups made non-capturing.
+func nonCapturing(rexpat string) string {
+	rexpat = strings.TrimSuffix(strings.TrimPrefix(rexpat, "^"), "$")
+	re, err := syntax.Parse(rexpat, syntax.Perl)
+	if err != nil {
+		panic(fmt.Sprintf("chi: invalid regexp pattern '%s' in route param", rexpat))
+	}
+	var uncapture func(re *syntax.Regexp) *syntax.Regexp
+	uncapture = func(re *syntax.Regexp) *syntax.Regexp {
+		for i, sub := range re.Sub {
+			re.Sub[i] = uncapture(sub)
+		}
+		if re.Op == syntax.OpCapture {
+			return re.Sub[0]
+		}
+		return re
+	}
+	return "(?:" + uncapture(re).String() + ")"
+}
+
+// Conflict is a pair of routes whose patterns both match some request path
+// for the same http methods, see Mux#Validate.
+type Conflict struct {
+	// Full routing patterns of the routes, across sub-routers, in order
+	Patterns [2]string
+
+	// The http methods handled by both routes, sorted
+	Methods []string
+
+	// A request path matched by both patterns, ie. "/users/new" for the
+	// "/users/{id}" and "/users/new" patterns
+	Path string
+
+	// Precedence reports whether the path is routed by the precedence of the
+	// segment types where the patterns differ, ie. "/users/new" over
+	// "/users/{id}", which is often intended, rather than to the regexp param
+	// registered first
+	Precedence bool
+}
+
+func (c Conflict) String() string {
+	return "'" + c.Patterns[0] + "' and '" + c.Patterns[1] + "' both match " +
+		strings.Join(c.Methods, ",") + " " + c.Path
+}
+
+// overlapPattern is a routing pattern compiled once for the search of its
+// overlaps with other patterns, see patOverlap.
+type overlapPattern struct {
+	pattern string
+	re      *regexp.Regexp
+
+	// static prefix of the pattern, up to its first param or wildcard
+	prefix string
+
+	// static segments of the pattern, tried as param values
+	segments []string
+}
+
+func newOverlapPattern(pattern string) *overlapPattern {
+	re, _ := PatternToRegexp(pattern)
+	p := &overlapPattern{pattern: pattern, re: re, prefix: pattern}
+	if i := strings.IndexAny(pattern, "{*"); i >= 0 {
+		p.prefix = pattern[:i]
+	}
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg != "" && !strings.ContainsAny(seg, "{}*") {
+			p.segments = append(p.segments, seg)
+		}
+	}
+	return p
+}
+
+// patOverlap returns a request path matched by both patterns `a` and `b`, as
+// found by trying sample values for the params and wildcards of each pattern,
+// made of "1", "x", the static segments of both patterns and the shortest
+// match of a regexp param. It reports false when no path is found, right away
+// for the patterns whose static prefixes differ.
+func patOverlap(a, b *overlapPattern) (string, bool) {
+	if !strings.HasPrefix(a.prefix, b.prefix) && !strings.HasPrefix(b.prefix, a.prefix) {
+		return "", false
+	}
+
+	samples := []string{"1", "x"}
+	seen := map[string]bool{"1": true, "x": true}
+	for _, segs := range [][]string{a.segments, b.segments} {
+		for _, seg := range segs {
+			if !seen[seg] {
+				seen[seg] = true
+				samples = append(samples, seg)
+			}
+		}
+	}
+
+	for _, pattern := range []string{a.pattern, b.pattern} {
+		for _, path := range patSamplePaths(pattern, samples) {
+			if a.re.MatchString(path) && b.re.MatchString(path) {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// patPrecedence reports whether the paths matched by both patterns `a` and
+// `b` are routed by the precedence of the segment types where the patterns
+// differ, being a static segment over a regexp param, over a param, over a
+// wildcard, rather than by the order of registration of regexp params.
+func patPrecedence(a, b string) bool {
+	i := longestPrefix(a, b)
+	// Differing param keys or regexps in the same position are told apart
+	// from the start of the param
+	if ps := strings.LastIndex(a[:i], "{"); ps >= 0 && strings.LastIndex(a[:i], "}") < ps {
+		i = ps
+	}
+	typ := func(search string) nodeTyp {
+		ptyp, _, _, _, ps, _ := patNextSegment(search)
+		if ps > 0 {
+			return ntStatic
+		}
+		return ptyp
+	}
+	return typ(a[i:]) != typ(b[i:])
+}
+
+// patSamplePaths returns the paths of the `pattern` with each of its params
+// and wildcards replaced by the `samples`, up to a maximum number of paths.
+func patSamplePaths(pattern string, samples []string) []string {
+	const maxPaths = 256
+
+	paths := []string{""}
+	search := pattern
+	for len(search) > 0 {
+		ptyp, _, rexpat, _, ps, pe := patNextSegment(search)
+		if ptyp == ntStatic {
+			ps, pe = len(search), len(search)
+		}
+
+		var values []string
+		switch ptyp {
+		case ntStatic:
+			values = []string{""}
+		case ntRegexp:
+			values = append([]string{regexpSample(rexpat)}, samples...)
+		case ntCatchAll:
+			values = append([]string{"", "x/y"}, samples...)
+		default:
+			values = samples
+		}
+
+		var next []string
+		for _, path := range paths {
+			for _, v := range values {
+				if len(next) < maxPaths {
+					next = append(next, path+search[:ps]+v)
+				}
+			}
+		}
+		paths = next
+		search = search[pe:]
+	}
+	return paths
+}
+
+// regexpSample returns the shortest string matched by the regexp param
+// pattern `rexpat`, or an empty string if none is found.
+func regexpSample(rexpat string) string {
+	rexpat = strings.TrimSuffix(strings.TrimPrefix(rexpat, "^"), "$")
+	re, err := syntax.Parse(rexpat, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	var sample func(re *syntax.Regexp) string
+	sample = func(re *syntax.Regexp) string {
+		switch re.Op {
+		case syntax.OpLiteral:
+			return string(re.Rune)
+		case syntax.OpCharClass:
+			if len(re.Rune) > 0 {
+				return string(re.Rune[0])
+			}
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+			return "x"
+		case syntax.OpCapture, syntax.OpPlus:
+			return sample(re.Sub[0])
+		case syntax.OpRepeat:
+			return strings.Repeat(sample(re.Sub[0]), re.Min)
+		case syntax.OpConcat:
+			var s string
+			for _, sub := range re.Sub {
+				s += sample(sub)
+			}
+			return s
+		case syntax.OpAlternate:
+			return sample(re.Sub[0])
+		}
+		return ""
+	}
+	return sample(re.Simplify())
+}
+
+// patValidate panics with the position of the first malformed param in the
+// `pattern`, ie. an unbalanced brace, a param without a name, or a param
+// followed by another param, which can't be told apart in a URL. It panics as
+// well on a misplaced wildcard or param default, a duplicate param key or an
+// invalid param regexp, so the pattern is checked before the tree is modified.
+func patValidate(pattern string) {
+	cc, ps := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			if cc == 0 {
+				ps = i
+			}
+			cc++
+		case '}':
+			if cc == 0 {
+				panic(fmt.Sprintf("chi: route param opening delimiter '{' is missing in '%s' at position %d", pattern, i))
+			}
+			cc--
+			if cc > 0 {
+				continue
+			}
+			key := pattern[ps+1 : i]
+			if idx := strings.IndexAny(key, ":="); idx == 0 && key[0] == '=' || idx < 0 && key == "" {
+				panic(fmt.Sprintf("chi: route param name is empty in '%s' at position %d", pattern, ps))
+			}
+			if i+1 < len(pattern) && pattern[i+1] == '{' {
+				panic(fmt.Sprintf("chi: route param '%s' must be followed by a static character, not another param, in '%s' at position %d", pattern[ps:i+1], pattern, i+1))
+			}
+		}
+	}
+	if cc > 0 {
+		panic(fmt.Sprintf("chi: route param closing delimiter '}' is missing in '%s' at position %d", pattern, ps))
+	}
+
+	patParamKeys(pattern)
+	for pat := pattern; ; {
+		ptyp, _, rexpat, _, _, e := patNextSegment(pat)
+		if ptyp == ntStatic {
+			return
+		}
+		if ptyp == ntRegexp {
+			if _, err := regexp.Compile(rexpat); err != nil {
+				panic(fmt.Sprintf("chi: invalid regexp pattern '%s' in route param", rexpat))
+			}
+		}
+		pat = pat[e:]
+	}
+}
+
 func patParamKeys(pattern string) []string {
 	pat := pattern
 	paramKeys := []string{}
@@ -728,6 +1766,25 @@ func patParamKeys(pattern string) []string {
 	}
 }
 
+// intParamRegexp is the regexp of a param with the int type hint, ie. "{id:int}".
+const intParamRegexp = "[0-9]+"
+
+// patIntParams returns the indexes of the param keys of the `pattern` that
+// have the int type hint, ie. 0 for "/users/{id:int}", or nil if none has.
+func patIntParams(pattern string) []int {
+	var ints []int
+	for i := 0; ; i++ {
+		ptyp, _, rexpat, _, _, e := patNextSegment(pattern)
+		if ptyp == ntStatic {
+			return ints
+		}
+		if ptyp == ntRegexp && rexpat == "^"+intParamRegexp+"$" {
+			ints = append(ints, i)
+		}
+		pattern = pattern[e:]
+	}
+}
+
 // longestPrefix finds the length of the shared prefix
 // of two strings
 func longestPrefix(k1, k2 string) int {
@@ -761,6 +1818,17 @@ func (ns nodes) Len() int           { return len(ns) }
 func (ns nodes) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
 func (ns nodes) Less(i, j int) bool { return ns[i].label < ns[j].label }
 
+// insertSorted inserts the static node `child` in the list sorted by label,
+// with a binary search of its position rather than sorting the list again,
+// which keeps the registration of many sibling routes fast.
+func (ns nodes) insertSorted(child *node) nodes {
+	i := sort.Search(len(ns), func(i int) bool { return ns[i].label >= child.label })
+	ns = append(ns, nil)
+	copy(ns[i+1:], ns[i:])
+	ns[i] = child
+	return ns
+}
+
 // tailSort pushes nodes with '/' as the tail to the end of the list for param nodes.
 // The list order determines the traversal order.
 func (ns nodes) tailSort() {
@@ -804,41 +1872,91 @@ type WalkFunc func(method string, route string, handler http.Handler, middleware
 
 // Walk walks any router tree that implements Routes interface.
 func Walk(r Routes, walkFn WalkFunc) error {
-	return walk(r, walkFn, "")
+	return walk(r, walkFn, WalkOptions{}, "")
+}
+
+// WalkOptions controls the routes visited by WalkWith.
+type WalkOptions struct {
+	// SynthesizeHead visits a HEAD route with the GET handler for each GET
+	// route without a HEAD handler, as served by the GetHead middleware.
+	SynthesizeHead bool
+
+	// SynthesizeOptions visits an OPTIONS route for each route without an
+	// OPTIONS handler, with a handler responding with the Allow header of the
+	// http methods of the route, including the synthesized HEAD route if any.
+	SynthesizeOptions bool
+}
+
+// WalkWith walks any router tree that implements Routes interface in the same
+// manner as Walk, along with the routes synthesized as per the `opts`.
+func WalkWith(r Routes, opts WalkOptions, walkFn WalkFunc) error {
+	return walk(r, walkFn, opts, "")
 }
 
-func walk(r Routes, walkFn WalkFunc, parentRoute string, parentMw ...func(http.Handler) http.Handler) error {
+func walk(r Routes, walkFn WalkFunc, opts WalkOptions, parentRoute string, parentMw ...func(http.Handler) http.Handler) error {
 	for _, route := range r.Routes() {
 		mws := make([]func(http.Handler) http.Handler, len(parentMw))
 		copy(mws, parentMw)
 		mws = append(mws, r.Middlewares()...)
 
 		if route.SubRoutes != nil {
-			if err := walk(route.SubRoutes, walkFn, parentRoute+route.Pattern, mws...); err != nil {
+			if err := walk(route.SubRoutes, walkFn, opts, parentRoute+route.Pattern, mws...); err != nil {
 				return err
 			}
 			continue
 		}
 
+		fullRoute := parentRoute + route.Pattern
+		visit := func(method string, handler http.Handler) error {
+			if chain, ok := handler.(*ChainHandler); ok {
+				return walkFn(method, fullRoute, chain.Endpoint, append(mws, chain.Middlewares...)...)
+			}
+			return walkFn(method, fullRoute, handler, mws...)
+		}
+
 		for method, handler := range route.Handlers {
 			if method == "*" {
 				// Ignore a "catchAll" method, since we pass down all the specific methods for each route.
 				continue
 			}
+			if err := visit(method, handler); err != nil {
+				return err
+			}
+		}
 
-			fullRoute := parentRoute + route.Pattern
+		synthesizeHead := opts.SynthesizeHead && route.Handlers["GET"] != nil && route.Handlers["HEAD"] == nil
+		if synthesizeHead {
+			if err := visit("HEAD", route.Handlers["GET"]); err != nil {
+				return err
+			}
+		}
 
-			if chain, ok := handler.(*ChainHandler); ok {
-				if err := walkFn(method, fullRoute, chain.Endpoint, append(mws, chain.Middlewares...)...); err != nil {
-					return err
-				}
-			} else {
-				if err := walkFn(method, fullRoute, handler, mws...); err != nil {
-					return err
+		if opts.SynthesizeOptions && route.Handlers["OPTIONS"] == nil {
+			methods := []string{"OPTIONS"}
+			for method := range route.Handlers {
+				if method != "*" {
+					methods = append(methods, method)
 				}
 			}
+			if synthesizeHead {
+				methods = append(methods, "HEAD")
+			}
+			sort.Strings(methods)
+			if err := visit("OPTIONS", allowHandler(methods)); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
+
+// allowHandler responds to the OPTIONS requests with the Allow header of the
+// http `methods`, see WalkOptions.SynthesizeOptions.
+func allowHandler(methods []string) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
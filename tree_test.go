@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -358,6 +362,269 @@ func TestTreeRegexMatchWholeParam(t *testing.T) {
 	}
 }
 
+func TestTreeFindRouteMethodNotAllowed(t *testing.T) {
+	hStub1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	hStub2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tr := &node{}
+	tr.InsertRoute(mPOST, "/articles", hStub1)
+	tr.InsertRoute(mPUT, "/articles/{id}", hStub2)
+
+	tests := []struct {
+		m         methodTyp
+		r         string
+		h         http.Handler
+		eps       bool
+		mna       bool
+		allowed   methodTyp
+		unallowed methodTyp
+	}{
+		{m: mPOST, r: "/articles", h: hStub1, eps: true, allowed: mPOST},
+		{m: mGET, r: "/articles", eps: true, mna: true, allowed: mPOST, unallowed: mGET},
+		{m: mGET, r: "/articles/1", eps: true, mna: true, allowed: mPUT, unallowed: mGET},
+		{m: mGET, r: "/nope"},
+		{m: mGET, r: "/articles/1/nope"},
+	}
+
+	for i, tt := range tests {
+		rctx := NewRouteContext()
+		_, eps, h := tr.FindRoute(rctx, tt.m, tt.r)
+
+		if fmt.Sprintf("%v", tt.h) != fmt.Sprintf("%v", h) {
+			t.Errorf("input [%d]: find '%s' expecting handler:%v , got:%v", i, tt.r, tt.h, h)
+		}
+		if (eps != nil) != tt.eps {
+			t.Fatalf("input [%d]: find '%s' expecting endpoints:%v , got:%v", i, tt.r, tt.eps, eps)
+		}
+		if rctx.methodNotAllowed != tt.mna {
+			t.Errorf("input [%d]: find '%s' expecting methodNotAllowed:%v , got:%v", i, tt.r, tt.mna, rctx.methodNotAllowed)
+		}
+		if eps == nil {
+			continue
+		}
+		if eps[tt.allowed] == nil || eps[tt.allowed].handler == nil {
+			t.Errorf("input [%d]: find '%s' expecting endpoint for method %d", i, tt.r, tt.allowed)
+		}
+		if tt.unallowed != 0 && eps[tt.unallowed] != nil {
+			t.Errorf("input [%d]: find '%s' expecting no endpoint for method %d", i, tt.r, tt.unallowed)
+		}
+	}
+}
+
+func TestTreeWildcardSegments(t *testing.T) {
+	hStub1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	hStub2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	hStub3 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tr := &node{}
+	tr.InsertRoute(mGET, "/files/*/meta", hStub1)
+	tr.InsertRoute(mGET, "/files/*", hStub2)
+	tr.InsertRoute(mGET, "/files/*/meta/size", hStub3)
+
+	tests := []struct {
+		r string       // input request path
+		h http.Handler // output matched handler
+		k []string     // output param keys
+		v []string     // output param values
+	}{
+		{r: "/files/a/meta", h: hStub1, k: []string{"*"}, v: []string{"a"}},
+		{r: "/files/a/b/meta", h: hStub1, k: []string{"*"}, v: []string{"a/b"}},
+		{r: "/files/a/meta/b/meta", h: hStub1, k: []string{"*"}, v: []string{"a/meta/b"}},
+		{r: "/files/a/b/meta/size", h: hStub3, k: []string{"*"}, v: []string{"a/b"}},
+		{r: "/files/meta", h: hStub2, k: []string{"*"}, v: []string{"meta"}},
+		{r: "/files/a/b", h: hStub2, k: []string{"*"}, v: []string{"a/b"}},
+		{r: "/files/a/b/meta/", h: hStub2, k: []string{"*"}, v: []string{"a/b/meta/"}},
+	}
+
+	for i, tt := range tests {
+		rctx := NewRouteContext()
+		_, handlers, _ := tr.FindRoute(rctx, mGET, tt.r)
+
+		var handler http.Handler
+		if methodHandler, ok := handlers[mGET]; ok {
+			handler = methodHandler.handler
+		}
+
+		paramKeys := rctx.routeParams.Keys
+		paramValues := rctx.routeParams.Values
+
+		if fmt.Sprintf("%v", tt.h) != fmt.Sprintf("%v", handler) {
+			t.Errorf("input [%d]: find '%s' expecting handler:%v , got:%v", i, tt.r, tt.h, handler)
+		}
+		if !stringSliceEqual(tt.k, paramKeys) {
+			t.Errorf("input [%d]: find '%s' expecting paramKeys:(%d)%v , got:(%d)%v", i, tt.r, len(tt.k), tt.k, len(paramKeys), paramKeys)
+		}
+		if !stringSliceEqual(tt.v, paramValues) {
+			t.Errorf("input [%d]: find '%s' expecting paramValues:(%d)%v , got:(%d)%v", i, tt.r, len(tt.v), tt.v, len(paramValues), paramValues)
+		}
+	}
+}
+
+func TestTreeWildcardSegmentsPanic(t *testing.T) {
+	patterns := []string{"/files/*/{id}", "/files/*/meta/*"}
+	for _, pattern := range patterns {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expecting a panic for pattern '%s'", pattern)
+				}
+			}()
+			tr := &node{}
+			tr.InsertRoute(mGET, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		}()
+	}
+}
+
+func TestTreeParamTailBacktrack(t *testing.T) {
+	hStub1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	hStub2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	hStub3 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tr := &node{}
+	tr.InsertRoute(mGET, "/images/{name}.{ext}", hStub1)
+	tr.InsertRoute(mGET, "/thumbs/{name}.png", hStub2)
+	tr.InsertRoute(mGET, "/videos/{name}.{ext:[a-z0-9]+}", hStub3)
+
+	tests := []struct {
+		r string       // input request path
+		h http.Handler // output matched handler
+		k []string     // output param keys
+		v []string     // output param values
+	}{
+		{r: "/images/photo.jpg", h: hStub1, k: []string{"name", "ext"}, v: []string{"photo", "jpg"}},
+		{r: "/images/archive.tar.gz", h: hStub1, k: []string{"name", "ext"}, v: []string{"archive", "tar.gz"}},
+		{r: "/images/.jpg", h: hStub1, k: []string{"name", "ext"}, v: []string{"", "jpg"}},
+		{r: "/images/photo.", h: nil, k: nil, v: nil},
+		{r: "/images/photo", h: nil, k: nil, v: nil},
+		{r: "/images/a.b/c", h: nil, k: nil, v: nil},
+		{r: "/thumbs/photo.png", h: hStub2, k: []string{"name"}, v: []string{"photo"}},
+		{r: "/thumbs/photo.2x.png", h: hStub2, k: []string{"name"}, v: []string{"photo.2x"}},
+		{r: "/thumbs/a.png.png", h: hStub2, k: []string{"name"}, v: []string{"a.png"}},
+		{r: "/thumbs/photo.png.jpg", h: nil, k: nil, v: nil},
+		{r: "/videos/clip.mp4", h: hStub3, k: []string{"name", "ext"}, v: []string{"clip", "mp4"}},
+		{r: "/videos/archive.tar.gz", h: hStub3, k: []string{"name", "ext"}, v: []string{"archive.tar", "gz"}},
+		{r: "/videos/clip.MP4", h: nil, k: nil, v: nil},
+	}
+
+	for i, tt := range tests {
+		rctx := NewRouteContext()
+		_, handlers, _ := tr.FindRoute(rctx, mGET, tt.r)
+
+		var handler http.Handler
+		if methodHandler, ok := handlers[mGET]; ok {
+			handler = methodHandler.handler
+		}
+
+		paramKeys := rctx.routeParams.Keys
+		paramValues := rctx.routeParams.Values
+
+		if fmt.Sprintf("%v", tt.h) != fmt.Sprintf("%v", handler) {
+			t.Errorf("input [%d]: find '%s' expecting handler:%v , got:%v", i, tt.r, tt.h, handler)
+		}
+		if !stringSliceEqual(tt.k, paramKeys) {
+			t.Errorf("input [%d]: find '%s' expecting paramKeys:(%d)%v , got:(%d)%v", i, tt.r, len(tt.k), tt.k, len(paramKeys), paramKeys)
+		}
+		if !stringSliceEqual(tt.v, paramValues) {
+			t.Errorf("input [%d]: find '%s' expecting paramValues:(%d)%v , got:(%d)%v", i, tt.r, len(tt.v), tt.v, len(paramValues), paramValues)
+		}
+	}
+}
+
+func TestRegisterMethodInvalid(t *testing.T) {
+	for _, method := range []string{"PURGE\r\nX-Evil: 1", "PUR GE", "PURGE\x00", "PURGE:", "(PURGE)", "PÜRGE"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expecting a panic for the method %q", method)
+				}
+			}()
+			RegisterMethod(method)
+		}()
+		if _, ok := methodMap[strings.ToUpper(method)]; ok {
+			t.Fatalf("expecting the method %q not to be registered", method)
+		}
+	}
+
+	for _, method := range []string{"GET", "M-SEARCH", "X_CUSTOM.1", "!#$%&'*+-.^_`|~"} {
+		if !ValidMethod(method) {
+			t.Errorf("expecting %q to be a valid method", method)
+		}
+	}
+}
+
+func TestRegisterMethodBits(t *testing.T) {
+	RegisterMethod("PURGE")
+	RegisterMethod("LINK")
+
+	seen := mSTUB
+	for m, mt := range methodMap {
+		if mt&seen != 0 {
+			t.Fatalf("method %s overlaps the bits of another method", m)
+		}
+		if mALL&mt != mt {
+			t.Fatalf("method %s is missing from mALL", m)
+		}
+		seen |= mt
+	}
+}
+
+func TestTreeStaticChildrenSorted(t *testing.T) {
+	hStub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tr := &node{}
+	labels := "zamqb0Z9_~-y"
+	for i := 0; i < len(labels); i++ {
+		tr.InsertRoute(mGET, "/"+string(labels[i])+"/item", hStub)
+	}
+
+	var check func(n *node)
+	check = func(n *node) {
+		nds := n.children[ntStatic]
+		for i := 1; i < len(nds); i++ {
+			if nds[i-1].label >= nds[i].label {
+				t.Fatalf("expecting static children sorted by label, got '%c' before '%c'", nds[i-1].label, nds[i].label)
+			}
+		}
+		for _, nds := range n.children {
+			for _, nn := range nds {
+				check(nn)
+			}
+		}
+	}
+	check(tr)
+
+	for i := 0; i < len(labels); i++ {
+		path := "/" + string(labels[i]) + "/item"
+		if _, _, h := tr.FindRoute(NewRouteContext(), mGET, path); h == nil {
+			t.Fatalf("expecting a handler for '%s'", path)
+		}
+	}
+}
+
+func TestPatDefaultParam(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		value   string
+		ok      bool
+	}{
+		{"/page/{n=1}", "/page", "1", true},
+		{"/{lang=en}", "/", "en", true},
+		{"/users/{id}/posts/{sort=}", "/users/{id}/posts", "", true},
+		{"/page/{n}", "", "", false},
+		{"/page/{n:[0-9=]+}", "", "", false},
+		{"/page/p{n=1}", "", "", false},
+		{"/page", "", "", false},
+	}
+
+	for _, tt := range tests {
+		path, value, ok := patDefaultParam(tt.pattern)
+		if path != tt.path || value != tt.value || ok != tt.ok {
+			t.Errorf("%s: expecting '%s' '%s' %v, got '%s' '%s' %v", tt.pattern, tt.path, tt.value, tt.ok, path, value, ok)
+		}
+	}
+}
+
 func TestTreeFindPattern(t *testing.T) {
 	hStub1 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 	hStub2 := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
@@ -453,6 +720,43 @@ func BenchmarkTreeGet(b *testing.B) {
 	}
 }
 
+func BenchmarkTreeWide(b *testing.B) {
+	hStub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	const labels = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	paths := make([]string, 1000)
+	for i := range paths {
+		// Spread the siblings over many distinct labels, in no particular order
+		paths[i] = fmt.Sprintf("/%c%d", labels[i*37%len(labels)], i)
+	}
+
+	b.Run("insert", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			tr := &node{}
+			for _, path := range paths {
+				tr.InsertRoute(mGET, path, hStub)
+			}
+		}
+	})
+
+	b.Run("find", func(b *testing.B) {
+		tr := &node{}
+		for _, path := range paths {
+			tr.InsertRoute(mGET, path, hStub)
+		}
+		mctx := NewRouteContext()
+
+		b.ReportAllocs()
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			mctx.Reset()
+			tr.FindRoute(mctx, mGET, paths[i%len(paths)])
+		}
+	})
+}
+
 func TestWalker(t *testing.T) {
 	r := bigMux()
 
@@ -465,3 +769,124 @@ func TestWalker(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestWalkWithSynthesizeHead(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Get("/articles", h)
+	r.Head("/articles", h)
+	r.Post("/articles", h)
+	r.Route("/admin", func(r Router) {
+		r.Get("/users", h)
+	})
+
+	walked := func(opts WalkOptions) []string {
+		var routes []string
+		err := WalkWith(r, opts, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			routes = append(routes, method+" "+route)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(routes)
+		return routes
+	}
+
+	without := []string{"GET /", "GET /admin/*/users", "GET /articles", "HEAD /articles", "POST /articles"}
+	if routes := walked(WalkOptions{}); !reflect.DeepEqual(routes, without) {
+		t.Fatalf("expecting %v, got %v", without, routes)
+	}
+	with := []string{"GET /", "GET /admin/*/users", "GET /articles", "HEAD /", "HEAD /admin/*/users", "HEAD /articles", "POST /articles"}
+	if routes := walked(WalkOptions{SynthesizeHead: true}); !reflect.DeepEqual(routes, with) {
+		t.Fatalf("expecting %v, got %v", with, routes)
+	}
+}
+
+func TestWalkWithSynthesizeOptions(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := NewRouter()
+	r.Get("/", h)
+	r.Get("/articles", h)
+	r.Post("/articles", h)
+	r.Options("/articles", h)
+	r.Route("/admin", func(r Router) {
+		r.Delete("/users/{id}", h)
+	})
+
+	walked := func(opts WalkOptions) ([]string, map[string]string) {
+		var routes []string
+		allow := map[string]string{}
+		err := WalkWith(r, opts, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			routes = append(routes, method+" "+route)
+			if method == "OPTIONS" {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("OPTIONS", "/", nil))
+				allow[route] = w.Header().Get("Allow")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(routes)
+		return routes, allow
+	}
+
+	without := []string{"DELETE /admin/*/users/{id}", "GET /", "GET /articles", "OPTIONS /articles", "POST /articles"}
+	if routes, _ := walked(WalkOptions{}); !reflect.DeepEqual(routes, without) {
+		t.Fatalf("expecting %v, got %v", without, routes)
+	}
+
+	with := []string{"DELETE /admin/*/users/{id}", "GET /", "GET /articles", "OPTIONS /", "OPTIONS /admin/*/users/{id}", "OPTIONS /articles", "POST /articles"}
+	routes, allow := walked(WalkOptions{SynthesizeOptions: true})
+	if !reflect.DeepEqual(routes, with) {
+		t.Fatalf("expecting %v, got %v", with, routes)
+	}
+	if allow["/"] != "GET, OPTIONS" || allow["/admin/*/users/{id}"] != "DELETE, OPTIONS" {
+		t.Fatalf("expecting the Allow header of the methods of the routes, got %v", allow)
+	}
+	if allow["/articles"] != "" {
+		t.Fatalf("expecting the OPTIONS handler of the route, got Allow '%s'", allow["/articles"])
+	}
+
+	// The synthesized HEAD routes are allowed as well
+	_, allow = walked(WalkOptions{SynthesizeHead: true, SynthesizeOptions: true})
+	if allow["/"] != "GET, HEAD, OPTIONS" {
+		t.Fatalf("expecting 'GET, HEAD, OPTIONS', got '%s'", allow["/"])
+	}
+}
+
+func TestTreeMalformedPatternPanic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		msg     string
+	}{
+		{"/{", "chi: route param closing delimiter '}' is missing in '/{' at position 1"},
+		{"/users/{id/posts", "chi: route param closing delimiter '}' is missing in '/users/{id/posts' at position 7"},
+		{"/users/{id:[0-9]{2}", "chi: route param closing delimiter '}' is missing in '/users/{id:[0-9]{2}' at position 7"},
+		{"/users/id}", "chi: route param opening delimiter '{' is missing in '/users/id}' at position 9"},
+		{"/users/{}", "chi: route param name is empty in '/users/{}' at position 7"},
+		{"/page/{=1}", "chi: route param name is empty in '/page/{=1}' at position 6"},
+		{"/{a}{b}", "chi: route param '{a}' must be followed by a static character, not another param, in '/{a}{b}' at position 4"},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if rvr := recover(); rvr != tt.msg {
+					t.Errorf("%s: expecting panic '%s', got '%v'", tt.pattern, tt.msg, rvr)
+				}
+			}()
+			tr := &node{}
+			tr.InsertRoute(mGET, tt.pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		}()
+	}
+
+	// Well-formed params with nested braces and anonymous regexps
+	tr := &node{}
+	tr.InsertRoute(mGET, "/users/{id:[0-9]{2}}/{:[a-z]+}-{slug}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+}